@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// The admin listener is bound to localhost when only a port is given.
+const defaultAdminHost = "127.0.0.1"
+
+// The PROFILE command can be disabled, the profiles are also available on the
+// admin listener.
+var profileCommand = true
+
+// A set served by the process, for the stats of the admin listener.
+type adminSet struct {
+	listen string
+	ctx    *context
+}
+
+type adminSetStats struct {
+	Set         string `json:"set"`
+	Listen      string `json:"listen"`
+	Connections int32  `json:"connections"`
+	Ok          uint32 `json:"ok"`
+	WbOk        uint32 `json:"wb_ok"`
+	Errors      uint32 `json:"errors"`
+	Rejected    uint32 `json:"rejected"`
+	Timeouts    uint32 `json:"timeouts"`
+	Monitors    int32  `json:"monitors"`
+	SlowlogLen  int    `json:"slowlog_len"`
+}
+
+type adminStats struct {
+	Clients    int             `json:"clients"`
+	Goroutines int             `json:"goroutines"`
+	Sets       []adminSetStats `json:"sets"`
+}
+
+// Counters are reset when they are sent to statsd, they are the values since
+// the last sending when statsd is enabled.
+func getAdminStats(sets []adminSet) adminStats {
+	stats := adminStats{len(clients.list()), runtime.NumGoroutine(), make([]adminSetStats, 0, len(sets))}
+	for _, s := range sets {
+		ctx := s.ctx
+		stats.Sets = append(stats.Sets, adminSetStats{
+			ctx.set,
+			s.listen,
+			atomic.LoadInt32(&ctx.gaugeConn),
+			atomic.LoadUint32(&ctx.counterOk),
+			atomic.LoadUint32(&ctx.counterWbOk),
+			atomic.LoadUint32(&ctx.counterErr),
+			atomic.LoadUint32(&ctx.counterRejected),
+			atomic.LoadUint32(&ctx.counterTimeout),
+			atomic.LoadInt32(&ctx.monitors.count),
+			ctx.slowlog.len(),
+		})
+	}
+	return stats
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		log.Printf("Unable to write admin reply: %s", err)
+	}
+}
+
+// Serves the pprof profiles, /config with the flags and the configuration
+// file, and /stats with the stats of each set. The CPU profile and the trace
+// last ?seconds=, the goroutine dump is /debug/pprof/goroutine?debug=2.
+func newAdminHandler(config map[string]interface{}, sets []adminSet) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		flags := make(map[string]string)
+		flag.VisitAll(func(f *flag.Flag) {
+			flags[f.Name] = f.Value.String()
+		})
+		writeJson(w, map[string]interface{}{"flags": flags, "config": config})
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, getAdminStats(sets))
+	})
+	return mux
+}
+
+// A port alone listens on localhost.
+func adminAddr(listen string) string {
+	if !strings.Contains(listen, ":") {
+		return net.JoinHostPort(defaultAdminHost, listen)
+	}
+	return listen
+}
+
+func serveAdmin(listen string, config map[string]interface{}, sets []adminSet) {
+	addr := adminAddr(listen)
+	log.Printf("Admin listening on %s", addr)
+	err := http.ListenAndServe(addr, newAdminHandler(config, sets))
+	if err != nil {
+		log.Fatal("Unable to start the admin listener: ", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminGet(t *testing.T, server *httptest.Server, path string) []byte {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: got %d %s", path, resp.StatusCode, body)
+	}
+	return body
+}
+
+func TestAdmin(t *testing.T) {
+	s := startTestServer(t, "standard", map[string]interface{}{"slowlog_log_slower_than": 0.0})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{{"SET a 1", "+OK"}})
+	config := map[string]interface{}{"admin": "6390", "sets": []interface{}{map[string]interface{}{"set": "test_standard"}}}
+	server := httptest.NewServer(newAdminHandler(config, []adminSet{{"127.0.0.1:6379", s.ctx}}))
+	defer server.Close()
+
+	var stats adminStats
+	err := json.Unmarshal(adminGet(t, server, "/stats"), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Sets) != 1 {
+		t.Fatalf("Got %+v", stats)
+	}
+	set := stats.Sets[0]
+	if set.Set != "test_standard" || set.Listen != "127.0.0.1:6379" || set.Connections != 1 || set.Ok != 1 || set.SlowlogLen != 1 || stats.Clients < 1 {
+		t.Errorf("Got %+v", stats)
+	}
+
+	var running map[string]interface{}
+	err = json.Unmarshal(adminGet(t, server, "/config"), &running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running["config"].(map[string]interface{})["admin"] != "6390" || running["flags"] == nil {
+		t.Errorf("Got %v", running)
+	}
+
+	if body := adminGet(t, server, "/debug/pprof/goroutine?debug=2"); !strings.Contains(string(body), "goroutine ") {
+		t.Errorf("Unexpected goroutine dump %s", body)
+	}
+	adminGet(t, server, "/debug/pprof/heap")
+	adminGet(t, server, "/debug/pprof/trace?seconds=0.1")
+}
+
+func TestAdminAddr(t *testing.T) {
+	for _, c := range [][2]string{{"6390", "127.0.0.1:6390"}, {"0.0.0.0:6390", "0.0.0.0:6390"}, {":6390", ":6390"}} {
+		if addr := adminAddr(c[0]); addr != c[1] {
+			t.Errorf("%s: got %s, expected %s", c[0], addr, c[1])
+		}
+	}
+}
+
+func TestProfileDisabled(t *testing.T) {
+	profileCommand = false
+	defer func() { profileCommand = true }()
+	s := startTestServer(t, "standard", nil)
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"PROFILE", "-ERR PROFILE is disabled"},
+		{"SET a b", "+OK"},
+	})
+}
@@ -1,19 +1,33 @@
 package main
 
 import (
+	"errors"
+
 	as "github.com/aerospike/aerospike-client-go"
 	ase "github.com/aerospike/aerospike-client-go/types"
 )
 
+// Reads from one replica, the default: the consistency level of the older
+// clients is replaced by the read mode since the 3.0 client.
 func createReadPolicy() *as.BasePolicy {
 	policy := as.NewPolicy()
-	policy.ConsistencyLevel = as.CONSISTENCY_ONE
 	policy.ReplicaPolicy = as.MASTER_PROLES
 	return policy
 }
 
+// Used to read before a generation checked write
+func createMasterReadPolicy() *as.BasePolicy {
+	policy := as.NewPolicy()
+	policy.ReplicaPolicy = as.MASTER
+	return policy
+}
+
+// Store the user keys with the records, needed by RANDOMKEY
+var sendKey = false
+
 func fillWritePolicy(writePolicy *as.WritePolicy) {
 	writePolicy.CommitLevel = as.COMMIT_MASTER
+	writePolicy.SendKey = sendKey
 }
 
 func createWritePolicyGeneration(generation uint32, ttl int) *as.WritePolicy {
@@ -29,14 +43,16 @@ func createWritePolicyGeneration(generation uint32, ttl int) *as.WritePolicy {
 	return policy
 }
 
+// Ttl of the write policies:
+// * -1 keeps the ttl of an existing record, like most Redis commands. A
+// created record does not expire.
+// * -2 removes the ttl, like SET.
+// * otherwise, the ttl in seconds.
 func createWritePolicyEx(ttl int, createOnly bool) *as.WritePolicy {
-	if ttl == -2 {
-		policy := as.NewWritePolicy(0, as.TTLDontExpire)
-		fillWritePolicy(policy)
-		return policy
-	}
 	policy := as.NewWritePolicy(0, as.TTLDontUpdate)
-	if ttl != -1 {
+	if ttl == -2 || (ttl == -1 && createOnly) {
+		policy = as.NewWritePolicy(0, as.TTLDontExpire)
+	} else if ttl != -1 {
 		policy = as.NewWritePolicy(0, uint32(ttl))
 	}
 	fillWritePolicy(policy)
@@ -46,6 +62,59 @@ func createWritePolicyEx(ttl int, createOnly bool) *as.WritePolicy {
 	return policy
 }
 
+// Set when the namespace default-ttl is 0: records created without ttl do not
+// expire, so writes keeping the ttl can be done in one step.
+var nsNeverExpire = false
+
+// Aerospike gives the namespace default ttl to the records created by a write
+// which keeps the ttl. To create them without expiration like Redis, the
+// write is done on the existing record only, then the record is created.
+func keepTTLWrite(ctx *context, policy *as.WritePolicy, write func(policy *as.WritePolicy) error) error {
+	if nsNeverExpire || policy.Expiration != as.TTLDontUpdate || policy.GenerationPolicy != as.NONE || policy.RecordExistsAction != as.UPDATE {
+		return write(policy)
+	}
+	update := *policy
+	update.RecordExistsAction = as.UPDATE_ONLY
+	create := *policy
+	create.RecordExistsAction = as.CREATE_ONLY
+	create.Expiration = as.TTLDontExpire
+	for i := 0; i < ctx.generationRetries; i++ {
+		err := write(&update)
+		if errResultCode(err) != ase.KEY_NOT_FOUND_ERROR {
+			return err
+		}
+		err = write(&create)
+		if errResultCode(err) != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for write")
+}
+
+// Operate which can create the record, see keepTTLWrite.
+func operate(ctx *context, policy *as.WritePolicy, key *as.Key, ops ...*operation) (*as.Record, error) {
+	var rec *as.Record
+	err := keepTTLWrite(ctx, policy, func(policy *as.WritePolicy) error {
+		var err error
+		rec, err = ctx.client.Operate(policy, key, ops...)
+		return err
+	})
+	return rec, err
+}
+
+// PutBins which can create the record, see keepTTLWrite.
+func putBins(ctx *context, policy *as.WritePolicy, key *as.Key, bins ...*as.Bin) error {
+	return keepTTLWrite(ctx, policy, func(policy *as.WritePolicy) error {
+		return ctx.client.PutBins(policy, key, bins...)
+	})
+}
+
+func createWritePolicyUpdateOnly() *as.WritePolicy {
+	policy := createWritePolicyEx(-1, false)
+	policy.RecordExistsAction = as.UPDATE_ONLY
+	return policy
+}
+
 func buildKey(ctx *context, key []byte) (*as.Key, error) {
 	return as.NewKey(ctx.ns, ctx.set, string(key))
 }
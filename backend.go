@@ -0,0 +1,96 @@
+package main
+
+import (
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Storage used by the commands: the Aerospike cluster, or the memory backend
+// for the tests and the runs without cluster.
+type backend interface {
+	Get(policy *as.BasePolicy, key *as.Key, binNames ...string) (*as.Record, error)
+	GetHeader(policy *as.BasePolicy, key *as.Key) (*as.Record, error)
+	Exists(policy *as.BasePolicy, key *as.Key) (bool, error)
+	PutBins(policy *as.WritePolicy, key *as.Key, bins ...*as.Bin) error
+	Operate(policy *as.WritePolicy, key *as.Key, ops ...*operation) (*as.Record, error)
+	Delete(policy *as.WritePolicy, key *as.Key) (bool, error)
+	Touch(policy *as.WritePolicy, key *as.Key) error
+	ScanAll(policy *as.ScanPolicy, ns string, set string) (recordset, error)
+	// Returns the records whose bin is equal to value, using a secondary index.
+	Query(policy *as.QueryPolicy, ns string, set string, binName string, value string) (recordset, error)
+	IsConnected() bool
+}
+
+type recordset interface {
+	Results() <-chan *as.Result
+	Close() error
+}
+
+type aerospikeBackend struct {
+	client *as.Client
+}
+
+func (b *aerospikeBackend) Get(policy *as.BasePolicy, key *as.Key, binNames ...string) (*as.Record, error) {
+	return b.client.Get(policy, key, binNames...)
+}
+
+func (b *aerospikeBackend) GetHeader(policy *as.BasePolicy, key *as.Key) (*as.Record, error) {
+	return b.client.GetHeader(policy, key)
+}
+
+func (b *aerospikeBackend) Exists(policy *as.BasePolicy, key *as.Key) (bool, error) {
+	return b.client.Exists(policy, key)
+}
+
+func (b *aerospikeBackend) PutBins(policy *as.WritePolicy, key *as.Key, bins ...*as.Bin) error {
+	return b.client.PutBins(policy, key, bins...)
+}
+
+// The client returns no record and no error when an update only operate does
+// not find the record: the error is returned, like for the other writes.
+func (b *aerospikeBackend) Operate(policy *as.WritePolicy, key *as.Key, ops ...*operation) (*as.Record, error) {
+	asOps := make([]*as.Operation, len(ops))
+	write := false
+	for i, op := range ops {
+		asOps[i] = op.op
+		write = write || op.isWrite()
+	}
+	rec, err := b.client.Operate(policy, key, asOps...)
+	if err == nil && rec == nil && write && (policy.RecordExistsAction == as.UPDATE_ONLY || policy.RecordExistsAction == as.REPLACE_ONLY) {
+		return nil, ase.NewAerospikeError(ase.KEY_NOT_FOUND_ERROR)
+	}
+	return rec, err
+}
+
+func (b *aerospikeBackend) Delete(policy *as.WritePolicy, key *as.Key) (bool, error) {
+	return b.client.Delete(policy, key)
+}
+
+func (b *aerospikeBackend) Touch(policy *as.WritePolicy, key *as.Key) error {
+	return b.client.Touch(policy, key)
+}
+
+func (b *aerospikeBackend) ScanAll(policy *as.ScanPolicy, ns string, set string) (recordset, error) {
+	res, err := b.client.ScanAll(policy, ns, set)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *aerospikeBackend) Query(policy *as.QueryPolicy, ns string, set string, binName string, value string) (recordset, error) {
+	statement := as.NewStatement(ns, set)
+	err := statement.SetFilter(as.NewEqualFilter(binName, value))
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.client.Query(policy, statement)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *aerospikeBackend) IsConnected() bool {
+	return b.client.IsConnected()
+}
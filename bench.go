@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Load generator, like redis-benchmark. Each client sends pipelines of
+// commands picked in the mix, and measures the latency of each reply:
+//   aerodis bench -clients 50 -requests 100000 -pipeline 10 -mix GET=3,SET=1
+// With -backend, an Aerodis is started in the process, on a random port.
+
+const benchDefaultMix = "GET=1,SET=1,INCR=1,LPUSH=1,RPOP=1,HSET=1,HGET=1"
+
+// Each kind of key gets the commands of one type, so the benchmark does
+// not produce wrong type errors.
+type benchCommand struct {
+	kind string
+	args func(r *rand.Rand, key string, value string) []string
+}
+
+var benchCommands = map[string]benchCommand{
+	"GET":    {"string", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"SET":    {"string", func(r *rand.Rand, key string, value string) []string { return []string{key, value} }},
+	"SETEX":  {"string", func(r *rand.Rand, key string, value string) []string { return []string{key, "3600", value} }},
+	"GETSET": {"string", func(r *rand.Rand, key string, value string) []string { return []string{key, value} }},
+	"APPEND": {"string", func(r *rand.Rand, key string, value string) []string { return []string{key, value} }},
+	"STRLEN": {"string", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"MGET":   {"string", func(r *rand.Rand, key string, value string) []string { return []string{key, key + ":0", key + ":1"} }},
+	"INCR":   {"counter", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"DECR":   {"counter", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"INCRBY": {"counter", func(r *rand.Rand, key string, value string) []string { return []string{key, strconv.Itoa(r.Intn(100))} }},
+	"LPUSH":  {"list", func(r *rand.Rand, key string, value string) []string { return []string{key, value} }},
+	"RPUSH":  {"list", func(r *rand.Rand, key string, value string) []string { return []string{key, value} }},
+	"LPOP":   {"list", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"RPOP":   {"list", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"LLEN":   {"list", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"LRANGE": {"list", func(r *rand.Rand, key string, value string) []string { return []string{key, "0", "9"} }},
+	"LINDEX": {"list", func(r *rand.Rand, key string, value string) []string { return []string{key, "0"} }},
+	"HSET":   {"hash", func(r *rand.Rand, key string, value string) []string { return []string{key, benchField(r), value} }},
+	"HGET":   {"hash", func(r *rand.Rand, key string, value string) []string { return []string{key, benchField(r)} }},
+	"HMGET": {"hash", func(r *rand.Rand, key string, value string) []string {
+		return []string{key, benchField(r), benchField(r)}
+	}},
+	"HDEL":    {"hash", func(r *rand.Rand, key string, value string) []string { return []string{key, benchField(r)} }},
+	"HGETALL": {"hash", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"HLEN":    {"hash", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"HINCRBY": {"hcounter", func(r *rand.Rand, key string, value string) []string { return []string{key, benchField(r), "1"} }},
+	"SETBIT": {"bitmap", func(r *rand.Rand, key string, value string) []string {
+		return []string{key, strconv.Itoa(r.Intn(1024)), "1"}
+	}},
+	"GETBIT": {"bitmap", func(r *rand.Rand, key string, value string) []string {
+		return []string{key, strconv.Itoa(r.Intn(1024))}
+	}},
+	"BITCOUNT": {"bitmap", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"PFADD":    {"hll", func(r *rand.Rand, key string, value string) []string { return []string{key, strconv.Itoa(r.Int())} }},
+	"PFCOUNT":  {"hll", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"EXISTS":   {"string", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"DEL":      {"string", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+	"EXPIRE":   {"string", func(r *rand.Rand, key string, value string) []string { return []string{key, "3600"} }},
+	"TTL":      {"string", func(r *rand.Rand, key string, value string) []string { return []string{key} }},
+}
+
+func benchField(r *rand.Rand) string {
+	return "field" + strconv.Itoa(r.Intn(10))
+}
+
+type benchMixEntry struct {
+	name   string
+	weight int
+}
+
+// Parses a mix like GET=3,SET=1, the weight is 1 by default.
+func parseBenchMix(s string) ([]benchMixEntry, error) {
+	mix := make([]benchMixEntry, 0)
+	for _, e := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		name := strings.ToUpper(parts[0])
+		if _, ok := benchCommands[name]; !ok {
+			names := make([]string, 0, len(benchCommands))
+			for n := range benchCommands {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, errors.New("Unknown command in mix: '" + parts[0] + "', available commands: " + strings.Join(names, " "))
+		}
+		weight := 1
+		if len(parts) == 2 {
+			w, err := strconv.Atoi(parts[1])
+			if err != nil || w < 0 {
+				return nil, errors.New("Invalid weight in mix: '" + e + "'")
+			}
+			weight = w
+		}
+		mix = append(mix, benchMixEntry{name, weight})
+	}
+	return mix, nil
+}
+
+type benchConfig struct {
+	addr     string
+	clients  int
+	requests int
+	pipeline int
+	keyspace int
+	value    string
+	prefix   string
+	mix      []benchMixEntry
+}
+
+// Results of a client, the latencies of each command.
+type benchResult struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func newBenchResult() *benchResult {
+	return &benchResult{make(map[string][]time.Duration), make(map[string]int)}
+}
+
+func benchClient(config *benchConfig, seed int64, requests int, total int, res *benchResult) error {
+	conn, err := net.Dial("tcp", config.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	r := rand.New(rand.NewSource(seed))
+	names := make([]string, config.pipeline)
+	for requests > 0 {
+		n := config.pipeline
+		if n > requests {
+			n = requests
+		}
+		for i := 0; i < n; i++ {
+			x := r.Intn(total)
+			var name string
+			for _, e := range config.mix {
+				if x < e.weight {
+					name = e.name
+					break
+				}
+				x -= e.weight
+			}
+			c := benchCommands[name]
+			key := config.prefix + c.kind + ":" + strconv.Itoa(r.Intn(config.keyspace))
+			args := append([]string{name}, c.args(r, key, config.value)...)
+			writer.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+			for _, a := range args {
+				writer.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+			}
+			names[i] = name
+		}
+		start := time.Now()
+		err := writer.Flush()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			reply, err := readReply(reader)
+			if err != nil {
+				// Aerodis closes the connection after most errors
+				return errors.New("Connection lost after a " + names[i] + ": " + err.Error())
+			}
+			res.latencies[names[i]] = append(res.latencies[names[i]], time.Since(start))
+			if reply[0] == '-' {
+				res.errors[names[i]]++
+			}
+		}
+		requests -= n
+	}
+	return nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func formatLatency(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func writeLatencyHeader(out io.Writer, countLabel string) {
+	fmt.Fprintf(out, "%-10s %10s %10s %9s %9s %9s %9s\n", "command", "requests", countLabel, "p50 ms", "p95 ms", "p99 ms", "max ms")
+}
+
+// Writes the number of requests of a command, a count of errors or
+// mismatches, and the latency percentiles.
+func writeLatencyStats(out io.Writer, name string, latencies []time.Duration, count int) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Fprintf(out, "%-10s %10d %10d %9s %9s %9s %9s\n", name, len(latencies), count,
+		formatLatency(percentile(latencies, 0.5)), formatLatency(percentile(latencies, 0.95)),
+		formatLatency(percentile(latencies, 0.99)), formatLatency(latencies[len(latencies)-1]))
+}
+
+func runBench(config *benchConfig, out io.Writer) error {
+	total := 0
+	for _, e := range config.mix {
+		total += e.weight
+	}
+	if total == 0 {
+		return errors.New("The mix has no command with a positive weight")
+	}
+	fmt.Fprintf(out, "Benchmark of %s: %d clients, pipeline %d, %d requests, keyspace %d, values of %d bytes\n",
+		config.addr, config.clients, config.pipeline, config.requests, config.keyspace, len(config.value))
+	results := make([]*benchResult, config.clients)
+	errs := make([]error, config.clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	seed := start.UnixNano()
+	for i := 0; i < config.clients; i++ {
+		requests := config.requests / config.clients
+		if i < config.requests%config.clients {
+			requests++
+		}
+		results[i] = newBenchResult()
+		wg.Add(1)
+		go func(i int, requests int) {
+			defer wg.Done()
+			errs[i] = benchClient(config, seed+int64(i), requests, total, results[i])
+		}(i, requests)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	latencies := make(map[string][]time.Duration)
+	errorCounts := make(map[string]int)
+	all := make([]time.Duration, 0, config.requests)
+	allErrors := 0
+	for _, res := range results {
+		for name, l := range res.latencies {
+			latencies[name] = append(latencies[name], l...)
+			all = append(all, l...)
+		}
+		for name, n := range res.errors {
+			errorCounts[name] += n
+			allErrors += n
+		}
+	}
+	fmt.Fprintf(out, "%d requests in %.2f s, %.0f requests per second, %d errors\n",
+		len(all), elapsed.Seconds(), float64(len(all))/elapsed.Seconds(), allErrors)
+	if len(all) == 0 {
+		return nil
+	}
+	writeLatencyHeader(out, "errors")
+	names := make([]string, 0, len(latencies))
+	for name := range latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeLatencyStats(out, name, latencies[name], errorCounts[name])
+	}
+	writeLatencyStats(out, "all", all, allErrors)
+	return nil
+}
+
+// Entry point of the bench subcommand.
+func bench(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	host := flags.String("host", "127.0.0.1:6379", "Address of the Redis interface to benchmark")
+	clients := flags.Int("clients", 50, "Number of parallel connections")
+	requests := flags.Int("requests", 100000, "Total number of requests")
+	pipeline := flags.Int("pipeline", 1, "Number of requests sent at once by each connection")
+	keyspace := flags.Int("keyspace", 10000, "Number of keys of each type")
+	dataSize := flags.Int("data_size", 16, "Size in bytes of the values")
+	prefix := flags.String("key_prefix", "bench:", "Prefix of the keys, they are not deleted at the end")
+	mix := flags.String("mix", benchDefaultMix, "Commands to send, with their weights")
+	backendName := flags.String("backend", "", "Start an Aerodis in the process with this backend, aerospike or memory, instead of using -host")
+	mode := flags.String("mode", "standard", "Mode of the Aerodis started with -backend: standard, expanded_map or cdt_map")
+	aeroHost := flags.String("aero_host", "localhost", "Aerospike server host, with -backend aerospike")
+	aeroPort := flags.Int("aero_port", 3000, "Aerospike server port, with -backend aerospike")
+	ns := flags.String("ns", "test", "Aerospike namespace, with -backend aerospike")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *clients <= 0 || *requests <= 0 || *pipeline <= 0 || *keyspace <= 0 || *dataSize < 0 {
+		return errors.New("clients, requests, pipeline and keyspace must be positive")
+	}
+	parsedMix, err := parseBenchMix(*mix)
+	if err != nil {
+		return err
+	}
+	config := &benchConfig{*host, *clients, *requests, *pipeline, *keyspace, strings.Repeat("x", *dataSize), *prefix, parsedMix}
+
+	if *backendName != "" {
+		client := newBackend(*backendName, []string{*aeroHost}, *aeroPort, *clients, *ns)
+		m := map[string]interface{}{"set": "bench"}
+		switch *mode {
+		case "standard":
+		case "expanded_map", "cdt_map":
+			m[*mode] = true
+		default:
+			return errors.New("Unknown mode " + *mode)
+		}
+		ctx, handlers := setContext(client, false, *ns, 10, createReadPolicy(), createWritePolicyEx(-1, false), m)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		// The listener is not closed, handlePort does not stop on errors
+		go handlePort(ctx, l, handlers)
+		config.addr = l.Addr().String()
+	}
+	return runBench(config, out)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	for _, mode := range testModes {
+		out := &bytes.Buffer{}
+		mix := "GET=2,SET,INCR,LPUSH,RPOP,LRANGE,HSET,HGET,HINCRBY,SETBIT,PFADD,EXPIRE,TTL"
+		err := bench([]string{"-backend", "memory", "-mode", mode, "-clients", "4", "-requests", "2000", "-pipeline", "8", "-keyspace", "20", "-mix", mix}, out)
+		if err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		res := out.String()
+		if !strings.Contains(res, "2000 requests in ") || !strings.Contains(res, " 0 errors\n") {
+			t.Errorf("%s: unexpected output\n%s", mode, res)
+		}
+		for _, name := range []string{"GET", "HINCRBY", "PFADD", "all"} {
+			if !strings.Contains(res, "\n"+name+" ") {
+				t.Errorf("%s: no statistics for %s\n%s", mode, name, res)
+			}
+		}
+	}
+	if err := bench([]string{"-mix", "GET,UNKNOWN"}, &bytes.Buffer{}); err == nil || !strings.HasPrefix(err.Error(), "Unknown command in mix: 'UNKNOWN'") {
+		t.Errorf("Unknown command in mix: got %v", err)
+	}
+	if err := bench([]string{"-backend", "memory", "-mix", "GET=0"}, &bytes.Buffer{}); err == nil {
+		t.Error("A mix without weight must fail")
+	}
+}
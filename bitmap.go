@@ -0,0 +1,387 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Same limit as Redis
+const maxBitOffset = 4*1024*1024*1024 - 1
+
+// Used to grow a bitmap, without failing when it is already large enough
+var bitmapGrowPolicy = newBitPolicy(as.BitWriteFlagsNoFail)
+
+func parseBitOffset(buf []byte) (int, error) {
+	offset, err := strconv.Atoi(string(buf))
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, errors.New("Bit offset is not an integer or out of range: '" + string(buf) + "'")
+	}
+	return offset, nil
+}
+
+func parseBit(buf []byte) (int, error) {
+	if len(buf) != 1 || (buf[0] != '0' && buf[0] != '1') {
+		return 0, errors.New("Bit is not an integer or out of range: '" + string(buf) + "'")
+	}
+	return int(buf[0] - '0'), nil
+}
+
+// Returns the bytes read by the bit get operation of an operate command, the
+// other operations on the same bin may or may not be in the result.
+func bitGetResult(x interface{}) []byte {
+	switch x.(type) {
+	case []byte:
+		return x.([]byte)
+	case []interface{}:
+		for _, e := range x.([]interface{}) {
+			buf, ok := e.([]byte)
+			if ok {
+				return buf
+			}
+		}
+	}
+	return nil
+}
+
+var errEncodedBitmap = errors.New("Bitmap stored compressed or escaped")
+
+// Bit operations only work on plain blobs. Bits of values stored as integers,
+// compressed or escaped, are set proxy side, with a generation check. Bitmaps
+// are never compressed, but are escaped when they start like a compressed
+// value.
+func trySetBitValue(ctx *context, key *as.Key, offset int, bit int) (int, error) {
+	current, _, policy, err := stringRecord(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	old := getBit(current, offset)
+	size := len(current)
+	if offset/8 >= size {
+		size = offset/8 + 1
+	}
+	buf := make([]byte, size)
+	copy(buf, current)
+	mask := byte(0x80 >> uint(offset%8))
+	if bit == 1 {
+		buf[offset/8] |= mask
+	} else {
+		buf[offset/8] &^= mask
+	}
+	err = ctx.client.PutBins(policy, key, as.NewBin(binName, escapeCompressionMagic(buf)))
+	return old, err
+}
+
+// Reads the header of a bitmap, to know if it is stored compressed or escaped.
+// Returns the record, nil if it does not exist.
+func bitmapHeader(ctx *context, key *as.Key) (*as.Record, bool, error) {
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, bitGetOp(binName, 0, 8*(len(compressionMagic)+1)))
+	switch errResultCode(err) {
+	case ase.KEY_NOT_FOUND_ERROR:
+		return nil, false, nil
+	case ase.PARAMETER_ERROR, ase.OP_NOT_APPLICABLE, ase.BIN_TYPE_ERROR:
+		// shorter than the header, or not a blob
+		rec, err = ctx.client.GetHeader(createMasterReadPolicy(), key)
+		return rec, false, err
+	}
+	if err != nil || rec == nil {
+		return nil, false, err
+	}
+	header := bitGetResult(rec.Bins[binName])
+	if !hasCompressionMagic(header) {
+		return rec, false, nil
+	}
+	switch header[len(compressionMagic)] {
+	case compressionNone, compressionGzip, compressionSnappy:
+		return rec, true, nil
+	}
+	return rec, false, nil
+}
+
+// The header is read first, as the bit operations would modify a compressed
+// value. The write is generation checked against it. Bits of the header are
+// set proxy side, as they could make the bitmap look compressed.
+func trySetBit(ctx *context, key *as.Key, offset int, bit int) (int, error) {
+	header, encoded, err := bitmapHeader(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if encoded || offset < 8*(len(compressionMagic)+1) {
+		return 0, errEncodedBitmap
+	}
+	policy := createWritePolicyEx(-1, true)
+	if header != nil {
+		policy = createWritePolicyGeneration(header.Generation, -1)
+	}
+	value := []byte{0}
+	if bit == 1 {
+		value[0] = 0x80
+	}
+	rec, err := ctx.client.Operate(policy, key,
+		bitGrowOp(bitmapGrowPolicy, binName, offset/8+1),
+		bitGetOp(binName, offset, 1),
+		bitSetOp(defaultBitPolicy, binName, offset, 1, value),
+	)
+	if err != nil {
+		return 0, err
+	}
+	old := bitGetResult(rec.Bins[binName])
+	if len(old) > 0 && old[0]&0x80 != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func cmdSETBIT(wf io.Writer, ctx *context, args [][]byte) error {
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return err
+	}
+	bit, err := parseBit(args[2])
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		old, err := trySetBit(ctx, key, offset, bit)
+		if err == errEncodedBitmap || errResultCode(err) == ase.BIN_TYPE_ERROR {
+			old, err = trySetBitValue(ctx, key, offset, bit)
+		}
+		if err == nil {
+			return writeLine(wf, ":"+strconv.Itoa(old))
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for setbit")
+}
+
+// Reads a bitmap, nil if the key does not exist.
+func bitmapValue(ctx *context, k []byte) ([]byte, error) {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Bins[binName] == nil {
+		return nil, nil
+	}
+	return decodeValue(rec.Bins[binName])
+}
+
+func getBit(buf []byte, offset int) int {
+	if offset/8 >= len(buf) {
+		return 0
+	}
+	return int(buf[offset/8]>>(7-uint(offset%8))) & 1
+}
+
+func cmdGETBIT(wf io.Writer, ctx *context, args [][]byte) error {
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, encoded, err := bitmapHeader(ctx, key)
+	if err != nil {
+		return err
+	}
+	if encoded {
+		buf, err := bitmapValue(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return writeLine(wf, ":"+strconv.Itoa(getBit(buf, offset)))
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, bitGetOp(binName, offset, 1))
+	switch errResultCode(err) {
+	case ase.KEY_NOT_FOUND_ERROR, ase.PARAMETER_ERROR, ase.OP_NOT_APPLICABLE:
+		// missing key or bit after the end of the bitmap
+		return writeLine(wf, ":0")
+	case ase.BIN_TYPE_ERROR:
+		buf, err := bitmapValue(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return writeLine(wf, ":"+strconv.Itoa(getBit(buf, offset)))
+	}
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeLine(wf, ":0")
+	}
+	return writeLine(wf, ":"+strconv.Itoa(getBit(bitGetResult(rec.Bins[binName]), 0)))
+}
+
+// Returns the bit range selected by optional start, end and BYTE / BIT
+// arguments, false if the range is empty.
+func bitRange(buf []byte, args [][]byte) (int, int, bool, error) {
+	l := len(buf) * 8
+	if len(args) == 0 {
+		return 0, l, l > 0, nil
+	}
+	start, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	end := -1
+	if len(args) > 1 {
+		end, err = strconv.Atoi(string(args[1]))
+		if err != nil {
+			return 0, 0, false, err
+		}
+	}
+	unit := "BYTE"
+	if len(args) > 2 {
+		unit = strings.ToUpper(string(args[2]))
+	}
+	switch unit {
+	case "BYTE":
+		from, to, ok := stringRange(len(buf), start, end)
+		return from * 8, to * 8, ok, nil
+	case "BIT":
+		from, to, ok := stringRange(l, start, end)
+		return from, to, ok, nil
+	}
+	return 0, 0, false, errors.New("Syntax error: '" + string(args[2]) + "'")
+}
+
+// BITCOUNT and BITPOS read the bitmap, as the server side operations need to
+// know its size to handle negative offsets.
+func cmdBITCOUNT(wf io.Writer, ctx *context, args [][]byte) error {
+	if len(args) == 2 || len(args) > 4 {
+		return errors.New("Syntax error for bitcount")
+	}
+	buf, err := bitmapValue(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	from, to, ok, err := bitRange(buf, args[1:])
+	if err != nil {
+		return err
+	}
+	count := 0
+	for i := from; ok && i < to; {
+		if i%8 == 0 && i+8 <= to {
+			count += bits.OnesCount8(buf[i/8])
+			i += 8
+			continue
+		}
+		count += getBit(buf, i)
+		i++
+	}
+	return writeLine(wf, ":"+strconv.Itoa(count))
+}
+
+func cmdBITPOS(wf io.Writer, ctx *context, args [][]byte) error {
+	if len(args) > 5 {
+		return errors.New("Syntax error for bitpos")
+	}
+	bit, err := parseBit(args[1])
+	if err != nil {
+		return err
+	}
+	buf, err := bitmapValue(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if buf == nil {
+		return writeLine(wf, ":"+strconv.Itoa(-bit))
+	}
+	from, to, ok, err := bitRange(buf, args[2:])
+	if err != nil {
+		return err
+	}
+	for i := from; ok && i < to; i++ {
+		if getBit(buf, i) == bit {
+			return writeLine(wf, ":"+strconv.Itoa(i))
+		}
+	}
+	// Like Redis, the string is considered padded with zeros on the right
+	// when looking for a clear bit without an end
+	if bit == 0 && len(args) < 4 {
+		return writeLine(wf, ":"+strconv.Itoa(len(buf)*8))
+	}
+	return writeLine(wf, ":-1")
+}
+
+// Computed proxy side, sources are read one by one.
+func cmdBITOP(wf io.Writer, ctx *context, args [][]byte) error {
+	op := strings.ToUpper(string(args[0]))
+	if op == "NOT" && len(args) != 3 {
+		return errors.New("BITOP NOT must be called with a single source key")
+	}
+	if op != "AND" && op != "OR" && op != "XOR" && op != "NOT" {
+		return errors.New("Syntax error: '" + string(args[0]) + "'")
+	}
+	sources := make([][]byte, len(args)-2)
+	l := 0
+	for i, k := range args[2:] {
+		buf, err := bitmapValue(ctx, k)
+		if err != nil {
+			return err
+		}
+		sources[i] = buf
+		if len(buf) > l {
+			l = len(buf)
+		}
+	}
+	res := make([]byte, l)
+	for i := 0; i < l; i++ {
+		x := byte(0)
+		if i < len(sources[0]) {
+			x = sources[0][i]
+		}
+		for _, s := range sources[1:] {
+			y := byte(0)
+			if i < len(s) {
+				y = s[i]
+			}
+			switch op {
+			case "AND":
+				x &= y
+			case "OR":
+				x |= y
+			case "XOR":
+				x ^= y
+			}
+		}
+		if op == "NOT" {
+			x = ^x
+		}
+		res[i] = x
+	}
+	key, err := buildKey(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	if l == 0 {
+		_, err = ctx.client.Delete(ctx.writePolicy, key)
+		if err != nil {
+			return err
+		}
+		return writeLine(wf, ":0")
+	}
+	err = ctx.client.PutBins(createWritePolicyEx(-2, false), key, as.NewBin(binName, escapeCompressionMagic(res)))
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, ":"+strconv.Itoa(l))
+}
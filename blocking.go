@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Blocked clients are woken up immediately by the pushes done through this
+// Aerodis instance, and poll Aerospike with a backoff to see the pushes done
+// through other instances.
+const minBlockingPollInterval = 10 * time.Millisecond
+const maxBlockingPollInterval = 1 * time.Second
+
+var errClientClosed = errors.New("Client closed the connection")
+
+// Implemented by the writer of a connection, to stop waiting for a value
+// when the client goes away. Returns a channel closed when the client closes
+// the connection, and a function stopping the watch.
+type closeWatcher interface {
+	watchClose() (chan bool, func())
+}
+
+// A connection with its reader, which is peeked while a command is blocked.
+// Commands sent by the client in the meantime stay in the reader.
+type blockingConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *blockingConn) watchClose() (chan bool, func()) {
+	closed := make(chan bool)
+	done := make(chan bool)
+	c.Conn.SetReadDeadline(time.Time{})
+	go func() {
+		_, err := c.reader.Peek(1)
+		if netErr, ok := err.(net.Error); err != nil && !(ok && netErr.Timeout()) {
+			close(closed)
+		}
+		close(done)
+	}()
+	return closed, func() {
+		c.Conn.SetReadDeadline(time.Now())
+		<-done
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+type waiters struct {
+	mutex sync.Mutex
+	keys  map[string]map[chan bool]bool
+}
+
+func newWaiters() *waiters {
+	return &waiters{keys: make(map[string]map[chan bool]bool)}
+}
+
+func (w *waiters) register(keys [][]byte) chan bool {
+	c := make(chan bool, 1)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, k := range keys {
+		m := w.keys[string(k)]
+		if m == nil {
+			m = make(map[chan bool]bool)
+			w.keys[string(k)] = m
+		}
+		m[c] = true
+	}
+	return c
+}
+
+func (w *waiters) unregister(keys [][]byte, c chan bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, k := range keys {
+		m := w.keys[string(k)]
+		delete(m, c)
+		if len(m) == 0 {
+			delete(w.keys, string(k))
+		}
+	}
+}
+
+func (w *waiters) notify(key []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for c := range w.keys[string(key)] {
+		select {
+		case c <- true:
+		default:
+		}
+	}
+}
+
+func parseTimeout(buf []byte) (time.Duration, error) {
+	timeout, err := parseFloat(buf)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, errors.New("Timeout is negative")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+// Calls f on each key, in order, until it returns a value. Waits for a push
+// on one of the keys if there is no value. A zero timeout waits forever.
+// Commands are not blocking inside a MULTI, like in Redis. Stops without
+// calling f when the client closes the connection, so no value is lost.
+func waitForValue(wf io.Writer, ctx *context, keys [][]byte, timeout time.Duration, f func([]byte) (interface{}, error)) ([]byte, interface{}, error) {
+	_, multi := wf.(*bytes.Buffer)
+	var closed chan bool
+	if w, ok := wf.(closeWatcher); ok {
+		var stop func()
+		closed, stop = w.watchClose()
+		defer stop()
+	}
+	deadline := time.Now().Add(timeout)
+	interval := minBlockingPollInterval
+	for {
+		select {
+		case <-closed:
+			return nil, nil, errClientClosed
+		default:
+		}
+		c := ctx.listWaiters.register(keys)
+		for _, k := range keys {
+			value, err := f(k)
+			if err != nil || value != nil {
+				ctx.listWaiters.unregister(keys, c)
+				return k, value, err
+			}
+		}
+		wait := interval
+		if timeout > 0 {
+			remaining := deadline.Sub(time.Now())
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		if multi || wait <= 0 {
+			ctx.listWaiters.unregister(keys, c)
+			return nil, nil, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-c:
+			interval = minBlockingPollInterval
+		case <-timer.C:
+			interval *= 2
+			if interval > maxBlockingPollInterval {
+				interval = maxBlockingPollInterval
+			}
+		case <-closed:
+		}
+		timer.Stop()
+		ctx.listWaiters.unregister(keys, c)
+	}
+}
+
+func blockingPop(wf io.Writer, ctx *context, args [][]byte, index int) error {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	k, value, err := waitForValue(wf, ctx, args[:len(args)-1], timeout, func(k []byte) (interface{}, error) {
+		key, err := buildKey(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		return listPop(ctx, key, index)
+	})
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return writeLine(wf, "*-1")
+	}
+	err = writeLine(wf, "*2")
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, k)
+	if err != nil {
+		return err
+	}
+	return writeValue(wf, value)
+}
+
+func cmdBLPOP(wf io.Writer, ctx *context, args [][]byte) error {
+	return blockingPop(wf, ctx, args, 0)
+}
+
+func cmdBRPOP(wf io.Writer, ctx *context, args [][]byte) error {
+	return blockingPop(wf, ctx, args, -1)
+}
+
+func cmdBLMOVE(wf io.Writer, ctx *context, args [][]byte) error {
+	from, err := parseListSide(args[2])
+	if err != nil {
+		return err
+	}
+	to, err := parseListSide(args[3])
+	if err != nil {
+		return err
+	}
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		return err
+	}
+	_, value, err := waitForValue(wf, ctx, args[:1], timeout, func(k []byte) (interface{}, error) {
+		return listMove(ctx, k, args[1], from, to)
+	})
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return writeLine(wf, "*-1")
+	}
+	return writeValue(wf, value)
+}
+
+func cmdBRPOPLPUSH(wf io.Writer, ctx *context, args [][]byte) error {
+	timeout, err := parseTimeout(args[2])
+	if err != nil {
+		return err
+	}
+	_, value, err := waitForValue(wf, ctx, args[:1], timeout, func(k []byte) (interface{}, error) {
+		return listMove(ctx, k, args[1], -1, 0)
+	})
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return writeLine(wf, "*-1")
+	}
+	return writeValue(wf, value)
+}
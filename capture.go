@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic capture, to replay it against another Aerodis. The commands of the
+// sampled connections are written with their replies and their arrival time.
+// All the commands of a sampled connection are captured, so MULTI blocks and
+// the commands depending on the previous ones can be replayed.
+//
+// File format: the magic line, then for each command, as uvarints, the
+// connection number, the microseconds since the start of the capture, the
+// number of arguments, the arguments and the reply, prefixed by their size.
+const captureMagic = "AERODIS-CAPTURE-1\n"
+
+type capture struct {
+	lock        sync.Mutex
+	file        *os.File
+	writer      *bufio.Writer
+	start       time.Time
+	sample      float64
+	redact      bool
+	connections uint64
+}
+
+type capturedCommand struct {
+	connection uint64
+	at         time.Duration
+	args       [][]byte
+	reply      []byte
+}
+
+func newCapture(fname string, sample float64, redact bool) (*capture, error) {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := &capture{file: f, writer: bufio.NewWriterSize(f, 65536), start: time.Now(), sample: sample, redact: redact}
+	_, err = c.writer.WriteString(captureMagic)
+	if err != nil {
+		return nil, err
+	}
+	go c.flushLoop()
+	return c, nil
+}
+
+func (c *capture) flushLoop() {
+	for {
+		time.Sleep(time.Second)
+		err := c.flush()
+		if err != nil {
+			log.Printf("Unable to write capture: %s", err)
+		}
+	}
+}
+
+func (c *capture) flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.writer.Flush()
+}
+
+// Returns the number of a new connection, 0 if it is not sampled.
+func (c *capture) connection() uint64 {
+	if c.sample < 1 && rand.Float64() >= c.sample {
+		return 0
+	}
+	return atomic.AddUint64(&c.connections, 1)
+}
+
+// Replaces a key by a hash, so the same key is always replaced by the same
+// value, and the replay still works.
+func redactKey(key []byte) []byte {
+	h := sha1.Sum(key)
+	return []byte("redacted:" + hex.EncodeToString(h[:8]))
+}
+
+// Returns the indexes of the keys in the arguments of a command, args[0]
+// being the command.
+func keyIndexes(args [][]byte) []int {
+	res := make([]int, 0)
+	switch string(args[0]) {
+	case "DEL", "EXISTS", "MGET", "PFCOUNT", "PFMERGE", "PFMERGEEX":
+		for i := 1; i < len(args); i++ {
+			if string(args[0]) != "PFMERGEEX" || i != 2 {
+				res = append(res, i)
+			}
+		}
+	case "MSET", "MSETNX":
+		for i := 1; i < len(args); i += 2 {
+			res = append(res, i)
+		}
+	case "BLPOP", "BRPOP":
+		for i := 1; i < len(args)-1; i++ {
+			res = append(res, i)
+		}
+	case "RENAME", "RENAMENX", "COPY", "RPOPLPUSH", "BRPOPLPUSH", "LMOVE", "BLMOVE":
+		res = append(res, 1, 2)
+	case "XGROUP":
+		res = append(res, 2)
+	case "BITOP":
+		for i := 2; i < len(args); i++ {
+			res = append(res, i)
+		}
+	case "XREAD", "XREADGROUP":
+		for i := 1; i < len(args); i++ {
+			if bytes.EqualFold(args[i], []byte("STREAMS")) {
+				for j := i + 1; j < i+1+(len(args)-i-1)/2; j++ {
+					res = append(res, j)
+				}
+				break
+			}
+		}
+	case "MULTI", "EXEC", "DISCARD", "RANDOMKEY", "FLUSHDB", "SLOWLOG", "CLIENT":
+	default:
+		res = append(res, 1)
+	}
+	return res
+}
+
+// Replaces the key in a bulk string reply.
+func redactBulk(raw []byte) []byte {
+	i := bytes.IndexByte(raw, '\n')
+	if len(raw) == 0 || raw[0] != '$' || i < 0 || len(raw) < i+3 {
+		return raw
+	}
+	var buf bytes.Buffer
+	writeByteArray(&buf, redactKey(raw[i+1:len(raw)-2]))
+	return buf.Bytes()
+}
+
+func arrayReply(elements [][]byte) []byte {
+	return append([]byte("*"+strconv.Itoa(len(elements))+"\r\n"), bytes.Join(elements, nil)...)
+}
+
+// Replaces the keys in the replies, by the same hash as in the arguments, so
+// the replay still matches. The replies which can not be redacted, those of
+// EXEC which depend on the queued commands, and those of SLOWLOG, are not
+// kept.
+func redactReply(args [][]byte, reply []byte) []byte {
+	if len(reply) == 0 || reply[0] == '-' {
+		return reply
+	}
+	switch string(args[0]) {
+	case "RANDOMKEY":
+		return redactBulk(reply)
+	case "BLPOP", "BRPOP":
+		elements, err := replyElements(reply)
+		if err != nil {
+			return nil
+		}
+		if len(elements) != 2 {
+			return reply
+		}
+		elements[0] = redactBulk(elements[0])
+		return arrayReply(elements)
+	case "XREAD", "XREADGROUP":
+		elements, err := replyElements(reply)
+		if err != nil {
+			return nil
+		}
+		if elements == nil {
+			return reply
+		}
+		for i, e := range elements {
+			stream, err := replyElements(e)
+			if err != nil || len(stream) != 2 {
+				return nil
+			}
+			stream[0] = redactBulk(stream[0])
+			elements[i] = arrayReply(stream)
+		}
+		return arrayReply(elements)
+	case "EXEC", "SLOWLOG":
+		return nil
+	}
+	return reply
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(buf, tmp[:binary.PutUvarint(tmp, x)]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func (c *capture) record(connection uint64, at time.Time, args [][]byte, reply []byte) {
+	if c.redact {
+		redacted := make([][]byte, len(args))
+		copy(redacted, args)
+		for _, i := range keyIndexes(args) {
+			if i < len(args) {
+				redacted[i] = redactKey(args[i])
+			}
+		}
+		args = redacted
+		reply = redactReply(args, reply)
+	}
+	buf := appendUvarint(nil, connection)
+	buf = appendUvarint(buf, uint64(at.Sub(c.start)/time.Microsecond))
+	buf = appendUvarint(buf, uint64(len(args)))
+	for _, a := range args {
+		buf = appendBytes(buf, a)
+	}
+	buf = appendBytes(buf, reply)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.writer.Write(buf)
+	if err != nil {
+		log.Printf("Unable to write capture: %s", err)
+	}
+}
+
+// Copies the replies sent to a captured connection.
+type captureWriter struct {
+	w     io.Writer
+	reply bytes.Buffer
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	c.reply.Write(p)
+	return c.w.Write(p)
+}
+
+// Blocked commands of a captured connection still stop when the client
+// closes it.
+func (c *captureWriter) watchClose() (chan bool, func()) {
+	if w, ok := c.w.(closeWatcher); ok {
+		return w.watchClose()
+	}
+	return nil, func() {}
+}
+
+func readCapturedBytes(reader *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, l)
+	_, err = io.ReadFull(reader, buf)
+	return buf, err
+}
+
+func readCapturedCommand(reader *bufio.Reader) (*capturedCommand, error) {
+	connection, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	at, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, count)
+	for i := range args {
+		args[i], err = readCapturedBytes(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reply, err := readCapturedBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &capturedCommand{connection, time.Duration(at) * time.Microsecond, args, reply}, nil
+}
+
+// Reads a capture file. A truncated last command, written by an Aerodis
+// which has been stopped, is ignored.
+func readCapture(fname string) ([]*capturedCommand, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	magic := make([]byte, len(captureMagic))
+	_, err = io.ReadFull(reader, magic)
+	if err != nil || string(magic) != captureMagic {
+		return nil, errors.New("Not a capture file: " + fname)
+	}
+	res := make([]*capturedCommand, 0)
+	for {
+		c, err := readCapturedCommand(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureServer(t *testing.T, config map[string]interface{}) (*testServer, string) {
+	fname := filepath.Join(t.TempDir(), "capture")
+	config["capture"] = fname
+	return startTestServer(t, "standard", config), fname
+}
+
+func TestCaptureReplay(t *testing.T) {
+	s, fname := captureServer(t, map[string]interface{}{})
+	c := s.connect(t)
+	run(t, c, [][2]string{
+		{"SET a 1", "+OK"},
+		{"INCR n", ":1"},
+		{"RPUSH l x y", ":2"},
+		{"LRANGE l 0 -1", `["x" "y"]`},
+		{"MULTI", "+OK"},
+		{"GET a", "+QUEUED"},
+		{"HSET h f v", "+QUEUED"},
+		{"EXEC", `["1" :1]`},
+	})
+	c.send("UNKNOWN")
+	c.read()
+	c.closed()
+	// The connections are replayed in parallel, at the captured times
+	time.Sleep(50 * time.Millisecond)
+	c = s.connect(t)
+	run(t, c, [][2]string{{"INCR n", ":2"}})
+	c.close()
+	err := s.ctx.capture.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands, err := readCapture(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 10 {
+		t.Fatalf("Got %d captured commands", len(commands))
+	}
+	if string(commands[0].args[0]) != "SET" || string(commands[0].reply) != "+OK\r\n" || commands[0].connection != 1 {
+		t.Errorf("SET: got %+v", commands[0])
+	}
+	if !strings.HasPrefix(string(commands[8].reply), "-ERR Unknown command") {
+		t.Errorf("UNKNOWN: got %q", commands[8].reply)
+	}
+	if commands[9].connection != 2 || commands[9].at < commands[0].at {
+		t.Errorf("INCR: got %+v", commands[9])
+	}
+
+	// The replies are the same on an empty Aerodis
+	target := startTestServer(t, "standard", nil)
+	out := &bytes.Buffer{}
+	err = replay([]string{"-file", fname, "-host", target.addr, "-speed", "1"}, out)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if !strings.Contains(out.String(), "10 commands in ") || !strings.Contains(out.String(), " 0 mismatching replies\n") {
+		t.Errorf("Unexpected output\n%s", out)
+	}
+
+	// And not when the keys already exist
+	out.Reset()
+	err = replay([]string{"-file", fname, "-host", target.addr, "-speed", "1"}, out)
+	if err == nil || err.Error() != "5 mismatching replies" {
+		t.Errorf("Got %v\n%s", err, out)
+	}
+	for _, m := range []string{`Mismatch "INCR n": captured ":1\r\n", got ":3\r\n"`, `Mismatch "RPUSH l x y"`, `Mismatch "INCR n": captured ":2\r\n", got ":4\r\n"`} {
+		if !strings.Contains(out.String(), m) {
+			t.Errorf("%s not found\n%s", m, out)
+		}
+	}
+}
+
+func TestCaptureRedact(t *testing.T) {
+	s, fname := captureServer(t, map[string]interface{}{"capture_redact": true})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"MSET a 1 b 2", "+OK"},
+		{"HSET h a 1", ":1"},
+		{"XREAD STREAMS s1 s2 0-0 0-0", "nil"},
+		{"RPUSH l a", ":1"},
+		{"BLPOP l 0", `["l" "a"]`},
+		{"XADD s1 1-1 f v", `"1-1"`},
+		{"XREAD STREAMS s1 0-0", `[["s1" [["1-1" ["f" "v"]]]]]`},
+		{"DEL a", ":1"},
+		{"DEL b", ":1"},
+		{"DEL h", ":1"},
+		{"DEL s1", ":1"},
+		{"DEL l", ":1"},
+		{"RANDOMKEY", "nil"},
+		{"MSET a 1", "+OK"},
+		{"RANDOMKEY", `"a"`},
+		{"MULTI", "+OK"},
+		{"RANDOMKEY", "+QUEUED"},
+		{"EXEC", `["a"]`},
+	})
+	s.ctx.capture.flush()
+	commands, err := readCapture(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"MSET " + string(redactKey([]byte("a"))) + " 1 " + string(redactKey([]byte("b"))) + " 2",
+		"HSET " + string(redactKey([]byte("h"))) + " a 1",
+		"XREAD STREAMS " + string(redactKey([]byte("s1"))) + " " + string(redactKey([]byte("s2"))) + " 0-0 0-0",
+	}
+	for i, e := range expected {
+		if got := string(bytes.Join(commands[i].args, []byte(" "))); got != e {
+			t.Errorf("Got %s, expected %s", got, e)
+		}
+	}
+	redacted := func(key string) string {
+		k := string(redactKey([]byte(key)))
+		return "$" + strconv.Itoa(len(k)) + "\r\n" + k + "\r\n"
+	}
+	replies := map[int]string{
+		4:  "*2\r\n" + redacted("l") + "$1\r\na\r\n",
+		6:  "*1\r\n*2\r\n" + redacted("s1") + "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n",
+		12: "$-1\r\n",
+		14: redacted("a"),
+		17: "",
+	}
+	for i, e := range replies {
+		if got := string(commands[i].reply); got != e {
+			t.Errorf("Got %q for %s, expected %q", got, commands[i].args[0], e)
+		}
+	}
+}
+
+// A call of each command, the keys being the arguments starting with "key".
+var captureKeySamples = map[string]string{
+	"EXISTS":       "EXISTS key1 key2",
+	"DEL":          "DEL key1 key2",
+	"GET":          "GET key1",
+	"SET":          "SET key1 v",
+	"SETEX":        "SETEX key1 10 v",
+	"SETNXEX":      "SETNXEX key1 10 v",
+	"SETNX":        "SETNX key1 v",
+	"MGET":         "MGET key1 key2",
+	"MSET":         "MSET key1 a key2 b",
+	"MSETNX":       "MSETNX key1 a key2 b",
+	"APPEND":       "APPEND key1 v",
+	"STRLEN":       "STRLEN key1",
+	"GETRANGE":     "GETRANGE key1 0 1",
+	"SETRANGE":     "SETRANGE key1 0 v",
+	"GETSET":       "GETSET key1 v",
+	"GETDEL":       "GETDEL key1",
+	"GETEX":        "GETEX key1 EX 10",
+	"INCRBYFLOAT":  "INCRBYFLOAT key1 0.1",
+	"SETBIT":       "SETBIT key1 7 1",
+	"GETBIT":       "GETBIT key1 7",
+	"BITCOUNT":     "BITCOUNT key1 0 1",
+	"BITPOS":       "BITPOS key1 1",
+	"BITOP":        "BITOP AND key1 key2 key3",
+	"PFADD":        "PFADD key1 a",
+	"PFADDEX":      "PFADDEX key1 10 a",
+	"PFCOUNT":      "PFCOUNT key1 key2",
+	"PFMERGE":      "PFMERGE key1 key2 key3",
+	"PFMERGEEX":    "PFMERGEEX key1 10 key2 key3",
+	"GEOADD":       "GEOADD key1 2.35 48.85 paris",
+	"GEOPOS":       "GEOPOS key1 paris",
+	"GEOHASH":      "GEOHASH key1 paris",
+	"GEODIST":      "GEODIST key1 paris lyon",
+	"GEOSEARCH":    "GEOSEARCH key1 FROMMEMBER paris BYRADIUS 10 km",
+	"XADD":         "XADD key1 * a 1",
+	"XLEN":         "XLEN key1",
+	"XRANGE":       "XRANGE key1 - +",
+	"XREVRANGE":    "XREVRANGE key1 + -",
+	"XDEL":         "XDEL key1 1-0",
+	"XTRIM":        "XTRIM key1 MAXLEN 10",
+	"XREAD":        "XREAD COUNT 1 STREAMS key1 key2 0-0 0-0",
+	"XGROUP":       "XGROUP CREATE key1 g1 $",
+	"XREADGROUP":   "XREADGROUP GROUP g1 c1 STREAMS key1 >",
+	"XACK":         "XACK key1 g1 1-0",
+	"XPENDING":     "XPENDING key1 g1",
+	"LLEN":         "LLEN key1",
+	"RPUSH":        "RPUSH key1 a",
+	"LPUSH":        "LPUSH key1 a",
+	"RPUSHEX":      "RPUSHEX key1 a 10",
+	"LPUSHEX":      "LPUSHEX key1 a 10",
+	"RPOP":         "RPOP key1",
+	"LPOP":         "LPOP key1",
+	"LRANGE":       "LRANGE key1 0 -1",
+	"LTRIM":        "LTRIM key1 0 -1",
+	"RPUSHX":       "RPUSHX key1 a",
+	"LPUSHX":       "LPUSHX key1 a",
+	"LINDEX":       "LINDEX key1 0",
+	"LSET":         "LSET key1 0 a",
+	"LINSERT":      "LINSERT key1 BEFORE a b",
+	"LREM":         "LREM key1 0 a",
+	"LPOS":         "LPOS key1 a",
+	"BLPOP":        "BLPOP key1 key2 0",
+	"BRPOP":        "BRPOP key1 key2 0",
+	"BLMOVE":       "BLMOVE key1 key2 LEFT RIGHT 0",
+	"BRPOPLPUSH":   "BRPOPLPUSH key1 key2 0",
+	"RPOPLPUSH":    "RPOPLPUSH key1 key2",
+	"LMOVE":        "LMOVE key1 key2 LEFT RIGHT",
+	"INCR":         "INCR key1",
+	"INCRBY":       "INCRBY key1 2",
+	"INCRBYEX":     "INCRBYEX key1 10 2",
+	"HINCRBY":      "HINCRBY key1 f 1",
+	"HINCRBYEX":    "HINCRBYEX key1 f 1 10",
+	"DECR":         "DECR key1",
+	"DECRBY":       "DECRBY key1 2",
+	"DECRBYEX":     "DECRBYEX key1 10 2",
+	"HGET":         "HGET key1 f",
+	"HSET":         "HSET key1 f v",
+	"HSETEX":       "HSETEX key1 10 f v",
+	"HDEL":         "HDEL key1 f",
+	"HMGET":        "HMGET key1 f g",
+	"HMSET":        "HMSET key1 f v",
+	"HMINCRBYEX":   "HMINCRBYEX key1 10 f 1",
+	"HGETALL":      "HGETALL key1",
+	"HEXISTS":      "HEXISTS key1 f",
+	"HLEN":         "HLEN key1",
+	"HKEYS":        "HKEYS key1",
+	"HVALS":        "HVALS key1",
+	"HSETNX":       "HSETNX key1 f v",
+	"HSTRLEN":      "HSTRLEN key1 f",
+	"HINCRBYFLOAT": "HINCRBYFLOAT key1 f 0.1",
+	"HSCAN":        "HSCAN key1 0",
+	"EXPIRE":       "EXPIRE key1 10",
+	"TTL":          "TTL key1",
+	"PEXPIRE":      "PEXPIRE key1 10",
+	"EXPIREAT":     "EXPIREAT key1 10",
+	"PEXPIREAT":    "PEXPIREAT key1 10",
+	"PTTL":         "PTTL key1",
+	"EXPIRETIME":   "EXPIRETIME key1",
+	"PEXPIRETIME":  "PEXPIRETIME key1",
+	"PERSIST":      "PERSIST key1",
+	"RENAME":       "RENAME key1 key2",
+	"RENAMENX":     "RENAMENX key1 key2",
+	"COPY":         "COPY key1 key2 REPLACE",
+	"TYPE":         "TYPE key1",
+	"RANDOMKEY":    "RANDOMKEY",
+	"FLUSHDB":      "FLUSHDB",
+	"SLOWLOG":      "SLOWLOG GET 10",
+}
+
+func TestCaptureKeyIndexes(t *testing.T) {
+	for cmd := range standardHandlers() {
+		sample, ok := captureKeySamples[cmd]
+		if !ok {
+			t.Errorf("No sample for %s", cmd)
+			continue
+		}
+		args := bytes.Split([]byte(sample), []byte(" "))
+		expected := make([]int, 0)
+		for i, a := range args {
+			if bytes.HasPrefix(a, []byte("key")) {
+				expected = append(expected, i)
+			}
+		}
+		if got := keyIndexes(args); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%s: got %v, expected %v", sample, got, expected)
+		}
+	}
+}
+
+func TestCaptureSample(t *testing.T) {
+	s, fname := captureServer(t, map[string]interface{}{"capture_sample": 0.0})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{{"SET a 1", "+OK"}})
+	s.ctx.capture.flush()
+	commands, err := readCapture(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 0 {
+		t.Errorf("Got %d captured commands", len(commands))
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// In cdt map mode, the whole Redis map is stored in a single Aerospike map bin.
+// Field names are not limited by the bin name length, and modifications
+// are done atomically by the Aerospike server.
+
+var cdtMapPolicy = newMapPolicy(false, false)
+var cdtMapCreateOnlyPolicy = newMapPolicy(false, true)
+
+// Operate returns a single value when only one operation is done on a bin,
+// and a list of values otherwise. When the first value is a list, the other
+// values are appended to it, so the operations returning lists are preceded
+// by a map size operation.
+func binResults(rec *as.Record, name string, count int) []interface{} {
+	if rec == nil {
+		return make([]interface{}, count)
+	}
+	x := rec.Bins[name]
+	if count == 1 {
+		return []interface{}{x}
+	}
+	switch x.(type) {
+	case []interface{}:
+		a := x.([]interface{})
+		if len(a) == count {
+			return a
+		}
+		res := make([]interface{}, count-len(a), count)
+		return append(res, a...)
+	}
+	res := make([]interface{}, count)
+	res[count-1] = x
+	return res
+}
+
+func cmdCdtMapHGET(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapGetByKeyOp(binName, string(args[1]), returnValue))
+	if err != nil {
+		return err
+	}
+	return writeBin(wf, rec, binName, "$-1")
+}
+
+func cdtMapHset(wf io.Writer, ctx *context, k []byte, args [][]byte, ttl int) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	fields, values := hashFieldValues(ctx, args)
+	m := make(map[interface{}]interface{})
+	ops := make([]*operation, len(fields)+1)
+	for i, f := range fields {
+		ops[i] = mapGetByKeyOp(binName, f, returnCount)
+		m[f] = values[i]
+	}
+	ops[len(fields)] = mapPutItemsOp(cdtMapPolicy, binName, m)
+	rec, err := operate(ctx, createWritePolicyEx(ttl, false), key, ops...)
+	if err != nil {
+		return err
+	}
+	added := 0
+	for _, existed := range binResults(rec, binName, len(ops))[:len(fields)] {
+		if existed == nil || existed.(int) == 0 {
+			added++
+		}
+	}
+	return writeLine(wf, ":"+strconv.Itoa(added))
+}
+
+func cmdCdtMapHSET(wf io.Writer, ctx *context, args [][]byte) error {
+	if len(args)%2 != 1 {
+		return fmt.Errorf("Wrong number of params for 'HSET': %d", len(args))
+	}
+	return cdtMapHset(wf, ctx, args[0], args[1:], -1)
+}
+
+func cmdCdtMapHSETEX(wf io.Writer, ctx *context, args [][]byte) error {
+	ttl, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	return cdtMapHset(wf, ctx, args[0], args[2:4], ttl)
+}
+
+func cmdCdtMapHDEL(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fields := hashFields(args[1:])
+	keys := make([]interface{}, len(fields))
+	for i, f := range fields {
+		keys[i] = f
+	}
+	rec, err := ctx.client.Operate(createWritePolicyUpdateOnly(), key, mapRemoveByKeyListOp(binName, keys, returnCount), mapSizeOp(binName))
+	if err != nil {
+		if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+			return writeLine(wf, ":0")
+		}
+		return err
+	}
+	res := binResults(rec, binName, 2)
+	err = cdtMapDeleteIfEmpty(ctx, key, rec, res[1])
+	if err != nil {
+		return err
+	}
+	if res[0] == nil {
+		return writeLine(wf, ":0")
+	}
+	return writeLine(wf, ":"+strconv.Itoa(res[0].(int)))
+}
+
+// Redis deletes a map when its last field is removed. The delete is
+// generation checked, so a concurrent write wins.
+func cdtMapDeleteIfEmpty(ctx *context, key *as.Key, rec *as.Record, size interface{}) error {
+	if size == nil || size.(int) > 0 {
+		return nil
+	}
+	_, err := ctx.client.Delete(createWritePolicyGeneration(rec.Generation, -1), key)
+	if err != nil && errResultCode(err) != ase.GENERATION_ERROR {
+		return err
+	}
+	return nil
+}
+
+func cmdCdtMapHMGET(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	ops := make([]*operation, len(args)-1)
+	for i, e := range args[1:] {
+		ops[i] = mapGetByKeyOp(binName, string(e), returnValue)
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, ops...)
+	if err != nil {
+		return err
+	}
+	res := binResults(rec, binName, len(ops))
+	err = writeLine(wf, "*"+strconv.Itoa(len(res)))
+	if err != nil {
+		return err
+	}
+	for _, e := range res {
+		if e == nil {
+			err = writeLine(wf, "$-1")
+		} else {
+			err = writeValue(wf, e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdCdtMapHMSET(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	m := make(map[interface{}]interface{})
+	for i := 1; i+1 < len(args); i += 2 {
+		m[string(args[i])] = encode(ctx, args[i+1])
+	}
+	_, err = operate(ctx, ctx.writePolicy, key, mapPutItemsOp(cdtMapPolicy, binName, m))
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, "+OK")
+}
+
+func cdtMapHIncrByEx(wf io.Writer, ctx *context, k []byte, field string, incr int, ttl int) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	rec, err := operate(ctx, createWritePolicyEx(ttl, false), key, mapIncrementOp(cdtMapPolicy, binName, field, incr))
+	if err != nil {
+		code := errResultCode(err)
+		if code == ase.BIN_TYPE_ERROR || code == ase.PARAMETER_ERROR {
+			return writeLine(wf, "$-1")
+		}
+		return err
+	}
+	return writeBinInt(wf, rec, binName)
+}
+
+func cmdCdtMapHINCRBY(wf io.Writer, ctx *context, args [][]byte) error {
+	incr, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return err
+	}
+	return cdtMapHIncrByEx(wf, ctx, args[0], string(args[1]), incr, -1)
+}
+
+func cmdCdtMapHINCRBYEX(wf io.Writer, ctx *context, args [][]byte) error {
+	incr, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return err
+	}
+	ttl, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		return err
+	}
+	return cdtMapHIncrByEx(wf, ctx, args[0], string(args[1]), incr, ttl)
+}
+
+func cmdCdtMapHMINCRBYEX(wf io.Writer, ctx *context, args [][]byte) error {
+	if len(args) == 2 {
+		return cmdHMINCRBYEX(wf, ctx, args)
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	ttl, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	ops := make([]*operation, (len(args)-2)/2)
+	for i := 2; i+1 < len(args); i += 2 {
+		incr, err := strconv.Atoi(string(args[i+1]))
+		if err != nil {
+			return err
+		}
+		ops[(i/2)-1] = mapIncrementOp(cdtMapPolicy, binName, string(args[i]), incr)
+	}
+	_, err = operate(ctx, createWritePolicyEx(ttl, false), key, ops...)
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, "+OK")
+}
+
+func cmdCdtMapHGETALL(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.Bins[binName] == nil {
+		return writeLine(wf, "*0")
+	}
+	m, ok := rec.Bins[binName].(map[interface{}]interface{})
+	if !ok {
+		return errWrongType
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(m)*2))
+	if err != nil {
+		return err
+	}
+	for k, v := range m {
+		err = writeValue(wf, k)
+		if err != nil {
+			return err
+		}
+		err = writeValue(wf, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdCdtMapHEXISTS(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapGetByKeyOp(binName, string(args[1]), returnCount))
+	if err != nil {
+		return err
+	}
+	return writeBinInt(wf, rec, binName)
+}
+
+func cmdCdtMapHSTRLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapGetByKeyOp(binName, string(args[1]), returnValue))
+	if err != nil {
+		return err
+	}
+	return writeBinLen(wf, rec, binName)
+}
+
+func cmdCdtMapHLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapSizeOp(binName))
+	if err != nil {
+		return err
+	}
+	return writeBinInt(wf, rec, binName)
+}
+
+func cdtMapGetAll(wf io.Writer, ctx *context, k []byte, op *operation) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, op)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.Bins[binName] == nil {
+		return writeLine(wf, "*0")
+	}
+	return writeArray(wf, rec.Bins[binName].([]interface{}))
+}
+
+func cmdCdtMapHKEYS(wf io.Writer, ctx *context, args [][]byte) error {
+	return cdtMapGetAll(wf, ctx, args[0], mapGetByIndexRangeOp(binName, 0, returnKey))
+}
+
+func cmdCdtMapHVALS(wf io.Writer, ctx *context, args [][]byte) error {
+	return cdtMapGetAll(wf, ctx, args[0], mapGetByIndexRangeOp(binName, 0, returnValue))
+}
+
+func cmdCdtMapHSETNX(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, err = operate(ctx, ctx.writePolicy, key, mapPutOp(cdtMapCreateOnlyPolicy, binName, string(args[1]), encode(ctx, args[2])))
+	if err != nil {
+		if errResultCode(err) == ase.FAIL_ELEMENT_EXISTS {
+			return writeLine(wf, ":0")
+		}
+		return err
+	}
+	return writeLine(wf, ":1")
+}
+
+// The names are read to select the page, then the values of the page. The
+// size is read first, so the results of the other operations are not merged
+// into one list.
+func cmdCdtMapHSCAN(wf io.Writer, ctx *context, args [][]byte) error {
+	scan, err := parseScanArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapSizeOp(binName), mapGetByIndexRangeOp(binName, 0, returnKey))
+	if err != nil {
+		return err
+	}
+	keys, _ := binResults(rec, binName, 2)[1].([]interface{})
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.(string)
+	}
+	cursor, page := scan.page(names)
+	if len(page) == 0 {
+		return writeScanResult(wf, cursor, [][]byte{}, []interface{}{})
+	}
+	selected := make([]interface{}, len(page))
+	for i, e := range page {
+		selected[i] = e
+	}
+	rec, err = ctx.client.Operate(ctx.writePolicy, key, mapSizeOp(binName), mapGetByKeyListOp(binName, selected, returnKey), mapGetByKeyListOp(binName, selected, returnValue))
+	if err != nil {
+		return err
+	}
+	res := binResults(rec, binName, 3)
+	found, _ := res[1].([]interface{})
+	values, _ := res[2].([]interface{})
+	fields := make([][]byte, len(found))
+	for i, k := range found {
+		fields[i] = []byte(k.(string))
+	}
+	return writeScanResult(wf, cursor, fields, values)
+}
+
+func tryCdtMapHIncrByFloat(ctx *context, key *as.Key, field string, incr float64) ([]byte, error) {
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapGetByKeyOp(binName, field, returnValue))
+	if err != nil {
+		return nil, err
+	}
+	var current interface{}
+	policy := createWritePolicyEx(-1, true)
+	if rec != nil {
+		current = rec.Bins[binName]
+		policy = createWritePolicyGeneration(rec.Generation, -1)
+	}
+	res, ok, err := incrFloat(current, incr)
+	if err != nil || !ok {
+		return nil, err
+	}
+	_, err = ctx.client.Operate(policy, key, mapPutOp(cdtMapPolicy, binName, field, encode(ctx, res)))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func cmdCdtMapHINCRBYFLOAT(wf io.Writer, ctx *context, args [][]byte) error {
+	incr, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		res, err := tryCdtMapHIncrByFloat(ctx, key, string(args[1]), incr)
+		if err == nil {
+			if res == nil {
+				return writeLine(wf, "$-1")
+			}
+			return writeByteArray(wf, res)
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for hincrbyfloat")
+}
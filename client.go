@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A connection of a Redis client, registered while it is open. The
+// registry is shared by all the sets, so CLIENT LIST and CLIENT KILL see all
+// the clients of the process.
+type client struct {
+	id           int64
+	conn         net.Conn
+	addr         string
+	set          string
+	created      time.Time
+	lastActivity int64
+	netIn        uint64
+	netOut       uint64
+	monitor      int32
+	killed       int32
+	lock         sync.Mutex
+	name         string
+	cmd          string
+}
+
+type clientRegistry struct {
+	lock    sync.Mutex
+	nextID  int64
+	clients map[int64]*client
+}
+
+var clients = &clientRegistry{clients: make(map[int64]*client)}
+
+// Clients are paused until this time, in nanoseconds.
+var clientsPausedUntil int64
+
+// Counts the bytes read and written on a connection.
+type trackedConn struct {
+	net.Conn
+	client *client
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.client.netIn, uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.client.netOut, uint64(n))
+	return n, err
+}
+
+// Registers a new connection, and returns it wrapped to count its bytes.
+func (r *clientRegistry) add(conn net.Conn, set string) (*client, net.Conn) {
+	now := time.Now()
+	c := &client{conn: conn, addr: clientAddr(conn), set: set, created: now, lastActivity: now.UnixNano()}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nextID++
+	c.id = r.nextID
+	r.clients[c.id] = c
+	return c, &trackedConn{conn, c}
+}
+
+func (r *clientRegistry) remove(c *client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.clients, c.id)
+}
+
+// Returns the clients, ordered by id.
+func (r *clientRegistry) list() []*client {
+	r.lock.Lock()
+	res := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		res = append(res, c)
+	}
+	r.lock.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].id < res[j].id })
+	return res
+}
+
+// Called when a command is received.
+func (c *client) command(cmd string) {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cmd = strings.ToLower(cmd)
+}
+
+// Formats the client like the CLIENT LIST lines of Redis, with the set.
+func (c *client) info() string {
+	now := time.Now()
+	c.lock.Lock()
+	name := c.name
+	cmd := c.cmd
+	c.lock.Unlock()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	flags := "N"
+	if atomic.LoadInt32(&c.monitor) == 1 {
+		flags = "O"
+	}
+	idle := now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActivity)))
+	return "id=" + strconv.FormatInt(c.id, 10) +
+		" addr=" + c.addr +
+		" laddr=" + c.conn.LocalAddr().String() +
+		" name=" + name +
+		" age=" + strconv.Itoa(int(now.Sub(c.created)/time.Second)) +
+		" idle=" + strconv.Itoa(int(idle/time.Second)) +
+		" flags=" + flags +
+		" db=0 set=" + c.set +
+		" tot-net-in=" + strconv.FormatUint(atomic.LoadUint64(&c.netIn), 10) +
+		" tot-net-out=" + strconv.FormatUint(atomic.LoadUint64(&c.netOut), 10) +
+		" cmd=" + cmd + "\n"
+}
+
+// Closes the connection, the client is removed when its command loop ends.
+func (c *client) kill() {
+	atomic.StoreInt32(&c.killed, 1)
+	c.conn.Close()
+}
+
+// Waits while the clients are paused.
+func waitClientsPause() {
+	for {
+		d := time.Until(time.Unix(0, atomic.LoadInt64(&clientsPausedUntil)))
+		if d <= 0 {
+			return
+		}
+		if d > 10*time.Millisecond {
+			d = 10 * time.Millisecond
+		}
+		time.Sleep(d)
+	}
+}
+
+// Same rule as Redis: names cannot contain spaces, newlines or special
+// characters.
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Applies the filters of the new CLIENT KILL form, and returns the number of
+// killed clients.
+func killClients(wf io.Writer, c *client, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return writeErrorReply(wf, "syntax error")
+	}
+	skipMe := true
+	filters := make(map[string]string)
+	for i := 0; i < len(args); i += 2 {
+		filter := strings.ToUpper(string(args[i]))
+		value := string(args[i+1])
+		switch filter {
+		case "ID", "ADDR", "LADDR", "SET":
+			filters[filter] = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return writeErrorReply(wf, "syntax error")
+			}
+		default:
+			return writeErrorReply(wf, "syntax error")
+		}
+	}
+	if id, ok := filters["ID"]; ok {
+		_, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return writeErrorReply(wf, "client-id should be greater than 0")
+		}
+	}
+	killed := 0
+	for _, other := range clients.list() {
+		if skipMe && other == c {
+			continue
+		}
+		if id, ok := filters["ID"]; ok && id != strconv.FormatInt(other.id, 10) {
+			continue
+		}
+		if addr, ok := filters["ADDR"]; ok && addr != other.addr {
+			continue
+		}
+		if laddr, ok := filters["LADDR"]; ok && laddr != other.conn.LocalAddr().String() {
+			continue
+		}
+		if set, ok := filters["SET"]; ok && set != other.set {
+			continue
+		}
+		other.kill()
+		killed++
+	}
+	return writeLine(wf, ":"+strconv.Itoa(killed))
+}
+
+// CLIENT is handled with the connection, not with the other commands, as it
+// needs the client.
+func handleClientCommand(wf io.Writer, c *client, args [][]byte) error {
+	if len(args) < 2 {
+		return writeErrorReply(wf, "wrong number of arguments for 'client' command")
+	}
+	name := string(args[1])
+	sub := strings.ToUpper(name)
+	args = args[2:]
+	switch sub {
+	case "ID":
+		return writeLine(wf, ":"+strconv.FormatInt(c.id, 10))
+	case "SETNAME":
+		if len(args) != 1 {
+			return writeErrorReply(wf, "wrong number of arguments for 'client|setname' command")
+		}
+		if !validClientName(args[0]) {
+			return writeErrorReply(wf, "Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.lock.Lock()
+		c.name = string(args[0])
+		c.lock.Unlock()
+		return writeLine(wf, "+OK")
+	case "GETNAME":
+		c.lock.Lock()
+		clientName := c.name
+		c.lock.Unlock()
+		if clientName == "" {
+			return writeLine(wf, "$-1")
+		}
+		return writeByteArray(wf, []byte(clientName))
+	case "INFO":
+		return writeByteArray(wf, []byte(c.info()))
+	case "LIST":
+		ids := make(map[string]bool)
+		if len(args) > 0 {
+			if strings.ToUpper(string(args[0])) != "ID" || len(args) == 1 {
+				return writeErrorReply(wf, "syntax error")
+			}
+			for _, id := range args[1:] {
+				ids[string(id)] = true
+			}
+		}
+		var buf bytes.Buffer
+		for _, other := range clients.list() {
+			if len(ids) == 0 || ids[strconv.FormatInt(other.id, 10)] {
+				buf.WriteString(other.info())
+			}
+		}
+		return writeByteArray(wf, buf.Bytes())
+	case "KILL":
+		if len(args) == 1 {
+			// Old form, with only the address of the client
+			for _, other := range clients.list() {
+				if other.addr == string(args[0]) {
+					other.kill()
+					return writeLine(wf, "+OK")
+				}
+			}
+			return writeErrorReply(wf, "No such client")
+		}
+		return killClients(wf, c, args)
+	case "PAUSE":
+		if len(args) < 1 || len(args) > 2 {
+			return writeErrorReply(wf, "wrong number of arguments for 'client|pause' command")
+		}
+		timeout, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil || timeout < 0 {
+			return writeErrorReply(wf, "timeout is not an integer or out of range")
+		}
+		if len(args) == 2 && strings.ToUpper(string(args[1])) != "ALL" {
+			return writeErrorReply(wf, "only the ALL pause mode is supported")
+		}
+		atomic.StoreInt64(&clientsPausedUntil, time.Now().Add(time.Duration(timeout)*time.Millisecond).UnixNano())
+		return writeLine(wf, "+OK")
+	case "UNPAUSE":
+		atomic.StoreInt64(&clientsPausedUntil, 0)
+		return writeLine(wf, "+OK")
+	}
+	return writeErrorReply(wf, "unknown subcommand '"+name+"'. Try CLIENT ID, SETNAME, GETNAME, INFO, LIST, KILL, PAUSE or UNPAUSE.")
+}
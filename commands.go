@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 
 	as "github.com/aerospike/aerospike-client-go"
 	ase "github.com/aerospike/aerospike-client-go/types"
@@ -96,7 +102,7 @@ func cmdMSET(wf io.Writer, ctx *context, args [][]byte) error {
 		if err != nil {
 			return err
 		}
-		err = ctx.client.PutBins(ctx.writePolicy, key, as.NewBin(binName, encode(ctx, args[i+1])))
+		err = ctx.client.PutBins(createWritePolicyEx(-2, false), key, as.NewBin(binName, encode(ctx, args[i+1])))
 		if err != nil {
 			return err
 		}
@@ -117,50 +123,79 @@ func cmdSETNXEX(wf io.Writer, ctx *context, args [][]byte) error {
 	return setex(wf, ctx, args[0], binName, args[2], ttl, true)
 }
 
-func tryHSet(ctx *context, key *as.Key, field string, value interface{}, ttl int) (bool, error) {
-	policy := as.NewPolicy()
-	policy.ReplicaPolicy = as.MASTER
-	rec, err := ctx.client.Get(policy, key, field)
-	if err != nil {
-		return false, err
+// Returns the fields and the encoded values of a list of field / value pairs.
+// When a field is given more than once, the last value wins, like in Redis.
+func hashFieldValues(ctx *context, args [][]byte) ([]string, []interface{}) {
+	index := make(map[string]int)
+	fields := make([]string, 0, len(args)/2)
+	values := make([]interface{}, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		field := string(args[i])
+		j, ok := index[field]
+		if ok {
+			values[j] = encode(ctx, args[i+1])
+			continue
+		}
+		index[field] = len(fields)
+		fields = append(fields, field)
+		values = append(values, encode(ctx, args[i+1]))
 	}
-	var generation uint32
-	if rec != nil {
-		generation = rec.Generation
-	} else {
-		generation = 0
+	return fields, values
+}
+
+func hashFields(args [][]byte) []string {
+	index := make(map[string]bool)
+	fields := make([]string, 0, len(args))
+	for _, e := range args {
+		if !index[string(e)] {
+			index[string(e)] = true
+			fields = append(fields, string(e))
+		}
 	}
-	err = ctx.client.PutBins(createWritePolicyGeneration(generation, ttl), key, as.NewBin(field, value))
+	return fields
+}
+
+func tryHSet(ctx *context, key *as.Key, fields []string, values []interface{}, ttl int) (int, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, fields...)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	if rec == nil {
-		return false, nil
+	existing := 0
+	policy := createWritePolicyEx(ttl, true)
+	if rec != nil {
+		policy = createWritePolicyGeneration(rec.Generation, ttl)
+		for _, f := range fields {
+			if rec.Bins[f] != nil {
+				existing++
+			}
+		}
 	}
-	if rec.Bins[field] == nil {
-		return false, nil
+	bins := make([]*as.Bin, len(fields))
+	for i, f := range fields {
+		bins[i] = as.NewBin(f, values[i])
 	}
-	return true, nil
+	err = ctx.client.PutBins(policy, key, bins...)
+	if err != nil {
+		return 0, err
+	}
+	return existing, nil
 }
 
-func hset(wf io.Writer, ctx *context, k []byte, kk []byte, v interface{}, ttl int, set bool) error {
+func hset(wf io.Writer, ctx *context, k []byte, fields []string, values []interface{}, ttl int, set bool) error {
 	key, err := buildKey(ctx, k)
 	if err != nil {
 		return err
 	}
-	field := string(kk)
 	for i := 0; i < ctx.generationRetries; i++ {
-		existed, err := tryHSet(ctx, key, field, v, ttl)
+		existing, err := tryHSet(ctx, key, fields, values, ttl)
 		if err == nil {
-			if !set {
-				existed = !existed
-			}
-			if existed {
-				return writeLine(wf, ":0")
+			if set {
+				return writeLine(wf, ":"+strconv.Itoa(len(fields)-existing))
 			}
-			return writeLine(wf, ":1")
+			return writeLine(wf, ":"+strconv.Itoa(existing))
 		}
-		if errResultCode(err) != ase.GENERATION_ERROR {
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
 			return err
 		}
 	}
@@ -168,7 +203,11 @@ func hset(wf io.Writer, ctx *context, k []byte, kk []byte, v interface{}, ttl in
 }
 
 func cmdHSET(wf io.Writer, ctx *context, args [][]byte) error {
-	return hset(wf, ctx, args[0], args[1], args[2], -1, true)
+	if len(args)%2 != 1 {
+		return fmt.Errorf("Wrong number of params for 'HSET': %d", len(args))
+	}
+	fields, values := hashFieldValues(ctx, args[1:])
+	return hset(wf, ctx, args[0], fields, values, -1, true)
 }
 
 func cmdHSETEX(wf io.Writer, ctx *context, args [][]byte) error {
@@ -176,92 +215,336 @@ func cmdHSETEX(wf io.Writer, ctx *context, args [][]byte) error {
 	if err != nil {
 		return err
 	}
-	return hset(wf, ctx, args[0], args[2], args[3], ttl, true)
+	return hset(wf, ctx, args[0], []string{string(args[2])}, []interface{}{encode(ctx, args[3])}, ttl, true)
 }
 
 func cmdHDEL(wf io.Writer, ctx *context, args [][]byte) error {
-	return hset(wf, ctx, args[0], args[1], nil, -1, false)
+	fields := hashFields(args[1:])
+	return hset(wf, ctx, args[0], fields, make([]interface{}, len(fields)), -1, false)
+}
+
+func tryHSetNX(ctx *context, key *as.Key, field string, value interface{}) (bool, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, field)
+	if err != nil {
+		return false, err
+	}
+	policy := createWritePolicyEx(-1, true)
+	if rec != nil {
+		if rec.Bins[field] != nil {
+			return false, nil
+		}
+		policy = createWritePolicyGeneration(rec.Generation, -1)
+	}
+	err = ctx.client.PutBins(policy, key, as.NewBin(field, value))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func cmdHSETNX(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	field := string(args[1])
+	value := encode(ctx, args[2])
+	for i := 0; i < ctx.generationRetries; i++ {
+		created, err := tryHSetNX(ctx, key, field, value)
+		if err == nil {
+			if created {
+				return writeLine(wf, ":1")
+			}
+			return writeLine(wf, ":0")
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for hsetnx")
+}
+
+func cmdHEXISTS(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	field := string(args[1])
+	rec, err := ctx.client.Get(ctx.readPolicy, key, field)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.Bins[field] == nil {
+		return writeLine(wf, ":0")
+	}
+	return writeLine(wf, ":1")
+}
+
+func cmdHSTRLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	field := string(args[1])
+	rec, err := ctx.client.Get(ctx.readPolicy, key, field)
+	if err != nil {
+		return err
+	}
+	return writeBinLen(wf, rec, field)
+}
+
+func hashRecord(ctx *context, k []byte) (*as.Record, error) {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.client.Get(ctx.readPolicy, key)
+}
+
+func cmdHLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	rec, err := hashRecord(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeLine(wf, ":0")
+	}
+	return writeLine(wf, ":"+strconv.Itoa(len(rec.Bins)))
+}
+
+func cmdHKEYS(wf io.Writer, ctx *context, args [][]byte) error {
+	rec, err := hashRecord(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	res := make([]interface{}, 0)
+	if rec != nil {
+		for k := range rec.Bins {
+			res = append(res, k)
+		}
+	}
+	return writeArray(wf, res)
+}
+
+func cmdHVALS(wf io.Writer, ctx *context, args [][]byte) error {
+	rec, err := hashRecord(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	res := make([]interface{}, 0)
+	if rec != nil {
+		for _, v := range rec.Bins {
+			res = append(res, v)
+		}
+	}
+	return writeArray(wf, res)
+}
+
+func cmdHSCAN(wf io.Writer, ctx *context, args [][]byte) error {
+	scan, err := parseScanArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	rec, err := hashRecord(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeScanResult(wf, 0, [][]byte{}, []interface{}{})
+	}
+	names := make([]string, 0, len(rec.Bins))
+	for k := range rec.Bins {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	cursor, page := scan.page(names)
+	fields := make([][]byte, len(page))
+	values := make([]interface{}, len(page))
+	for i, e := range page {
+		fields[i] = []byte(e)
+		values[i] = rec.Bins[e]
+	}
+	return writeScanResult(wf, cursor, fields, values)
+}
+
+func tryHIncrByFloat(ctx *context, key *as.Key, field string, incr float64) ([]byte, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, field)
+	if err != nil {
+		return nil, err
+	}
+	var current interface{}
+	policy := createWritePolicyEx(-1, true)
+	if rec != nil {
+		current = rec.Bins[field]
+		policy = createWritePolicyGeneration(rec.Generation, -1)
+	}
+	res, ok, err := incrFloat(current, incr)
+	if err != nil || !ok {
+		return nil, err
+	}
+	err = ctx.client.PutBins(policy, key, as.NewBin(field, encode(ctx, res)))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
-func listOpReturnSize(wf io.Writer, ctx *context, args [][]byte, ttl int, op *as.Operation) error {
+func cmdHINCRBYFLOAT(wf io.Writer, ctx *context, args [][]byte) error {
+	incr, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
 	key, err := buildKey(ctx, args[0])
 	if err != nil {
 		return err
 	}
-	rec, err := ctx.client.Operate(createWritePolicyEx(ttl, false), key, op, as.AddOp(as.NewBin(sizeArrayField, 1)))
+	for i := 0; i < ctx.generationRetries; i++ {
+		res, err := tryHIncrByFloat(ctx, key, string(args[1]), incr)
+		if err == nil {
+			if res == nil {
+				return writeLine(wf, "$-1")
+			}
+			return writeByteArray(wf, res)
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for hincrbyfloat")
+}
+
+func listOpReturnSize(wf io.Writer, ctx *context, key *as.Key, policy *as.WritePolicy, count int, op *operation) error {
+	rec, err := operate(ctx, policy, key, op, addOp(as.NewBin(sizeArrayField, count)))
 	if err != nil {
+		if policy.RecordExistsAction == as.UPDATE_ONLY && errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+			return writeLine(wf, ":0")
+		}
 		return err
 	}
 	return writeBinInt(wf, rec, binName)
 }
 
-func arrayRPush(wf io.Writer, ctx *context, args [][]byte, ttl int) error {
+func encodeAll(ctx *context, args [][]byte) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, e := range args {
+		values[i] = encode(ctx, e)
+	}
+	return values
+}
+
+func arrayRPush(wf io.Writer, ctx *context, k []byte, values [][]byte, policy *as.WritePolicy) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
 	// ListInsertOp does not like to be called on an empty list and -1
 	// ListAppendOp is ok
-	return listOpReturnSize(wf, ctx, args, ttl, as.ListAppendOp(binName, encode(ctx, args[1])))
+	err = listOpReturnSize(wf, ctx, key, policy, len(values), listAppendOp(binName, encodeAll(ctx, values)...))
+	if err != nil {
+		return err
+	}
+	ctx.listWaiters.notify(k)
+	return nil
 }
 
 func cmdRPUSH(wf io.Writer, ctx *context, args [][]byte) error {
-	return arrayRPush(wf, ctx, args, -1)
+	return arrayRPush(wf, ctx, args[0], args[1:], createWritePolicyEx(-1, false))
+}
+
+func cmdRPUSHX(wf io.Writer, ctx *context, args [][]byte) error {
+	return arrayRPush(wf, ctx, args[0], args[1:], createWritePolicyUpdateOnly())
 }
 
 func cmdRPUSHEX(wf io.Writer, ctx *context, args [][]byte) error {
-	ttl, err := strconv.Atoi(string(args[2]))
+	ttl, err := strconv.Atoi(string(args[len(args)-1]))
 	if err != nil {
 		return err
 	}
-	return arrayRPush(wf, ctx, args, ttl)
+	return arrayRPush(wf, ctx, args[0], args[1:len(args)-1], createWritePolicyEx(ttl, false))
 }
 
-func arrayLPush(wf io.Writer, ctx *context, args [][]byte, ttl int) error {
-	return listOpReturnSize(wf, ctx, args, ttl, as.ListInsertOp(binName, 0, encode(ctx, args[1])))
+// Like Redis, elements are inserted one after the other at the head of the
+// list, so they end up in reverse order.
+func arrayLPush(wf io.Writer, ctx *context, k []byte, values [][]byte, policy *as.WritePolicy) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	encoded := encodeAll(ctx, values)
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	err = listOpReturnSize(wf, ctx, key, policy, len(values), listInsertOp(binName, 0, encoded...))
+	if err != nil {
+		return err
+	}
+	ctx.listWaiters.notify(k)
+	return nil
 }
 
 func cmdLPUSH(wf io.Writer, ctx *context, args [][]byte) error {
-	return arrayLPush(wf, ctx, args, -1)
+	return arrayLPush(wf, ctx, args[0], args[1:], createWritePolicyEx(-1, false))
+}
+
+func cmdLPUSHX(wf io.Writer, ctx *context, args [][]byte) error {
+	return arrayLPush(wf, ctx, args[0], args[1:], createWritePolicyUpdateOnly())
 }
 
 func cmdLPUSHEX(wf io.Writer, ctx *context, args [][]byte) error {
-	ttl, err := strconv.Atoi(string(args[2]))
+	ttl, err := strconv.Atoi(string(args[len(args)-1]))
 	if err != nil {
 		return err
 	}
-	return arrayLPush(wf, ctx, args, ttl)
+	return arrayLPush(wf, ctx, args[0], args[1:len(args)-1], createWritePolicyEx(ttl, false))
 }
 
-func arrayPop(wf io.Writer, ctx *context, args [][]byte, index int) error {
-	key, err := buildKey(ctx, args[0])
-	if err != nil {
-		return err
-	}
+// Returns the popped element, nil if the list is empty or does not exist.
+func listPop(ctx *context, key *as.Key, index int) (interface{}, error) {
+	value, _, err := listPopRecord(ctx, key, index)
+	return value, err
+}
+
+// Also returns the record after the pop, to get its generation.
+func listPopRecord(ctx *context, key *as.Key, index int) (interface{}, *as.Record, error) {
 	size, err := ctx.client.Get(ctx.readPolicy, key, sizeArrayField)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if size == nil {
-		return writeLine(wf, "$-1")
+		return nil, nil, nil
 	}
 	if size.Bins[sizeArrayField] == nil {
-		return writeLine(wf, "$-1")
+		return nil, nil, nil
 	}
 	if size.Bins[sizeArrayField].(int) == 0 {
-		return writeLine(wf, "$-1")
+		return nil, nil, nil
 	}
-	rec, err := ctx.client.Operate(ctx.writePolicy, key, as.ListPopOp(binName, index), as.AddOp(as.NewBin(sizeArrayField, -1)))
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, listPopOp(binName, index), addOp(as.NewBin(sizeArrayField, -1)))
 	code := errResultCode(err)
 	if code == ase.BIN_TYPE_ERROR || code == ase.PARAMETER_ERROR {
-		return writeLine(wf, "$-1")
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return rec.Bins[binName], rec, nil
+}
+
+func arrayPop(wf io.Writer, ctx *context, args [][]byte, index int) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
 	}
+	value, err := listPop(ctx, key, index)
 	if err != nil {
 		return err
 	}
-	result := rec.Bins[binName]
-	switch result.(type) {
-	case int:
-		return writeByteArray(wf, []byte(strconv.Itoa(result.(int))))
-	default:
-		return writeByteArray(wf, result.([]byte))
+	if value == nil {
+		return writeLine(wf, "$-1")
 	}
+	return writeValue(wf, value)
 }
 
 func cmdRPOP(wf io.Writer, ctx *context, args [][]byte) error {
@@ -272,6 +555,153 @@ func cmdLPOP(wf io.Writer, ctx *context, args [][]byte) error {
 	return arrayPop(wf, ctx, args, 0)
 }
 
+// Returns the list index corresponding to LEFT or RIGHT
+func parseListSide(buf []byte) (int, error) {
+	switch strings.ToUpper(string(buf)) {
+	case "LEFT":
+		return 0, nil
+	case "RIGHT":
+		return -1, nil
+	}
+	return 0, fmt.Errorf("Syntax error: '%s'", string(buf))
+}
+
+func listPushOp(value interface{}, index int) *operation {
+	if index == 0 {
+		return listInsertOp(binName, 0, value)
+	}
+	return listAppendOp(binName, value)
+}
+
+func tryListPush(ctx *context, key *as.Key, value interface{}, index int) error {
+	rec, err := ctx.client.GetHeader(createMasterReadPolicy(), key)
+	if err != nil {
+		return err
+	}
+	policy := createWritePolicyEx(-1, true)
+	if rec != nil {
+		policy = createWritePolicyGeneration(rec.Generation, -1)
+	}
+	_, err = ctx.client.Operate(policy, key, listPushOp(value, index), addOp(as.NewBin(sizeArrayField, 1)))
+	return err
+}
+
+// The push is generation checked, and retried after a concurrent
+// modification only. A timeout is returned as is, even if the server has
+// applied the push.
+func listPush(ctx *context, key *as.Key, value interface{}, index int) error {
+	for i := 0; i < ctx.generationRetries; i++ {
+		err := tryListPush(ctx, key, value, index)
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for list push")
+}
+
+// Puts back a popped element where it was. If the list has been modified
+// since the pop, the element is pushed anyway, so it is not lost.
+func listPushBack(ctx *context, key *as.Key, value interface{}, index int, generation uint32) error {
+	_, err := ctx.client.Operate(createWritePolicyGeneration(generation, -1), key, listPushOp(value, index), addOp(as.NewBin(sizeArrayField, 1)))
+	if errResultCode(err) == ase.GENERATION_ERROR {
+		_, err = operate(ctx, ctx.writePolicy, key, listPushOp(value, index), addOp(as.NewBin(sizeArrayField, 1)))
+	}
+	return err
+}
+
+func tryListRotate(ctx *context, key *as.Key, from int, to int) (interface{}, error) {
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, listGetOp(binName, from))
+	code := errResultCode(err)
+	if code == ase.KEY_NOT_FOUND_ERROR || code == ase.BIN_TYPE_ERROR || code == ase.PARAMETER_ERROR || code == ase.OP_NOT_APPLICABLE {
+		return nil, nil
+	}
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	value := rec.Bins[binName]
+	if value == nil {
+		return nil, nil
+	}
+	_, err = ctx.client.Operate(createWritePolicyGeneration(rec.Generation, -1), key, listRemoveOp(binName, from), listPushOp(value, to))
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Pops an element from a list and pushes it to another one. Returns nil if
+// the source list is empty. If the push fails, the element is put back in
+// the source list. The move is at least once, but not exactly once: when
+// the push times out after being applied by the server, the element is in
+// both lists. If the push back fails too, the element is lost and logged.
+func listMove(ctx *context, src []byte, dst []byte, from int, to int) (interface{}, error) {
+	srcKey, err := buildKey(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(src, dst) {
+		for i := 0; i < ctx.generationRetries; i++ {
+			value, err := tryListRotate(ctx, srcKey, from, to)
+			if errResultCode(err) != ase.GENERATION_ERROR {
+				return value, err
+			}
+		}
+		return nil, errors.New("Too many retry for list move")
+	}
+	dstKey, err := buildKey(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	value, rec, err := listPopRecord(ctx, srcKey, from)
+	if err != nil || value == nil {
+		return value, err
+	}
+	err = listPush(ctx, dstKey, value, to)
+	if err != nil {
+		pushBackErr := listPushBack(ctx, srcKey, value, from, rec.Generation)
+		if pushBackErr != nil {
+			log.Printf("[ %s ] Element lost while moving from %s to %s: %s, %s", ctx.set, string(src), string(dst), err, pushBackErr)
+		}
+		if errResultCode(err) == ase.BIN_TYPE_ERROR {
+			return nil, errWrongType
+		}
+		return nil, err
+	}
+	ctx.listWaiters.notify(dst)
+	return value, nil
+}
+
+func cmdRPOPLPUSH(wf io.Writer, ctx *context, args [][]byte) error {
+	value, err := listMove(ctx, args[0], args[1], -1, 0)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return writeLine(wf, "$-1")
+	}
+	return writeValue(wf, value)
+}
+
+func cmdLMOVE(wf io.Writer, ctx *context, args [][]byte) error {
+	from, err := parseListSide(args[2])
+	if err != nil {
+		return err
+	}
+	to, err := parseListSide(args[3])
+	if err != nil {
+		return err
+	}
+	value, err := listMove(ctx, args[0], args[1], from, to)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return writeLine(wf, "$-1")
+	}
+	return writeValue(wf, value)
+}
+
 func cmdLLEN(wf io.Writer, ctx *context, args [][]byte) error {
 	key, err := buildKey(ctx, args[0])
 	if err != nil {
@@ -312,8 +742,12 @@ func arrayRange(ctx *context, key *as.Key, start int, stop int) ([]interface{},
 	if stop < start {
 		count -= 1
 	}
-	rec, err := ctx.client.Operate(ctx.writePolicy, key, as.ListGetRangeOp(binName, start, count), as.ListSizeOp(binName))
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, listGetRangeOp(binName, start, count), listSizeOp(binName))
 	if errResultCode(err) == ase.PARAMETER_ERROR {
+		// Like Redis, a start before the beginning of the list is the beginning
+		if start < 0 {
+			return arrayRange(ctx, key, 0, stop)
+		}
 		return make([]interface{}, 0), 0, false, nil
 	}
 	if err != nil {
@@ -323,18 +757,21 @@ func arrayRange(ctx *context, key *as.Key, start int, stop int) ([]interface{},
 		return make([]interface{}, 0), 0, true, nil
 	}
 	a := rec.Bins[binName].([]interface{})
-	size, result := a[len(a)-1], a[:len(a)-1]
-	if start < 0 && stop >= 0 {
-		end := size.(int) + start + 1
-		if end < len(result) {
-			result = result[0:end]
-		}
-	}
-	if start >= 0 && stop < 0 {
-		end := size.(int) + stop + 1
-		if end < len(result) {
-			result = result[0:end]
+	size, result := a[len(a)-1].(int), a[:len(a)-1]
+	// The elements are read from start to the end of the list when only one
+	// of start and stop is negative
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if stop-start+1 < len(result) {
+		end := stop - start + 1
+		if end < 0 {
+			end = 0
 		}
+		result = result[0:end]
 	}
 	return result, rec.Generation, false, nil
 }
@@ -369,12 +806,12 @@ func tryLTRIM(wf io.Writer, ctx *context, key *as.Key, start int, stop int) erro
 	if err != nil {
 		return err
 	}
-	ops := make([]*as.Operation, 1)
-	ops[0] = as.ListClearOp(binName)
+	ops := make([]*operation, 1)
+	ops[0] = listClearOp(binName)
 	if len(result) > 0 {
-		ops = append(ops, as.ListAppendOp(binName, result...))
+		ops = append(ops, listAppendOp(binName, result...))
 	}
-	ops = append(ops, as.PutOp(as.NewBin(sizeArrayField, len(result))))
+	ops = append(ops, putOp(as.NewBin(sizeArrayField, len(result))))
 	policy := ctx.writePolicy
 	if nonExistent {
 		return nil
@@ -389,13 +826,289 @@ func tryLTRIM(wf io.Writer, ctx *context, key *as.Key, start int, stop int) erro
 	return nil
 }
 
+// Reads the whole list, to be modified using a generation checked write.
+func listRecord(ctx *context, key *as.Key) ([]interface{}, *as.Record, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, binName, sizeArrayField)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec == nil || rec.Bins[binName] == nil {
+		return make([]interface{}, 0), rec, nil
+	}
+	list, ok := rec.Bins[binName].([]interface{})
+	if !ok {
+		return nil, nil, errWrongType
+	}
+	return list, rec, nil
+}
+
+func listElementEquals(x interface{}, element []byte) bool {
+	buf, err := decodeValue(x)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(buf, element)
+}
+
+func cmdLINDEX(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	index, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, listGetOp(binName, index))
+	code := errResultCode(err)
+	if code == ase.PARAMETER_ERROR || code == ase.OP_NOT_APPLICABLE {
+		return writeLine(wf, "$-1")
+	}
+	if err != nil {
+		return err
+	}
+	return writeBin(wf, rec, binName, "$-1")
+}
+
+func tryLSET(wf io.Writer, ctx *context, key *as.Key, index int, value interface{}) error {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, sizeArrayField)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.Bins[sizeArrayField] == nil {
+		return writeErrorReply(wf, "no such key")
+	}
+	size := rec.Bins[sizeArrayField].(int)
+	if index >= size || index < -size {
+		return writeErrorReply(wf, "index out of range")
+	}
+	_, err = ctx.client.Operate(createWritePolicyGeneration(rec.Generation, -1), key, listSetOp(binName, index, value))
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, "+OK")
+}
+
+func cmdLSET(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	index, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	value := encode(ctx, args[2])
+	for i := 0; i < ctx.generationRetries; i++ {
+		err := tryLSET(wf, ctx, key, index, value)
+		if errResultCode(err) != ase.GENERATION_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for lset")
+}
+
+func tryLINSERT(wf io.Writer, ctx *context, key *as.Key, after bool, pivot []byte, value interface{}) error {
+	list, rec, err := listRecord(ctx, key)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeLine(wf, ":0")
+	}
+	for i, e := range list {
+		if listElementEquals(e, pivot) {
+			if after {
+				i++
+			}
+			return listOpReturnSize(wf, ctx, key, createWritePolicyGeneration(rec.Generation, -1), 1, listInsertOp(binName, i, value))
+		}
+	}
+	return writeLine(wf, ":-1")
+}
+
+func cmdLINSERT(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	var after bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		after = false
+	case "AFTER":
+		after = true
+	default:
+		return fmt.Errorf("Syntax error in LINSERT: '%s'", string(args[1]))
+	}
+	value := encode(ctx, args[3])
+	for i := 0; i < ctx.generationRetries; i++ {
+		err := tryLINSERT(wf, ctx, key, after, args[2], value)
+		if errResultCode(err) != ase.GENERATION_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for linsert")
+}
+
+// Returns the indexes of the elements to remove, as specified by LREM.
+func listRemovedIndexes(list []interface{}, count int, element []byte) map[int]bool {
+	res := make(map[int]bool)
+	if count >= 0 {
+		for i := 0; i < len(list) && (count == 0 || len(res) < count); i++ {
+			if listElementEquals(list[i], element) {
+				res[i] = true
+			}
+		}
+	} else {
+		for i := len(list) - 1; i >= 0 && len(res) < -count; i-- {
+			if listElementEquals(list[i], element) {
+				res[i] = true
+			}
+		}
+	}
+	return res
+}
+
+func tryLREM(ctx *context, key *as.Key, count int, element []byte) (int, error) {
+	list, rec, err := listRecord(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	removed := listRemovedIndexes(list, count, element)
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	result := make([]interface{}, 0, len(list)-len(removed))
+	for i, e := range list {
+		if !removed[i] {
+			result = append(result, e)
+		}
+	}
+	ops := make([]*operation, 1)
+	ops[0] = listClearOp(binName)
+	if len(result) > 0 {
+		ops = append(ops, listAppendOp(binName, result...))
+	}
+	ops = append(ops, putOp(as.NewBin(sizeArrayField, len(result))))
+	_, err = ctx.client.Operate(createWritePolicyGeneration(rec.Generation, -1), key, ops...)
+	if err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+func cmdLREM(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		removed, err := tryLREM(ctx, key, count, args[2])
+		if err == nil {
+			return writeLine(wf, ":"+strconv.Itoa(removed))
+		}
+		if errResultCode(err) != ase.GENERATION_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for lrem")
+}
+
+func cmdLPOS(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rank := 1
+	count := -1
+	maxLen := 0
+	for i := 2; i+1 < len(args); i += 2 {
+		v, err := strconv.Atoi(string(args[i+1]))
+		if err != nil {
+			return err
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if v == 0 {
+				return writeErrorReply(wf, "RANK can't be zero")
+			}
+			rank = v
+		case "COUNT":
+			if v < 0 {
+				return writeErrorReply(wf, "COUNT can't be negative")
+			}
+			count = v
+		case "MAXLEN":
+			if v < 0 {
+				return writeErrorReply(wf, "MAXLEN can't be negative")
+			}
+			maxLen = v
+		default:
+			return fmt.Errorf("Syntax error in LPOS: '%s'", string(args[i]))
+		}
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+	if err != nil {
+		return err
+	}
+	list := make([]interface{}, 0)
+	if rec != nil && rec.Bins[binName] != nil {
+		var ok bool
+		list, ok = rec.Bins[binName].([]interface{})
+		if !ok {
+			return errWrongType
+		}
+	}
+	res := make([]interface{}, 0)
+	step, i := 1, 0
+	if rank < 0 {
+		step, i = -1, len(list)-1
+		rank = -rank
+	}
+	for checked := 0; i >= 0 && i < len(list) && (maxLen == 0 || checked < maxLen); checked, i = checked+1, i+step {
+		if !listElementEquals(list[i], args[1]) {
+			continue
+		}
+		if rank > 1 {
+			rank--
+			continue
+		}
+		res = append(res, i)
+		if count == -1 || (count > 0 && len(res) == count) {
+			break
+		}
+	}
+	if count == -1 {
+		if len(res) == 0 {
+			return writeLine(wf, "$-1")
+		}
+		return writeLine(wf, ":"+strconv.Itoa(res[0].(int)))
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(res)))
+	if err != nil {
+		return err
+	}
+	for _, e := range res {
+		err = writeLine(wf, ":"+strconv.Itoa(e.(int)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func hIncrByEx(wf io.Writer, ctx *context, k []byte, field string, incr int, ttl int) error {
 	key, err := buildKey(ctx, k)
 	if err != nil {
 		return err
 	}
 	bin := as.NewBin(field, incr)
-	rec, err := ctx.client.Operate(createWritePolicyEx(ttl, false), key, as.AddOp(bin), as.GetOpForBin(field))
+	rec, err := operate(ctx, createWritePolicyEx(ttl, false), key, addOp(bin), getOpForBin(field))
 	if err != nil {
 		if errResultCode(err) == ase.BIN_TYPE_ERROR {
 			return writeLine(wf, "$-1")
@@ -405,6 +1118,35 @@ func hIncrByEx(wf io.Writer, ctx *context, k []byte, field string, incr int, ttl
 	return writeBinInt(wf, rec, field)
 }
 
+func parseFloat(buf []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("Invalid float '%s'", string(buf))
+	}
+	return f, nil
+}
+
+// Returns the formatted result of adding incr to a stored value, false if
+// the stored value is not a float.
+// Returns false if the current value is not a number.
+func incrFloat(current interface{}, incr float64) ([]byte, bool, error) {
+	f := 0.0
+	if current != nil {
+		buf, err := decodeValue(current)
+		if err != nil {
+			return nil, false, err
+		}
+		f, err = parseFloat(buf)
+		if err != nil {
+			return nil, false, nil
+		}
+	}
+	return []byte(formatFloat(f + incr)), true, nil
+}
+
 func cmdINCR(wf io.Writer, ctx *context, args [][]byte) error {
 	return hIncrByEx(wf, ctx, args[0], binName, 1, -1)
 }
@@ -508,7 +1250,7 @@ func cmdHMSET(wf io.Writer, ctx *context, args [][]byte) error {
 	for i := 1; i+1 < len(args); i += 2 {
 		bins[i/2] = as.NewBin(string(args[i]), encode(ctx, args[i+1]))
 	}
-	err = ctx.client.PutBins(ctx.writePolicy, key, bins...)
+	err = putBins(ctx, ctx.writePolicy, key, bins...)
 	if err != nil {
 		return err
 	}
@@ -530,16 +1272,26 @@ func cmdHGETALL(wf io.Writer, ctx *context, args [][]byte) error {
 			return err
 		}
 	} else {
+		names := make([]string, 0, len(rec.Bins))
+		values := make([]interface{}, 0, len(rec.Bins))
+		for k, v := range rec.Bins {
+			names = append(names, k)
+			values = append(values, v)
+		}
+		bufs, err := decodeValues(values)
+		if err != nil {
+			return err
+		}
 		err = writeLine(wf, "*"+strconv.Itoa(len(rec.Bins)*2))
 		if err != nil {
 			return err
 		}
-		for k, v := range rec.Bins {
-			err = writeByteArray(wf, []byte(k))
+		for i, buf := range bufs {
+			err = writeByteArray(wf, []byte(names[i]))
 			if err != nil {
 				return err
 			}
-			err = writeValue(wf, v)
+			err = writeByteArray(wf, buf)
 			if err != nil {
 				return err
 			}
@@ -548,47 +1300,6 @@ func cmdHGETALL(wf io.Writer, ctx *context, args [][]byte) error {
 	return nil
 }
 
-func cmdEXPIRE(wf io.Writer, ctx *context, args [][]byte) error {
-	key, err := buildKey(ctx, args[0])
-	if err != nil {
-		return err
-	}
-
-	ttl, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return err
-	}
-
-	err = ctx.client.Touch(createWritePolicyEx(ttl, false), key)
-	if err != nil {
-		if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
-			return writeLine(wf, ":0")
-		}
-		return err
-	}
-	return writeLine(wf, ":1")
-}
-
-func cmdTTL(wf io.Writer, ctx *context, args [][]byte) error {
-	key, err := buildKey(ctx, args[0])
-	if err != nil {
-		return err
-	}
-
-	rec, err := ctx.client.GetHeader(ctx.readPolicy, key)
-	if err != nil {
-		return err
-	}
-	if rec == nil {
-		return writeLine(wf, ":-2")
-	}
-	ttl := uint64(rec.Expiration)
-	if ttl >= 4294967295 {
-		return writeLine(wf, ":-1")
-	}
-	return writeLine(wf, ":"+strconv.FormatUint(ttl, 10))
-}
-
 func cmdEXISTS(wf io.Writer, ctx *context, args [][]byte) error {
 	key, err := buildKey(ctx, args[0])
 	if err != nil {
@@ -641,15 +1352,15 @@ func cmdHMINCRBYEX(wf io.Writer, ctx *context, args [][]byte) error {
 		}
 		return writeLine(wf, "+OK")
 	}
-	ops := make([]*as.Operation, (len(args)-2)/2)
+	ops := make([]*operation, (len(args)-2)/2)
 	for i := 2; i+1 < len(args); i += 2 {
 		incr, err := strconv.Atoi(string(args[i+1]))
 		if err != nil {
 			return err
 		}
-		ops[(i/2)-1] = as.AddOp(as.NewBin(string(args[i]), incr))
+		ops[(i/2)-1] = addOp(as.NewBin(string(args[i]), incr))
 	}
-	_, err = ctx.client.Operate(createWritePolicyEx(ttl, false), key, ops...)
+	_, err = operate(ctx, createWritePolicyEx(ttl, false), key, ops...)
 	if err != nil {
 		return err
 	}
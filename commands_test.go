@@ -0,0 +1,536 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+)
+
+func TestGetSet(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"GET myKey", "nil"},
+			{"EXISTS myKey", ":0"},
+			{"SET myKey 12", "+OK"},
+			{"GET myKey", `"12"`},
+			{"EXISTS myKey", ":1"},
+			{"SET myKey2 13", "+OK"},
+			{"GET myKey2", `"13"`},
+			{"DEL myKey", ":1"},
+			{"DEL myKey", ":0"},
+			{"GET myKey", "nil"},
+			{"SETNX myKey a", ":1"},
+			{"SETNX myKey b", ":0"},
+			{"GET myKey", `"a"`},
+			{"SET myKey1 a", "+OK"},
+			{"FLUSHDB", "+OK"},
+			{"GET myKey1", "nil"},
+			{"GET myKey2", "nil"},
+		})
+		for _, v := range []string{"toto\r\ntiti", "toto\x00\x01\x02tata", strings.Repeat("a", 1031)} {
+			if res := c.do("SET", "myKey", v); res != "+OK" {
+				t.Errorf("SET: got %s", res)
+			}
+			if res := c.do("GET", "myKey"); res != strconv.Quote(v) {
+				t.Errorf("GET: got %s, expected %q", res, v)
+			}
+		}
+	})
+}
+
+func TestStrings(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"APPEND myKey abc", ":3"},
+			{"APPEND myKey def", ":6"},
+			{"GET myKey", `"abcdef"`},
+			{"STRLEN myKey", ":6"},
+			{"STRLEN unknown", ":0"},
+			{"GETRANGE myKey 1 2", `"bc"`},
+			{"GETRANGE myKey -3 -1", `"def"`},
+			{"GETRANGE myKey 4 100", `"ef"`},
+			{"GETRANGE myKey 5 2", `""`},
+			{"GETRANGE unknown 0 -1", `""`},
+			{"SETRANGE myKey 3 XY", ":6"},
+			{"GET myKey", `"abcXYf"`},
+			{"SETRANGE myKey2 2 a", ":3"},
+			{"GET myKey2", `"\x00\x00a"`},
+			{"SET myKey 12", "+OK"},
+			{"APPEND myKey 3", ":3"},
+			{"INCR myKey", ":124"},
+			{"GETSET myKey z", `"124"`},
+			{"GETSET unknown z", "nil"},
+			{"GETDEL unknown", `"z"`},
+			{"GETDEL unknown", "nil"},
+			{"EXISTS unknown", ":0"},
+			{"GETEX myKey EX 100", `"z"`},
+			{"TTL myKey", ":100"},
+			{"GETEX myKey PX 1500", `"z"`},
+			{"PTTL myKey", ":2000"},
+			{"GETEX myKey PERSIST", `"z"`},
+			{"TTL myKey", ":-1"},
+			{"GETEX myKey", `"z"`},
+			{"GETEX unknown EX 100", "nil"},
+			{"DEL myKey2", ":1"},
+			{"INCRBYFLOAT myKey2 10.5", `"10.5"`},
+			{"INCRBYFLOAT myKey2 0.25", `"10.75"`},
+			{"INCRBYFLOAT myKey2 -0.75", `"10"`},
+			{"GET myKey2", `"10"`},
+			{"SET myKey2 3", "+OK"},
+			{"INCRBYFLOAT myKey2 1.5", `"4.5"`},
+			{"INCRBYFLOAT myKey 1.5", "nil"},
+			{"INCRBYFLOAT myKey2 0.1", `"4.6"`},
+			{"INCRBYFLOAT myKey4 0.1", `"0.1"`},
+			{"INCRBYFLOAT myKey4 0.2", `"0.3"`},
+			{"DEL myKey2", ":1"},
+			{"MSETNX myKey1 a myKey2 b", ":1"},
+			{"MSETNX myKey3 c myKey2 d", ":0"},
+			{"MGET myKey1 myKey2 myKey3", `["a" "b" nil]`},
+			{"MSET myKey1 a1 myKey3 2", "+OK"},
+			{"MGET myKey1 myKey2 myKey3", `["a1" "b" "2"]`},
+			{"MGET unknown unknown2", "[nil nil]"},
+		})
+	})
+}
+
+func TestStringsWrongType(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"RPUSH myKey a", ":1"},
+			{"GET myKey", wrongType},
+			{"APPEND myKey b", wrongType},
+			{"STRLEN myKey", wrongType},
+			{"GETRANGE myKey 0 -1", wrongType},
+			{"INCRBYFLOAT myKey 1.5", wrongType},
+			{"LRANGE myKey 0 -1", `["a"]`},
+		})
+	})
+}
+
+func TestIncrDecr(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"INCR myKey", ":1"},
+			{"GET myKey", `"1"`},
+			{"INCRBY myKey 2", ":3"},
+			{"DECR myKey", ":2"},
+			{"DECRBY myKey 5", ":-3"},
+			{"INCRBY myKey -2", ":-5"},
+			{"DECRBY myKey -2", ":-3"},
+			{"GET myKey", `"-3"`},
+			{"SET myKey a", "+OK"},
+			{"INCR myKey", "nil"},
+			{"SET myKey 2", "+OK"},
+			{"INCR myKey", ":3"},
+			{"DEL myKey", ":1"},
+			{"INCRBYEX myKey 4 2", ":2"},
+			{"TTL myKey", ":4"},
+			{"INCRBY myKey 4", ":6"},
+			{"TTL myKey", ":4"},
+			{"DEL myKey", ":1"},
+			{"DECRBYEX myKey 4 2", ":-2"},
+			{"TTL myKey", ":4"},
+		})
+		s.sleep(5 * time.Second)
+		run(t, c, [][2]string{{"GET myKey", "nil"}})
+	})
+}
+
+func TestSetEx(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"SETEX myKey 4 a", "+OK"},
+			{"GET myKey", `"a"`},
+			{"TTL myKey", ":4"},
+			{"SETNXEX myKey2 3 a", ":1"},
+			{"SETNXEX myKey2 3 b", ":0"},
+			{"GET myKey2", `"a"`},
+			{"SET myKey3 a", "+OK"},
+			{"TTL myKey3", ":-1"},
+			{"EXPIRE myKey3 4", ":1"},
+			{"TTL unknown", ":-2"},
+			{"EXPIRE unknown 10", ":0"},
+		})
+		s.sleep(5 * time.Second)
+		run(t, c, [][2]string{
+			{"GET myKey", "nil"},
+			{"GET myKey2", "nil"},
+			{"GET myKey3", "nil"},
+		})
+	})
+}
+
+func TestLists(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"LLEN myKey", ":0"},
+			{"RPOP myKey", "nil"},
+			{"LPOP myKey", "nil"},
+			{"RPUSH myKey a", ":1"},
+			{"RPUSH myKey b", ":2"},
+			{"RPUSH myKey c", ":3"},
+			{"RPUSH myKey 12", ":4"},
+			{"LLEN myKey", ":4"},
+			{"RPOP myKey", `"12"`},
+			{"LPOP myKey", `"a"`},
+			{"LPUSH myKey z", ":3"},
+			{"LRANGE myKey 0 -1", `["z" "b" "c"]`},
+			{"DEL myKey", ":1"},
+			{"RPUSH myKey a b c", ":3"},
+			{"LPUSH myKey d e", ":5"},
+			{"LRANGE myKey 0 -1", `["e" "d" "a" "b" "c"]`},
+			{"RPUSHX myKey f", ":6"},
+			{"LPUSHX myKey g", ":7"},
+			{"LRANGE myKey 0 -1", `["g" "e" "d" "a" "b" "c" "f"]`},
+			{"RPUSHX myKey2 a", ":0"},
+			{"LPUSHX myKey2 a", ":0"},
+			{"LLEN myKey2", ":0"},
+		})
+	})
+}
+
+func TestListsRange(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"LRANGE myKey 0 0", "[]"},
+			{"RPUSH myKey a", ":1"},
+			{"LRANGE myKey 0 0", `["a"]`},
+			{"LTRIM myKey 0 -1", "+OK"},
+			{"LRANGE myKey -1 0", `["a"]`},
+			{"RPUSH myKey b c", ":3"},
+			{"LRANGE myKey 0 12", `["a" "b" "c"]`},
+			{"LRANGE myKey 0 -2", `["a" "b"]`},
+			{"LRANGE myKey 2 2", `["c"]`},
+			{"LRANGE myKey -3 -2", `["a" "b"]`},
+			{"LRANGE myKey -2 -3", "[]"},
+			{"LTRIM myKey 0 -2", "+OK"},
+			{"LRANGE myKey 0 -1", `["a" "b"]`},
+			{"RPUSH myKey c d e f", ":6"},
+			{"LRANGE myKey -2 8", `["e" "f"]`},
+			{"LRANGE myKey 2 4", `["c" "d" "e"]`},
+			{"LTRIM myKey 2 4", "+OK"},
+			{"LRANGE myKey 0 -1", `["c" "d" "e"]`},
+			{"LTRIM myKey -2 -3", "+OK"},
+			{"LLEN myKey", ":0"},
+			{"RPUSH myKey a b c", ":3"},
+			{"LRANGE myKey 1 -2", `["b"]`},
+			{"LRANGE myKey -3 1", `["a" "b"]`},
+			{"LRANGE myKey 2 -3", "[]"},
+			{"LRANGE myKey -100 1", `["a" "b"]`},
+			{"LRANGE myKey -100 -2", `["a" "b"]`},
+			{"LRANGE myKey -100 -50", "[]"},
+			{"LRANGE myKey 5 10", "[]"},
+			{"LTRIM myKey -100 1", "+OK"},
+			{"LRANGE myKey 0 -1", `["a" "b"]`},
+			{"DEL myKey", ":1"},
+			{"RPUSH myKey a", ":1"},
+			{"LTRIM myKey 2 4", "+OK"},
+			{"LLEN myKey", ":0"},
+			{"LTRIM unknown 2 4", "+OK"},
+		})
+	})
+}
+
+func TestListsIndex(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"LINDEX myKey 0", "nil"},
+			{"LSET myKey 0 a", "-ERR no such key"},
+			{"RPUSH myKey a b c b a b", ":6"},
+			{"LINDEX myKey 0", `"a"`},
+			{"LINDEX myKey -1", `"b"`},
+			{"LINDEX myKey 10", "nil"},
+			{"LSET myKey 1 z", "+OK"},
+			{"LSET myKey -1 y", "+OK"},
+			{"LSET myKey 6 x", "-ERR index out of range"},
+			{"LRANGE myKey 0 -1", `["a" "z" "c" "b" "a" "y"]`},
+			{"LINSERT myKey BEFORE c w", ":7"},
+			{"LINSERT myKey AFTER y v", ":8"},
+			{"LINSERT myKey AFTER unknown v", ":-1"},
+			{"LRANGE myKey 0 -1", `["a" "z" "w" "c" "b" "a" "y" "v"]`},
+			{"LPOS myKey a", ":0"},
+			{"LPOS myKey a RANK 2", ":5"},
+			{"LPOS myKey a RANK -1", ":5"},
+			{"LPOS myKey a COUNT 0", "[:0 :5]"},
+			{"LPOS myKey a RANK 2 MAXLEN 3", "nil"},
+			{"LPOS myKey unknown", "nil"},
+			{"LPOS myKey a RANK 0", "-ERR RANK can't be zero"},
+			{"LREM myKey 0 a", ":2"},
+			{"RPUSH myKey z z", ":8"},
+			{"LREM myKey -2 z", ":2"},
+			{"LRANGE myKey 0 -1", `["z" "w" "c" "b" "y" "v"]`},
+			{"LREM myKey 0 unknown", ":0"},
+			{"LINSERT unknown AFTER a b", ":0"},
+		})
+	})
+}
+
+// Commands reading the whole list, on a string key.
+func TestListsWrongType(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"SET myKey abc", "+OK"},
+			{"LREM myKey 0 a", wrongType},
+			{"LINSERT myKey BEFORE a b", wrongType},
+			{"LPOS myKey a", wrongType},
+			{"GET myKey", `"abc"`},
+		})
+	})
+}
+
+func TestListsMove(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"RPOPLPUSH myKey myKey2", "nil"},
+			{"RPUSH myKey a b c", ":3"},
+			{"RPOPLPUSH myKey myKey2", `"c"`},
+			{"LRANGE myKey 0 -1", `["a" "b"]`},
+			{"LRANGE myKey2 0 -1", `["c"]`},
+			{"LMOVE myKey myKey2 LEFT RIGHT", `"a"`},
+			{"LRANGE myKey2 0 -1", `["c" "a"]`},
+			{"LMOVE myKey2 myKey2 LEFT RIGHT", `"c"`},
+			{"LRANGE myKey2 0 -1", `["a" "c"]`},
+			{"RPOPLPUSH myKey2 myKey2", `"c"`},
+			{"LRANGE myKey2 0 -1", `["c" "a"]`},
+			{"LMOVE unknown myKey2 LEFT RIGHT", "nil"},
+		})
+	})
+}
+
+// The push to a string fails, the element is pushed back to the source list.
+func TestListsMovePushBack(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"RPUSH myKey a b c", ":3"},
+			{"SET myKey2 x", "+OK"},
+			{"RPOPLPUSH myKey myKey2", wrongType},
+			{"LRANGE myKey 0 -1", `["a" "b" "c"]`},
+			{"LMOVE myKey myKey2 LEFT RIGHT", wrongType},
+			{"LRANGE myKey 0 -1", `["a" "b" "c"]`},
+			{"LLEN myKey", ":3"},
+			{"GET myKey2", `"x"`},
+		})
+	})
+}
+
+func TestListsBlocking(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"RPUSH myKey2 a b", ":2"},
+			{"BLPOP myKey myKey2 1", `["myKey2" "a"]`},
+			{"BRPOP myKey myKey2 1", `["myKey2" "b"]`},
+			{"BLPOP myKey myKey2 0.1", "nil"},
+			{"RPUSH myKey c", ":1"},
+			{"BLMOVE myKey myKey2 LEFT RIGHT 1", `"c"`},
+			{"BLMOVE myKey myKey2 LEFT RIGHT 0.1", "nil"},
+			{"BRPOPLPUSH myKey2 myKey 1", `"c"`},
+			{"LRANGE myKey 0 -1", `["c"]`},
+			{"BRPOPLPUSH unknown myKey 0.1", "nil"},
+			// Not blocking inside a MULTI
+			{"MULTI", "+OK"},
+			{"BRPOP unknown 0", "+QUEUED"},
+			{"EXEC", "[nil]"},
+		})
+
+		// A push from another client wakes up the blocked one
+		c.send("BLPOP", "myKey3", "0")
+		time.Sleep(50 * time.Millisecond)
+		c2 := s.connect(t)
+		defer c2.close()
+		run(t, c2, [][2]string{{"RPUSH myKey3 d", ":1"}})
+		if res := c.read(); res != `["myKey3" "d"]` {
+			t.Errorf("BLPOP: got %s", res)
+		}
+		run(t, c2, [][2]string{{"LLEN myKey3", ":0"}})
+
+		// A client closing the connection does not pop the value
+		c3 := s.connect(t)
+		c3.send("BLPOP", "myKey4", "0")
+		time.Sleep(50 * time.Millisecond)
+		c3.close()
+		time.Sleep(50 * time.Millisecond)
+		run(t, c2, [][2]string{
+			{"RPUSH myKey4 e", ":1"},
+			{"LRANGE myKey4 0 -1", `["e"]`},
+		})
+	})
+}
+
+func TestListsEx(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"LPUSHEX myKey toto 2", ":1"},
+			{"RPUSHEX myKey2 toto 2", ":1"},
+			{"LLEN myKey", ":1"},
+			{"LLEN myKey2", ":1"},
+			{"TTL myKey", ":2"},
+		})
+		s.sleep(3 * time.Second)
+		run(t, c, [][2]string{
+			{"LLEN myKey", ":0"},
+			{"LLEN myKey2", ":0"},
+		})
+	})
+}
+
+func TestKeys(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"TYPE myKey", "+none"},
+			{"RENAME myKey myKey2", "-ERR no such key"},
+			{"SET myKey a", "+OK"},
+			{"TYPE myKey", "+string"},
+			{"EXPIRE myKey 100", ":1"},
+			{"RENAME myKey myKey2", "+OK"},
+			{"EXISTS myKey", ":0"},
+			{"GET myKey2", `"a"`},
+			{"TTL myKey2", ":100"},
+			{"PERSIST myKey2", ":1"},
+			{"PERSIST myKey2", ":0"},
+			{"TTL myKey2", ":-1"},
+			{"PERSIST myKey", ":0"},
+			{"SET myKey b", "+OK"},
+			{"RENAMENX myKey myKey2", ":0"},
+			{"RENAME myKey myKey2", "+OK"},
+			{"RENAMENX myKey2 myKey", ":1"},
+			{"GET myKey", `"b"`},
+			{"COPY myKey myKey2", ":1"},
+			{"COPY myKey myKey2", ":0"},
+			{"SET myKey c", "+OK"},
+			{"COPY myKey myKey2 REPLACE", ":1"},
+			{"GET myKey2", `"c"`},
+			{"COPY myKey myKey", "-ERR source and destination objects are the same"},
+			{"DEL myKey2", ":1"},
+			{"RANDOMKEY", `"myKey"`},
+			{"DEL myKey", ":1"},
+			{"RPUSH myKey a b", ":2"},
+			{"TYPE myKey", "+list"},
+			{"RENAME myKey myKey2", "+OK"},
+			{"LRANGE myKey2 0 -1", `["a" "b"]`},
+			{"RPUSH myKey2 c", ":3"},
+			{"DEL myKey2", ":1"},
+			{"HSET myKey a 1", ":1"},
+			{"HSET myKey b 2", ":1"},
+			{"TYPE myKey", "+hash"},
+			{"RENAME myKey myKey2", "+OK"},
+			{"EXISTS myKey", ":0"},
+			{"HGET myKey2 b", `"2"`},
+			{"HGETALL myKey", "[]"},
+			{"COPY myKey2 myKey", ":1"},
+			{"HGET myKey a", `"1"`},
+			{"HSET myKey a 3", ":0"},
+			{"HGET myKey2 a", `"1"`},
+			{"SET myKey3 a", "+OK"},
+			{"RENAME myKey3 myKey2", "+OK"},
+			{"GET myKey2", `"a"`},
+			{"XADD myKey4 1-0 a 1", `"1-0"`},
+			{"TYPE myKey4", "+stream"},
+			{"PFADD myKey5 a", ":1"},
+			{"TYPE myKey5", "+string"},
+		})
+	})
+}
+
+func TestExpire(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		at := time.Now().Unix() + 1000
+		run(t, c, [][2]string{
+			{"PEXPIRE myKey 10000", ":0"},
+			{"PTTL myKey", ":-2"},
+			{"EXPIRETIME myKey", ":-2"},
+			{"SET myKey a", "+OK"},
+			{"PTTL myKey", ":-1"},
+			{"EXPIRETIME myKey", ":-1"},
+			{"EXPIRE myKey 100 XX", ":0"},
+			{"EXPIRE myKey 100 GT", ":0"},
+			{"EXPIRE myKey 100 NX", ":1"},
+			{"EXPIRE myKey 200 NX", ":0"},
+			{"EXPIRE myKey 50 GT", ":0"},
+			{"EXPIRE myKey 200 GT", ":1"},
+			{"TTL myKey", ":200"},
+			{"EXPIRE myKey 300 LT", ":0"},
+			{"EXPIRE myKey 150 XX LT", ":1"},
+			{"TTL myKey", ":150"},
+			{"PEXPIRE myKey 10500", ":1"},
+			{"PTTL myKey", ":11000"},
+			{"EXPIREAT myKey " + strconv.FormatInt(at, 10), ":1"},
+		})
+		between(t, c, "EXPIRETIME myKey", at-1, at+1)
+		run(t, c, [][2]string{
+			{"PEXPIREAT myKey " + strconv.FormatInt(at*1000+500, 10), ":1"},
+		})
+		between(t, c, "PEXPIRETIME myKey", at*1000-1000, at*1000+2000)
+		run(t, c, [][2]string{
+			{"PERSIST myKey", ":1"},
+			{"EXPIRE myKey 100 LT", ":1"},
+			{"EXPIREAT myKey " + strconv.FormatInt(time.Now().Unix()-10, 10), ":1"},
+			{"EXISTS myKey", ":0"},
+			{"SET myKey a", "+OK"},
+			{"EXPIRE myKey 0", ":1"},
+			{"EXISTS myKey", ":0"},
+			{"HSET myKey a 1", ":1"},
+			{"PEXPIRE myKey 10000", ":1"},
+			{"TTL myKey", ":10"},
+			{"EXPIRE myKey 100 NX", ":0"},
+			{"EXPIRE myKey 2", ":1"},
+			{"HGET myKey a", `"1"`},
+		})
+		s.sleep(3 * time.Second)
+		run(t, c, [][2]string{
+			{"HGET myKey a", "nil"},
+			{"TTL myKey", ":-2"},
+		})
+	})
+}
+
+// With a namespace default ttl, created records do not expire, and updated
+// records keep their ttl.
+func TestExpireDefaultTTL(t *testing.T) {
+	nsNeverExpire = false
+	defer func() { nsNeverExpire = true }()
+	s := startTestServer(t, "standard", nil)
+	s.backend.defaultTTL = 1000
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"INCR myKey", ":1"},
+		{"TTL myKey", ":-1"},
+		{"HSET myKey2 a 1", ":1"},
+		{"TTL myKey2", ":-1"},
+		{"EXPIRE myKey 100", ":1"},
+		{"INCR myKey", ":2"},
+		{"TTL myKey", ":100"},
+		{"EXPIRE myKey2 100", ":1"},
+		{"HSET myKey2 b 2", ":1"},
+		{"TTL myKey2", ":100"},
+	})
+
+	// The record is created by another client between the update and the
+	// create: the update is retried.
+	key, err := buildKey(s.ctx, []byte("myKey3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := 0
+	err = keepTTLWrite(s.ctx, createWritePolicyEx(-1, false), func(policy *as.WritePolicy) error {
+		writes++
+		err := s.ctx.client.PutBins(policy, key, as.NewBin(binName, "b"))
+		if writes == 1 {
+			run(t, c, [][2]string{{"SETEX myKey3 100 a", "+OK"}})
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != 3 {
+		t.Errorf("Got %d writes", writes)
+	}
+	run(t, c, [][2]string{
+		{"GET myKey3", `"b"`},
+		{"TTL myKey3", ":100"},
+	})
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The compatibility test sends random sequences of commands to a Redis server
+// and to Aerodis, and compares their replies byte for byte. It runs only when
+// a Redis server is given, its keys starting with compat: are deleted:
+//
+//	go test -run TestCompat -redis 127.0.0.1:6380
+var compatRedis = flag.String("redis", "", "Redis server of the compatibility test, the test is skipped without it")
+var compatAerodis = flag.String("compat_aerodis", "", "Aerodis of the compatibility test, by default one with the memory backend in each mode")
+var compatSeed = flag.Int64("compat_seed", 0, "Seed of the compatibility test, random by default")
+var compatSequences = flag.Int("compat_sequences", 200, "Number of command sequences of the compatibility test")
+var compatLength = flag.Int("compat_length", 30, "Number of commands of a sequence of the compatibility test")
+
+// Each kind of key gets the commands of one type, so the sequences do not
+// hit the wrong type errors, which are not the same in Aerodis.
+// Numbers are only set to integers, floats only changed by INCRBYFLOAT, and
+// counters are hashes of integers.
+var compatKinds = []string{"string", "number", "float", "list", "hash", "counter", "bitmap", "hll"}
+
+const compatKeysByKind = 3
+
+func compatKey(r *rand.Rand, kind string) string {
+	return "compat:" + kind + ":" + strconv.Itoa(r.Intn(compatKeysByKind))
+}
+
+func compatAnyKey(r *rand.Rand) string {
+	return compatKey(r, compatKinds[r.Intn(len(compatKinds))])
+}
+
+var compatWords = []string{"a", "b", "c", "toto", "12", "-3", "007", "1.5", " ", "x\r\ny", "\x00\xff"}
+
+func compatWord(r *rand.Rand) string {
+	return compatWords[r.Intn(len(compatWords))]
+}
+
+func compatInt(r *rand.Rand, min int, max int) string {
+	return strconv.Itoa(min + r.Intn(max-min+1))
+}
+
+func compatSide(r *rand.Rand) string {
+	if r.Intn(2) == 0 {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// Adds 1 to n pairs of arguments, built by f.
+func compatPairs(r *rand.Rand, args []string, f func() []string) []string {
+	for i := 1 + r.Intn(3); i > 0; i-- {
+		args = append(args, f()...)
+	}
+	return args
+}
+
+type compatCommand struct {
+	name string
+	args func(r *rand.Rand) []string
+}
+
+// PTTL, PEXPIRETIME and EXPIRETIME are not generated: Aerospike ttls are in
+// seconds. Blocking commands, HSCAN and RANDOMKEY are not generated either,
+// as their replies depend on the timing or on the other keys.
+var compatCommands = []compatCommand{
+	{"GET", func(r *rand.Rand) []string { return []string{compatKey(r, "string")} }},
+	{"SET", func(r *rand.Rand) []string { return []string{compatKey(r, "string"), compatWord(r)} }},
+	{"SETNX", func(r *rand.Rand) []string { return []string{compatKey(r, "string"), compatWord(r)} }},
+	{"SETEX", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "string"), compatInt(r, 100, 1000), compatWord(r)}
+	}},
+	{"GETSET", func(r *rand.Rand) []string { return []string{compatKey(r, "string"), compatWord(r)} }},
+	{"GETDEL", func(r *rand.Rand) []string { return []string{compatKey(r, "string")} }},
+	{"APPEND", func(r *rand.Rand) []string { return []string{compatKey(r, "string"), compatWord(r)} }},
+	{"STRLEN", func(r *rand.Rand) []string { return []string{compatKey(r, "string")} }},
+	{"GETRANGE", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "string"), compatInt(r, -5, 5), compatInt(r, -5, 5)}
+	}},
+	{"SETRANGE", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "string"), compatInt(r, 0, 5), compatWord(r)}
+	}},
+	{"MGET", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "string"), compatKey(r, "string"), compatKey(r, "number")}
+	}},
+	{"MSET", func(r *rand.Rand) []string {
+		return compatPairs(r, nil, func() []string { return []string{compatKey(r, "string"), compatWord(r)} })
+	}},
+	{"MSETNX", func(r *rand.Rand) []string {
+		return compatPairs(r, nil, func() []string { return []string{compatKey(r, "string"), compatWord(r)} })
+	}},
+	{"SET", func(r *rand.Rand) []string { return []string{compatKey(r, "number"), compatInt(r, -10, 10)} }},
+	{"GET", func(r *rand.Rand) []string { return []string{compatKey(r, "number")} }},
+	{"INCR", func(r *rand.Rand) []string { return []string{compatKey(r, "number")} }},
+	{"DECR", func(r *rand.Rand) []string { return []string{compatKey(r, "number")} }},
+	{"INCRBY", func(r *rand.Rand) []string { return []string{compatKey(r, "number"), compatInt(r, -10, 10)} }},
+	{"DECRBY", func(r *rand.Rand) []string { return []string{compatKey(r, "number"), compatInt(r, -10, 10)} }},
+	{"INCRBYFLOAT", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "float"), []string{"1", "-0.5", "2.25", "10", "0.1", "-0.3"}[r.Intn(6)]}
+	}},
+	{"GET", func(r *rand.Rand) []string { return []string{compatKey(r, "float")} }},
+	{"RPUSH", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "list")}, func() []string { return []string{compatWord(r)} })
+	}},
+	{"LPUSH", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "list")}, func() []string { return []string{compatWord(r)} })
+	}},
+	{"RPUSHX", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatWord(r)} }},
+	{"LPUSHX", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatWord(r)} }},
+	{"RPOP", func(r *rand.Rand) []string { return []string{compatKey(r, "list")} }},
+	{"LPOP", func(r *rand.Rand) []string { return []string{compatKey(r, "list")} }},
+	{"LLEN", func(r *rand.Rand) []string { return []string{compatKey(r, "list")} }},
+	{"LRANGE", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "list"), compatInt(r, -6, 6), compatInt(r, -6, 6)}
+	}},
+	{"LTRIM", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "list"), compatInt(r, -6, 6), compatInt(r, -6, 6)}
+	}},
+	{"LINDEX", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatInt(r, -6, 6)} }},
+	{"LSET", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatInt(r, -6, 6), compatWord(r)} }},
+	{"LINSERT", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "list"), []string{"BEFORE", "AFTER"}[r.Intn(2)], compatWord(r), compatWord(r)}
+	}},
+	{"LREM", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatInt(r, -2, 2), compatWord(r)} }},
+	{"LPOS", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatWord(r)} }},
+	{"RPOPLPUSH", func(r *rand.Rand) []string { return []string{compatKey(r, "list"), compatKey(r, "list")} }},
+	{"LMOVE", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "list"), compatKey(r, "list"), compatSide(r), compatSide(r)}
+	}},
+	{"HSET", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "hash")}, func() []string { return []string{compatWord(r), compatWord(r)} })
+	}},
+	{"HMSET", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "hash")}, func() []string { return []string{compatWord(r), compatWord(r)} })
+	}},
+	{"HSETNX", func(r *rand.Rand) []string { return []string{compatKey(r, "hash"), compatWord(r), compatWord(r)} }},
+	{"HGET", func(r *rand.Rand) []string { return []string{compatKey(r, "hash"), compatWord(r)} }},
+	{"HMGET", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "hash")}, func() []string { return []string{compatWord(r)} })
+	}},
+	{"HDEL", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "hash")}, func() []string { return []string{compatWord(r)} })
+	}},
+	{"HGETALL", func(r *rand.Rand) []string { return []string{compatKey(r, "hash")} }},
+	{"HKEYS", func(r *rand.Rand) []string { return []string{compatKey(r, "hash")} }},
+	{"HVALS", func(r *rand.Rand) []string { return []string{compatKey(r, "hash")} }},
+	{"HLEN", func(r *rand.Rand) []string { return []string{compatKey(r, "hash")} }},
+	{"HEXISTS", func(r *rand.Rand) []string { return []string{compatKey(r, "hash"), compatWord(r)} }},
+	{"HSTRLEN", func(r *rand.Rand) []string { return []string{compatKey(r, "hash"), compatWord(r)} }},
+	{"HINCRBY", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "counter"), compatWord(r), compatInt(r, -10, 10)}
+	}},
+	{"HGETALL", func(r *rand.Rand) []string { return []string{compatKey(r, "counter")} }},
+	{"SETBIT", func(r *rand.Rand) []string {
+		return []string{compatKey(r, "bitmap"), compatInt(r, 0, 40), compatInt(r, 0, 1)}
+	}},
+	{"GETBIT", func(r *rand.Rand) []string { return []string{compatKey(r, "bitmap"), compatInt(r, 0, 50)} }},
+	{"BITCOUNT", func(r *rand.Rand) []string { return []string{compatKey(r, "bitmap")} }},
+	{"BITPOS", func(r *rand.Rand) []string { return []string{compatKey(r, "bitmap"), compatInt(r, 0, 1)} }},
+	{"GET", func(r *rand.Rand) []string { return []string{compatKey(r, "bitmap")} }},
+	{"PFADD", func(r *rand.Rand) []string {
+		return compatPairs(r, []string{compatKey(r, "hll")}, func() []string { return []string{compatWord(r)} })
+	}},
+	{"PFCOUNT", func(r *rand.Rand) []string { return []string{compatKey(r, "hll"), compatKey(r, "hll")} }},
+	{"PFMERGE", func(r *rand.Rand) []string { return []string{compatKey(r, "hll"), compatKey(r, "hll")} }},
+	{"EXISTS", func(r *rand.Rand) []string { return []string{compatAnyKey(r)} }},
+	{"DEL", func(r *rand.Rand) []string { return []string{compatAnyKey(r)} }},
+	{"TYPE", func(r *rand.Rand) []string { return []string{compatAnyKey(r)} }},
+	{"EXPIRE", func(r *rand.Rand) []string { return []string{compatAnyKey(r), compatInt(r, 100, 1000)} }},
+	{"TTL", func(r *rand.Rand) []string { return []string{compatAnyKey(r)} }},
+	{"PERSIST", func(r *rand.Rand) []string { return []string{compatAnyKey(r)} }},
+	{"RENAME", func(r *rand.Rand) []string {
+		kind := compatKinds[r.Intn(len(compatKinds))]
+		return []string{compatKey(r, kind), compatKey(r, kind)}
+	}},
+	{"COPY", func(r *rand.Rand) []string {
+		kind := compatKinds[r.Intn(len(compatKinds))]
+		return []string{compatKey(r, kind), compatKey(r, kind)}
+	}},
+}
+
+// Connection to a Redis or an Aerodis, keeping the raw replies.
+type compatConn struct {
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *compatConn) connect() error {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Sends a command, and returns its raw reply. Aerodis closes the connection
+// after some errors, an EXISTS checks it, and a new connection is opened if
+// needed.
+func (c *compatConn) do(args []string) ([]byte, error) {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, a := range args {
+		buf += "$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n"
+	}
+	_, err := c.conn.Write([]byte(buf))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := readReply(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := replyElements(raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw[0] == '-' {
+		_, err := c.conn.Write([]byte("*2\r\n$6\r\nEXISTS\r\n$6\r\ncompat\r\n"))
+		if err == nil {
+			_, err = readReply(c.reader)
+		}
+		if err != nil {
+			err = c.connect()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sortRawReply(args[0], raw, elements), nil
+}
+
+// The fields of a hash are not ordered in Aerodis, the replies listing them
+// are sorted.
+func sortRawReply(cmd string, raw []byte, elements [][]byte) []byte {
+	step := 0
+	switch cmd {
+	case "HGETALL":
+		step = 2
+	case "HKEYS", "HVALS":
+		step = 1
+	default:
+		return raw
+	}
+	groups := make([]string, 0, len(elements)/step)
+	for i := 0; i+step <= len(elements); i += step {
+		groups = append(groups, string(bytes.Join(elements[i:i+step], nil)))
+	}
+	sort.Strings(groups)
+	return []byte("*" + strconv.Itoa(len(elements)) + "\r\n" + strings.Join(groups, ""))
+}
+
+// Ttls are rounded differently by Redis and Aerospike, so they can differ
+// by one second.
+func compatSame(cmd string, a []byte, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if cmd != "TTL" || a[0] != ':' || b[0] != ':' {
+		return false
+	}
+	x, errX := strconv.Atoi(string(bytes.TrimRight(a[1:], "\r\n")))
+	y, errY := strconv.Atoi(string(bytes.TrimRight(b[1:], "\r\n")))
+	return errX == nil && errY == nil && x >= 0 && y >= 0 && x-y <= 1 && y-x <= 1
+}
+
+type compatDivergence struct {
+	sequence int
+	command  string
+	redis    []byte
+	aerodis  []byte
+}
+
+type compatStats struct {
+	sent        int
+	divergences []compatDivergence
+}
+
+func TestCompat(t *testing.T) {
+	if *compatRedis == "" {
+		t.Skip("No Redis server, use -redis host:port")
+	}
+	seed := *compatSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("Seed %d, use -compat_seed %d to replay the sequences", seed, seed)
+	if *compatAerodis != "" {
+		compatRun(t, *compatAerodis, seed)
+		return
+	}
+	for _, mode := range testModes {
+		t.Run(mode, func(t *testing.T) {
+			s := startTestServer(t, mode, nil)
+			compatRun(t, s.addr, seed)
+		})
+	}
+}
+
+func compatRun(t *testing.T, aerodisAddr string, seed int64) {
+	redis := &compatConn{addr: *compatRedis}
+	aerodis := &compatConn{addr: aerodisAddr}
+	for _, c := range []*compatConn{redis, aerodis} {
+		err := c.connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.conn.Close()
+	}
+	r := rand.New(rand.NewSource(seed))
+	stats := make(map[string]*compatStats)
+	for _, c := range compatCommands {
+		stats[c.name] = &compatStats{}
+	}
+	for i := 0; i < *compatSequences; i++ {
+		// Keys are deleted one by one, DEL deletes only one key in Aerodis
+		for _, kind := range compatKinds {
+			for j := 0; j < compatKeysByKind; j++ {
+				for _, c := range []*compatConn{redis, aerodis} {
+					_, err := c.do([]string{"DEL", "compat:" + kind + ":" + strconv.Itoa(j)})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		}
+		for j := 0; j < *compatLength; j++ {
+			c := compatCommands[r.Intn(len(compatCommands))]
+			args := append([]string{c.name}, c.args(r)...)
+			redisReply, err := redis.do(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			aerodisReply, err := aerodis.do(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := stats[c.name]
+			s.sent++
+			if !compatSame(c.name, redisReply, aerodisReply) {
+				command := strconv.Quote(strings.Join(args, " "))
+				s.divergences = append(s.divergences, compatDivergence{i, command, redisReply, aerodisReply})
+			}
+		}
+	}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := stats[name]
+		t.Logf("%-12s sent %5d, divergent %5d", name, s.sent, len(s.divergences))
+	}
+	for _, name := range names {
+		s := stats[name]
+		if len(s.divergences) == 0 {
+			continue
+		}
+		report := name + ": " + strconv.Itoa(len(s.divergences)) + " divergent replies out of " + strconv.Itoa(s.sent)
+		for k, d := range s.divergences {
+			if k == 3 {
+				report += "\n  ..."
+				break
+			}
+			report += "\n  sequence " + strconv.Itoa(d.sequence) + ", " + d.command +
+				"\n    redis:   " + strconv.Quote(string(d.redis)) +
+				"\n    aerodis: " + strconv.Quote(string(d.aerodis))
+		}
+		t.Error(report)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+)
+
+// Compressed values are prefixed by compressionMagic and by one byte giving
+// the algorithm, so they are readable whatever the configuration of the set.
+// Values starting with compressionMagic are escaped with compressionNone.
+const compressionMagic = "\x00AZC"
+
+const compressionNone = byte(0)
+const compressionGzip = byte('g')
+const compressionSnappy = byte('s')
+
+const defaultCompressionThreshold = 1024
+
+func compressionAlgorithm(name string) (byte, error) {
+	switch name {
+	case "gzip":
+		return compressionGzip, nil
+	case "snappy":
+		return compressionSnappy, nil
+	}
+	return compressionNone, fmt.Errorf("Unknown compression algorithm '%s'", name)
+}
+
+func hasCompressionMagic(buf []byte) bool {
+	return len(buf) > len(compressionMagic) && string(buf[:len(compressionMagic)]) == compressionMagic
+}
+
+func escapeCompressionMagic(buf []byte) []byte {
+	if !hasCompressionMagic(buf) {
+		return buf
+	}
+	return append([]byte(compressionMagic+string(compressionNone)), buf...)
+}
+
+func compress(ctx *context, buf []byte) []byte {
+	if ctx.compression == compressionNone || len(buf) < ctx.compressionThreshold {
+		return escapeCompressionMagic(buf)
+	}
+	out := bytes.NewBufferString(compressionMagic)
+	out.WriteByte(ctx.compression)
+	switch ctx.compression {
+	case compressionGzip:
+		w := gzip.NewWriter(out)
+		w.Write(buf)
+		w.Close()
+	case compressionSnappy:
+		out.Write(snappy.Encode(nil, buf))
+	}
+	atomic.AddUint64(&ctx.counterCompressionIn, uint64(len(buf)))
+	if out.Len() >= len(buf) {
+		atomic.AddUint64(&ctx.counterCompressionOut, uint64(len(buf)))
+		return escapeCompressionMagic(buf)
+	}
+	atomic.AddUint64(&ctx.counterCompressionOut, uint64(out.Len()))
+	return out.Bytes()
+}
+
+// Values which cannot be decompressed were stored before compression was
+// enabled, and are returned as is.
+func decompress(buf []byte) ([]byte, error) {
+	if !hasCompressionMagic(buf) {
+		return buf, nil
+	}
+	payload := buf[len(compressionMagic)+1:]
+	switch buf[len(compressionMagic)] {
+	case compressionNone:
+		return payload, nil
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return buf, nil
+		}
+		res, err := ioutil.ReadAll(r)
+		if err != nil {
+			return buf, nil
+		}
+		return res, nil
+	case compressionSnappy:
+		res, err := snappy.Decode(nil, payload)
+		if err != nil {
+			return buf, nil
+		}
+		return res, nil
+	}
+	return buf, nil
+}
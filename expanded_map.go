@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"sort"
 	"strconv"
 	"time"
 
@@ -121,31 +124,41 @@ func cmdExpandedMapHGET(wf io.Writer, ctx *context, args [][]byte) error {
 	return writeBin(wf, rec, valueBinName, "$-1")
 }
 
-func expandedMapHset(wf io.Writer, ctx *context, k []byte, kk []byte, v []byte, ttl int) error {
+func expandedMapFieldBins(suffixedKey string, field string, value interface{}) []*as.Bin {
+	return []*as.Bin{as.NewBin(mainKeyBinName, suffixedKey), as.NewBin(secondKeyBinName, field), as.NewBin(valueBinName, value), as.NewBin("created_at", now())}
+}
+
+func expandedMapHset(wf io.Writer, ctx *context, k []byte, args [][]byte, ttl int) error {
 	suffixedKey, _, err := compositeExistsOrCreate(ctx, string(k), ttl)
 	if err != nil {
 		return err
 	}
-	key, err := formatCompositeKey(ctx, *suffixedKey, string(kk))
-	if err != nil {
-		return err
-	}
-	exists, err := ctx.client.Exists(ctx.readPolicy, key)
-	if err != nil {
-		return err
-	}
-	err = ctx.client.PutBins(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, as.NewBin(mainKeyBinName, *suffixedKey), as.NewBin(secondKeyBinName, string(kk)), as.NewBin(valueBinName, encode(ctx, v)), as.NewBin("created_at", now()))
-	if err != nil {
-		return err
-	}
-	if exists {
-		return writeLine(wf, ":0")
+	added := 0
+	for i := 0; i+1 < len(args); i += 2 {
+		key, err := formatCompositeKey(ctx, *suffixedKey, string(args[i]))
+		if err != nil {
+			return err
+		}
+		exists, err := ctx.client.Exists(ctx.readPolicy, key)
+		if err != nil {
+			return err
+		}
+		err = ctx.client.PutBins(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, expandedMapFieldBins(*suffixedKey, string(args[i]), encode(ctx, args[i+1]))...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			added++
+		}
 	}
-	return writeLine(wf, ":1")
+	return writeLine(wf, ":"+strconv.Itoa(added))
 }
 
 func cmdExpandedMapHSET(wf io.Writer, ctx *context, args [][]byte) error {
-	return expandedMapHset(wf, ctx, args[0], args[1], args[2], -1)
+	if len(args)%2 != 1 {
+		return fmt.Errorf("Wrong number of params for 'HSET': %d", len(args))
+	}
+	return expandedMapHset(wf, ctx, args[0], args[1:], -1)
 }
 
 func cmdExpandedMapHSETEX(wf io.Writer, ctx *context, args [][]byte) error {
@@ -153,7 +166,7 @@ func cmdExpandedMapHSETEX(wf io.Writer, ctx *context, args [][]byte) error {
 	if err != nil {
 		return err
 	}
-	return expandedMapHset(wf, ctx, args[0], args[2], args[3], ttl)
+	return expandedMapHset(wf, ctx, args[0], args[2:4], ttl)
 }
 
 func cmdExpandedMapHDEL(wf io.Writer, ctx *context, args [][]byte) error {
@@ -164,52 +177,125 @@ func cmdExpandedMapHDEL(wf io.Writer, ctx *context, args [][]byte) error {
 	if suffixedKey == nil {
 		return writeLine(wf, ":0")
 	}
+	removed := 0
+	for _, field := range hashFields(args[1:]) {
+		key, err := formatCompositeKey(ctx, *suffixedKey, field)
+		if err != nil {
+			return err
+		}
+		existed, err := ctx.client.Delete(ctx.writePolicy, key)
+		if err != nil {
+			return err
+		}
+		if existed {
+			removed++
+		}
+	}
+	return writeLine(wf, ":"+strconv.Itoa(removed))
+}
+
+func cmdExpandedMapHSETNX(wf io.Writer, ctx *context, args [][]byte) error {
+	suffixedKey, _, err := compositeExistsOrCreate(ctx, string(args[0]), -1)
+	if err != nil {
+		return err
+	}
 	key, err := formatCompositeKey(ctx, *suffixedKey, string(args[1]))
 	if err != nil {
 		return err
 	}
-	existed, err := ctx.client.Delete(ctx.writePolicy, key)
+	err = ctx.client.PutBins(createWritePolicyEx(ctx.expandedMapDefaultTTL, true), key, expandedMapFieldBins(*suffixedKey, string(args[1]), encode(ctx, args[2]))...)
 	if err != nil {
+		if errResultCode(err) == ase.KEY_EXISTS_ERROR {
+			return writeLine(wf, ":0")
+		}
 		return err
 	}
-	if existed {
-		return writeLine(wf, ":1")
+	return writeLine(wf, ":1")
+}
+
+func expandedMapGetField(ctx *context, k []byte, field []byte) (*as.Record, error) {
+	suffixedKey, err := compositeExists(ctx, string(k))
+	if err != nil {
+		return nil, err
+	}
+	if suffixedKey == nil {
+		return nil, nil
+	}
+	key, err := formatCompositeKey(ctx, *suffixedKey, string(field))
+	if err != nil {
+		return nil, err
 	}
-	return writeLine(wf, ":0")
+	return ctx.client.Get(ctx.readPolicy, key, valueBinName)
 }
 
-func cmdExpandedMapEXPIRE(wf io.Writer, ctx *context, args [][]byte) error {
-	ttl, err := strconv.Atoi(string(args[1]))
+func cmdExpandedMapHEXISTS(wf io.Writer, ctx *context, args [][]byte) error {
+	rec, err := expandedMapGetField(ctx, args[0], args[1])
 	if err != nil {
 		return err
 	}
-	key, err := formatCompositeKey(ctx, string(args[0]), mainSuffix)
+	if rec == nil || rec.Bins[valueBinName] == nil {
+		return writeLine(wf, ":0")
+	}
+	return writeLine(wf, ":1")
+}
+
+func cmdExpandedMapHSTRLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	rec, err := expandedMapGetField(ctx, args[0], args[1])
 	if err != nil {
 		return err
 	}
-	err = ctx.client.Touch(createWritePolicyEx(ttl, false), key)
-	if err == nil {
-		return writeLine(wf, ":1")
+	return writeBinLen(wf, rec, valueBinName)
+}
+
+func tryExpandedMapHIncrByFloat(ctx *context, suffixedKey string, field string, incr float64) ([]byte, error) {
+	key, err := formatCompositeKey(ctx, suffixedKey, field)
+	if err != nil {
+		return nil, err
 	}
-	if errResultCode(err) != ase.KEY_NOT_FOUND_ERROR {
-		return err
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, valueBinName)
+	if err != nil {
+		return nil, err
+	}
+	var current interface{}
+	policy := createWritePolicyEx(ctx.expandedMapDefaultTTL, true)
+	if rec != nil {
+		current = rec.Bins[valueBinName]
+		policy = createWritePolicyGeneration(rec.Generation, ctx.expandedMapDefaultTTL)
+	}
+	res, ok, err := incrFloat(current, incr)
+	if err != nil || !ok {
+		return nil, err
+	}
+	err = ctx.client.PutBins(policy, key, expandedMapFieldBins(suffixedKey, field, encode(ctx, res))...)
+	if err != nil {
+		return nil, err
 	}
-	return cmdEXPIRE(wf, ctx, args)
+	return res, nil
 }
 
-func cmdExpandedMapTTL(wf io.Writer, ctx *context, args [][]byte) error {
-	key, err := formatCompositeKey(ctx, string(args[0]), mainSuffix)
+func cmdExpandedMapHINCRBYFLOAT(wf io.Writer, ctx *context, args [][]byte) error {
+	incr, err := parseFloat(args[2])
 	if err != nil {
 		return err
 	}
-	rec, err := ctx.client.GetHeader(ctx.readPolicy, key)
+	suffixedKey, _, err := compositeExistsOrCreate(ctx, string(args[0]), -1)
 	if err != nil {
 		return err
 	}
-	if rec != nil {
-		return writeLine(wf, ":"+strconv.FormatUint(uint64(rec.Expiration), 10))
+	for i := 0; i < ctx.generationRetries; i++ {
+		res, err := tryExpandedMapHIncrByFloat(ctx, *suffixedKey, string(args[1]), incr)
+		if err == nil {
+			if res == nil {
+				return writeLine(wf, "$-1")
+			}
+			return writeByteArray(wf, res)
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
 	}
-	return cmdTTL(wf, ctx, args)
+	return errors.New("Too many retry for hincrbyfloat")
 }
 
 func cmdExpandedMapDEL(wf io.Writer, ctx *context, args [][]byte) error {
@@ -240,7 +326,7 @@ func cmdExpandedMapHMSET(wf io.Writer, ctx *context, args [][]byte) error {
 		if err != nil {
 			return err
 		}
-		err = ctx.client.PutBins(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, as.NewBin(mainKeyBinName, *suffixedKey), as.NewBin(secondKeyBinName, string(args[i])), as.NewBin(valueBinName, encode(ctx, args[i+1])), as.NewBin("created_at", now()))
+		err = ctx.client.PutBins(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, expandedMapFieldBins(*suffixedKey, string(args[i]), encode(ctx, args[i+1]))...)
 		if err != nil {
 			return err
 		}
@@ -270,36 +356,94 @@ func cmdExpandedMapHMGET(wf io.Writer, ctx *context, args [][]byte) error {
 	return writeArrayBin(wf, res, valueBinName, "")
 }
 
-func cmdExpandedMapHGETALL(wf io.Writer, ctx *context, args [][]byte) error {
-	suffixedKey, err := compositeExists(ctx, string(args[0]))
+// Returns the records of all the fields of a map, using the secondary index.
+func expandedMapFields(ctx *context, k []byte) ([]*as.Record, error) {
+	out := make([]*as.Record, 0)
+	suffixedKey, err := compositeExists(ctx, string(k))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if suffixedKey == nil {
-		return writeArray(wf, make([]interface{}, 0))
+		return out, nil
 	}
-	statement := as.NewStatement(ctx.ns, ctx.set)
-	statement.Addfilter(as.NewEqualFilter(mainKeyBinName, *suffixedKey))
-	recordset, err := ctx.client.Query(nil, statement)
+	recordset, err := ctx.client.Query(nil, ctx.ns, ctx.set, mainKeyBinName, *suffixedKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	out := make([]*as.Record, 0)
 	for res := range recordset.Results() {
 		if res.Err != nil {
-			return res.Err
+			return nil, res.Err
 		}
 		out = append(out, res.Record)
 	}
+	return out, nil
+}
+
+func cmdExpandedMapHGETALL(wf io.Writer, ctx *context, args [][]byte) error {
+	out, err := expandedMapFields(ctx, args[0])
+	if err != nil {
+		return err
+	}
 	return writeArrayBin(wf, out, valueBinName, secondKeyBinName)
 }
 
+func cmdExpandedMapHLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	out, err := expandedMapFields(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, ":"+strconv.Itoa(len(out)))
+}
+
+func cmdExpandedMapHKEYS(wf io.Writer, ctx *context, args [][]byte) error {
+	out, err := expandedMapFields(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return writeArrayBin(wf, out, secondKeyBinName, "")
+}
+
+func cmdExpandedMapHVALS(wf io.Writer, ctx *context, args [][]byte) error {
+	out, err := expandedMapFields(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return writeArrayBin(wf, out, valueBinName, "")
+}
+
+func cmdExpandedMapHSCAN(wf io.Writer, ctx *context, args [][]byte) error {
+	scan, err := parseScanArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	out, err := expandedMapFields(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	names := make([]string, 0, len(out))
+	for _, rec := range out {
+		name := rec.Bins[secondKeyBinName].(string)
+		values[name] = rec.Bins[valueBinName]
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cursor, page := scan.page(names)
+	fields := make([][]byte, len(page))
+	res := make([]interface{}, len(page))
+	for i, e := range page {
+		fields[i] = []byte(e)
+		res[i] = values[e]
+	}
+	return writeScanResult(wf, cursor, fields, res)
+}
+
 func compositeIncr(wf io.Writer, ctx *context, suffixedKey *string, field string, value int) error {
 	key, err := formatCompositeKey(ctx, *suffixedKey, field)
 	if err != nil {
 		return err
 	}
-	rec, err := ctx.client.Operate(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, as.PutOp(as.NewBin(mainKeyBinName, *suffixedKey)), as.PutOp(as.NewBin(secondKeyBinName, field)), as.AddOp(as.NewBin(valueBinName, value)), as.GetOpForBin(valueBinName))
+	rec, err := ctx.client.Operate(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, putOp(as.NewBin(mainKeyBinName, *suffixedKey)), putOp(as.NewBin(secondKeyBinName, field)), addOp(as.NewBin(valueBinName, value)), getOpForBin(valueBinName))
 	if err != nil {
 		if errResultCode(err) == ase.BIN_TYPE_ERROR {
 			return writeLine(wf, "$-1")
@@ -357,7 +501,7 @@ func cmdExpandedMapHMINCRBYEX(wf io.Writer, ctx *context, args [][]byte) error {
 			if err != nil {
 				return err
 			}
-			_, err = ctx.client.Operate(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, as.PutOp(as.NewBin(mainKeyBinName, *suffixedKey)), as.PutOp(as.NewBin(secondKeyBinName, string(a[i]))), as.AddOp(as.NewBin(valueBinName, incr)))
+			_, err = ctx.client.Operate(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, putOp(as.NewBin(mainKeyBinName, *suffixedKey)), putOp(as.NewBin(secondKeyBinName, string(a[i]))), addOp(as.NewBin(valueBinName, incr)))
 			if err != nil {
 				return err
 			}
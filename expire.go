@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Aerospike ttls are in seconds: millisecond expirations are rounded up to
+// the next second, and millisecond ttls are multiples of 1000.
+const secondUnit = 1000
+const millisecondUnit = 1
+
+// Conditions of EXPIRE, only checked when one of them is set.
+type expireCondition struct {
+	nx bool
+	xx bool
+	gt bool
+	lt bool
+}
+
+func (c expireCondition) isSet() bool {
+	return c.nx || c.xx || c.gt || c.lt
+}
+
+// Checks the condition against the current ttl of the record, in seconds.
+// A key without expiration has an infinite ttl.
+func (c expireCondition) match(current uint32, ttl int64) bool {
+	persistent := current == as.TTLDontExpire
+	if c.nx && !persistent {
+		return false
+	}
+	if c.xx && persistent {
+		return false
+	}
+	if c.gt && (persistent || ttl <= int64(current)) {
+		return false
+	}
+	if c.lt && !persistent && ttl >= int64(current) {
+		return false
+	}
+	return true
+}
+
+func parseExpireCondition(args [][]byte) (expireCondition, error) {
+	c := expireCondition{}
+	for _, a := range args {
+		switch strings.ToUpper(string(a)) {
+		case "NX":
+			c.nx = true
+		case "XX":
+			c.xx = true
+		case "GT":
+			c.gt = true
+		case "LT":
+			c.lt = true
+		default:
+			return c, errors.New("Unsupported option " + string(a))
+		}
+	}
+	if c.nx && (c.xx || c.gt || c.lt) {
+		return c, errors.New("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if c.gt && c.lt {
+		return c, errors.New("GT and LT options at the same time are not compatible")
+	}
+	return c, nil
+}
+
+// Returns the number of milliseconds before the expiration, which can be
+// negative for an absolute time in the past.
+func parseExpireTime(value []byte, unit int64, absolute bool) (int64, error) {
+	x, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	ms := x * unit
+	if absolute {
+		ms -= int64(nowMillis())
+	}
+	return ms, nil
+}
+
+// Sets the ttl of a record, rounded up to the next second. Like Redis, a ttl
+// in the past deletes the record. The record is read first to check the
+// condition, and touched with a generation check.
+func tryExpire(ctx *context, key *as.Key, ms int64, cond expireCondition) (bool, error) {
+	ttl := (ms + secondUnit - 1) / secondUnit
+	if !cond.isSet() {
+		if ms <= 0 {
+			return ctx.client.Delete(ctx.writePolicy, key)
+		}
+		err := ctx.client.Touch(createWritePolicyEx(int(ttl), false), key)
+		if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	rec, err := ctx.client.GetHeader(createMasterReadPolicy(), key)
+	if err != nil {
+		return false, err
+	}
+	if rec == nil || !cond.match(rec.Expiration, ttl) {
+		return false, nil
+	}
+	policy := createWritePolicyGeneration(rec.Generation, int(ttl))
+	if ms <= 0 {
+		return ctx.client.Delete(policy, key)
+	}
+	err = ctx.client.Touch(policy, key)
+	if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func expireKey(wf io.Writer, ctx *context, key *as.Key, args [][]byte, unit int64, absolute bool) (bool, error) {
+	ms, err := parseExpireTime(args[1], unit, absolute)
+	if err != nil {
+		return false, err
+	}
+	cond, err := parseExpireCondition(args[2:])
+	if err != nil {
+		return false, writeErrorReply(wf, err.Error())
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		done, err := tryExpire(ctx, key, ms, cond)
+		if errResultCode(err) != ase.GENERATION_ERROR {
+			if err != nil {
+				return false, err
+			}
+			if done {
+				return true, writeLine(wf, ":1")
+			}
+			return false, writeLine(wf, ":0")
+		}
+	}
+	return false, errors.New("Too many retry for expire")
+}
+
+func expire(wf io.Writer, ctx *context, args [][]byte, unit int64, absolute bool) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, err = expireKey(wf, ctx, key, args, unit, absolute)
+	return err
+}
+
+func cmdEXPIRE(wf io.Writer, ctx *context, args [][]byte) error {
+	return expire(wf, ctx, args, secondUnit, false)
+}
+
+func cmdPEXPIRE(wf io.Writer, ctx *context, args [][]byte) error {
+	return expire(wf, ctx, args, millisecondUnit, false)
+}
+
+func cmdEXPIREAT(wf io.Writer, ctx *context, args [][]byte) error {
+	return expire(wf, ctx, args, secondUnit, true)
+}
+
+func cmdPEXPIREAT(wf io.Writer, ctx *context, args [][]byte) error {
+	return expire(wf, ctx, args, millisecondUnit, true)
+}
+
+// Writes the ttl of a record, or its expiration time if absolute is set.
+func writeTTL(wf io.Writer, rec *as.Record, unit int64, absolute bool) error {
+	if rec == nil {
+		return writeLine(wf, ":-2")
+	}
+	if rec.Expiration == as.TTLDontExpire {
+		return writeLine(wf, ":-1")
+	}
+	ms := int64(rec.Expiration) * secondUnit
+	if absolute {
+		ms += int64(nowMillis())
+	}
+	return writeLine(wf, ":"+strconv.FormatInt(ms/unit, 10))
+}
+
+func ttl(wf io.Writer, ctx *context, args [][]byte, unit int64, absolute bool) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.GetHeader(ctx.readPolicy, key)
+	if err != nil {
+		return err
+	}
+	return writeTTL(wf, rec, unit, absolute)
+}
+
+func cmdTTL(wf io.Writer, ctx *context, args [][]byte) error {
+	return ttl(wf, ctx, args, secondUnit, false)
+}
+
+func cmdPTTL(wf io.Writer, ctx *context, args [][]byte) error {
+	return ttl(wf, ctx, args, millisecondUnit, false)
+}
+
+func cmdEXPIRETIME(wf io.Writer, ctx *context, args [][]byte) error {
+	return ttl(wf, ctx, args, secondUnit, true)
+}
+
+func cmdPEXPIRETIME(wf io.Writer, ctx *context, args [][]byte) error {
+	return ttl(wf, ctx, args, millisecondUnit, true)
+}
+
+// The ttl of an expanded map is the ttl of its main record.
+func expandedMapExpire(wf io.Writer, ctx *context, args [][]byte, unit int64, absolute bool) error {
+	key, err := formatCompositeKey(ctx, string(args[0]), mainSuffix)
+	if err != nil {
+		return err
+	}
+	exists, err := ctx.client.Exists(createMasterReadPolicy(), key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return expire(wf, ctx, args, unit, absolute)
+	}
+	done, err := expireKey(wf, ctx, key, args, unit, absolute)
+	if done {
+		expandedMapInvalidate(ctx, args[0])
+	}
+	return err
+}
+
+func cmdExpandedMapEXPIRE(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapExpire(wf, ctx, args, secondUnit, false)
+}
+
+func cmdExpandedMapPEXPIRE(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapExpire(wf, ctx, args, millisecondUnit, false)
+}
+
+func cmdExpandedMapEXPIREAT(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapExpire(wf, ctx, args, secondUnit, true)
+}
+
+func cmdExpandedMapPEXPIREAT(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapExpire(wf, ctx, args, millisecondUnit, true)
+}
+
+func expandedMapTTL(wf io.Writer, ctx *context, args [][]byte, unit int64, absolute bool) error {
+	key, err := formatCompositeKey(ctx, string(args[0]), mainSuffix)
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.GetHeader(ctx.readPolicy, key)
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		return writeTTL(wf, rec, unit, absolute)
+	}
+	return ttl(wf, ctx, args, unit, absolute)
+}
+
+func cmdExpandedMapTTL(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapTTL(wf, ctx, args, secondUnit, false)
+}
+
+func cmdExpandedMapPTTL(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapTTL(wf, ctx, args, millisecondUnit, false)
+}
+
+func cmdExpandedMapEXPIRETIME(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapTTL(wf, ctx, args, secondUnit, true)
+}
+
+func cmdExpandedMapPEXPIRETIME(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapTTL(wf, ctx, args, millisecondUnit, true)
+}
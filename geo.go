@@ -0,0 +1,557 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Geo members are stored in a map bin, ordered by value, from the member to
+// its 52 bits geohash. The geohash is computed like Redis does for its sorted
+// set scores, so positions and distances are the same as with Redis.
+// Searches are done by Aerodis, on all the members of the key.
+
+const geoStep = 26
+const geoLatMin = -85.05112878
+const geoLatMax = 85.05112878
+const geoLonMin = -180.0
+const geoLonMax = 180.0
+
+const earthRadiusInMeters = 6372797.560856
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+var geoMapPolicy = newMapPolicy(true, false)
+
+func interleave(x uint32, y uint32) uint64 {
+	res := uint64(0)
+	for i := uint(0); i < 32; i++ {
+		res |= uint64((x>>i)&1)<<(2*i) | uint64((y>>i)&1)<<(2*i+1)
+	}
+	return res
+}
+
+func deinterleave(x uint64) (uint32, uint32) {
+	a, b := uint32(0), uint32(0)
+	for i := uint(0); i < 32; i++ {
+		a |= uint32((x>>(2*i))&1) << i
+		b |= uint32((x>>(2*i+1))&1) << i
+	}
+	return a, b
+}
+
+func geohashEncode(lon float64, lat float64, lonMin float64, lonMax float64, latMin float64, latMax float64) uint64 {
+	latOffset := (lat - latMin) / (latMax - latMin) * (1 << geoStep)
+	lonOffset := (lon - lonMin) / (lonMax - lonMin) * (1 << geoStep)
+	return interleave(uint32(latOffset), uint32(lonOffset))
+}
+
+// Returns the center of the area of a geohash.
+func geohashDecode(hash uint64) (float64, float64) {
+	ilat, ilon := deinterleave(hash)
+	latScale := geoLatMax - geoLatMin
+	lonScale := geoLonMax - geoLonMin
+	latMin := geoLatMin + (float64(ilat)*1.0/(1<<geoStep))*latScale
+	latMax := geoLatMin + (float64(ilat+1)*1.0/(1<<geoStep))*latScale
+	lonMin := geoLonMin + (float64(ilon)*1.0/(1<<geoStep))*lonScale
+	lonMax := geoLonMin + (float64(ilon+1)*1.0/(1<<geoStep))*lonScale
+	lon := math.Max(geoLonMin, math.Min(geoLonMax, (lonMin+lonMax)/2))
+	lat := math.Max(geoLatMin, math.Min(geoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+// Standard geohash string, as returned by GEOHASH
+func geohashString(hash uint64) string {
+	lon, lat := geohashDecode(hash)
+	bits := geohashEncode(lon, lat, -180, 180, -90, 90)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := uint64(0)
+		if i < 10 {
+			idx = (bits >> uint(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(x float64) float64 {
+	return x * math.Pi / 180
+}
+
+func geoDistance(lon1 float64, lat1 float64, lon2 float64, lat2 float64) float64 {
+	lat1r, lon1r := degRad(lat1), degRad(lon1)
+	lat2r, lon2r := degRad(lat2), degRad(lon2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	return 2.0 * earthRadiusInMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func parseCoordinates(lonBuf []byte, latBuf []byte) (float64, float64, error) {
+	lon, err := parseFloat(lonBuf)
+	if err != nil {
+		return 0, 0, err
+	}
+	lat, err := parseFloat(latBuf)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, fmt.Errorf("invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// Returns the number of meters in a distance unit
+func parseGeoUnit(buf []byte) (float64, error) {
+	switch strings.ToLower(string(buf)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errors.New("unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func formatDistance(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
+func formatCoordinate(x float64) string {
+	return strconv.FormatFloat(x, 'g', 17, 64)
+}
+
+// Returns the members stored in a record, nil if the record or the bin does
+// not exist.
+func geoRecordMembers(rec *as.Record) (map[interface{}]interface{}, error) {
+	if rec == nil || rec.Bins[binName] == nil {
+		return nil, nil
+	}
+	members, ok := rec.Bins[binName].(map[interface{}]interface{})
+	if !ok {
+		return nil, errWrongType
+	}
+	return members, nil
+}
+
+// Reads the members of a geo key, nil if the key does not exist.
+func geoMembers(ctx *context, k []byte) (map[interface{}]interface{}, error) {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+	if err != nil {
+		return nil, err
+	}
+	return geoRecordMembers(rec)
+}
+
+func tryGeoAdd(ctx *context, key *as.Key, members []string, hashes []int, nx bool, xx bool, ch bool) (int, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, binName)
+	if err != nil {
+		return 0, err
+	}
+	current, err := geoRecordMembers(rec)
+	if err != nil {
+		return 0, err
+	}
+	if current == nil {
+		current = make(map[interface{}]interface{})
+	}
+	policy := createWritePolicyEx(-1, true)
+	if rec != nil {
+		policy = createWritePolicyGeneration(rec.Generation, -1)
+	}
+	items := make(map[interface{}]interface{})
+	count := 0
+	for i, m := range members {
+		old, exists := current[m]
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		if !exists || (ch && old != hashes[i]) {
+			count++
+		}
+		current[m] = hashes[i]
+		items[m] = hashes[i]
+	}
+	if len(items) == 0 {
+		return count, nil
+	}
+	_, err = ctx.client.Operate(policy, key, mapPutItemsOp(geoMapPolicy, binName, items))
+	return count, err
+}
+
+func cmdGEOADD(wf io.Writer, ctx *context, args [][]byte) error {
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NX" {
+			nx = true
+		} else if option == "XX" {
+			xx = true
+		} else if option == "CH" {
+			ch = true
+		} else {
+			break
+		}
+	}
+	if nx && xx {
+		return writeErrorReply(wf, "XX and NX options at the same time are not compatible")
+	}
+	if len(args) == i || (len(args)-i)%3 != 0 {
+		return errors.New("Wrong number of params for geoadd")
+	}
+	members := make([]string, 0, (len(args)-i)/3)
+	hashes := make([]int, 0, (len(args)-i)/3)
+	for ; i+2 < len(args); i += 3 {
+		lon, lat, err := parseCoordinates(args[i], args[i+1])
+		if err != nil {
+			return writeErrorReply(wf, err.Error())
+		}
+		members = append(members, string(args[i+2]))
+		hashes = append(hashes, int(geohashEncode(lon, lat, geoLonMin, geoLonMax, geoLatMin, geoLatMax)))
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		count, err := tryGeoAdd(ctx, key, members, hashes, nx, xx, ch)
+		if err == nil {
+			return writeLine(wf, ":"+strconv.Itoa(count))
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for geoadd")
+}
+
+func writeCoordinates(wf io.Writer, lon float64, lat float64) error {
+	err := writeLine(wf, "*2")
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, []byte(formatCoordinate(lon)))
+	if err != nil {
+		return err
+	}
+	return writeByteArray(wf, []byte(formatCoordinate(lat)))
+}
+
+func cmdGEOPOS(wf io.Writer, ctx *context, args [][]byte) error {
+	members, err := geoMembers(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(args)-1))
+	if err != nil {
+		return err
+	}
+	for _, m := range args[1:] {
+		hash, ok := members[string(m)]
+		if !ok {
+			err = writeLine(wf, "*-1")
+		} else {
+			lon, lat := geohashDecode(uint64(hash.(int)))
+			err = writeCoordinates(wf, lon, lat)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdGEOHASH(wf io.Writer, ctx *context, args [][]byte) error {
+	members, err := geoMembers(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(args)-1))
+	if err != nil {
+		return err
+	}
+	for _, m := range args[1:] {
+		hash, ok := members[string(m)]
+		if !ok {
+			err = writeLine(wf, "$-1")
+		} else {
+			err = writeByteArray(wf, []byte(geohashString(uint64(hash.(int)))))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdGEODIST(wf io.Writer, ctx *context, args [][]byte) error {
+	unit := 1.0
+	if len(args) > 3 {
+		var err error
+		unit, err = parseGeoUnit(args[3])
+		if err != nil {
+			return writeErrorReply(wf, err.Error())
+		}
+	}
+	members, err := geoMembers(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	hash1, ok1 := members[string(args[1])]
+	hash2, ok2 := members[string(args[2])]
+	if !ok1 || !ok2 {
+		return writeLine(wf, "$-1")
+	}
+	lon1, lat1 := geohashDecode(uint64(hash1.(int)))
+	lon2, lat2 := geohashDecode(uint64(hash2.(int)))
+	return writeByteArray(wf, []byte(formatDistance(geoDistance(lon1, lat1, lon2, lat2)/unit)))
+}
+
+type geoSearch struct {
+	fromMember []byte
+	lon        float64
+	lat        float64
+	hasFrom    bool
+	radius     float64
+	width      float64
+	height     float64
+	byBox      bool
+	hasBy      bool
+	unit       float64
+	sort       int
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+}
+
+type geoPoint struct {
+	member string
+	hash   uint64
+	lon    float64
+	lat    float64
+	dist   float64
+}
+
+func parseGeoSearch(args [][]byte) (*geoSearch, error) {
+	s := &geoSearch{}
+	var err error
+	for i := 0; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch strings.ToUpper(string(args[i])) {
+		case "FROMMEMBER":
+			if left < 1 || s.hasFrom {
+				return nil, errors.New("syntax error")
+			}
+			s.fromMember = args[i+1]
+			s.hasFrom = true
+			i++
+		case "FROMLONLAT":
+			if left < 2 || s.hasFrom {
+				return nil, errors.New("syntax error")
+			}
+			s.lon, s.lat, err = parseCoordinates(args[i+1], args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			s.hasFrom = true
+			i += 2
+		case "BYRADIUS":
+			if left < 2 || s.hasBy {
+				return nil, errors.New("syntax error")
+			}
+			s.radius, err = parseFloat(args[i+1])
+			if err != nil || s.radius < 0 {
+				return nil, errors.New("radius cannot be negative")
+			}
+			s.unit, err = parseGeoUnit(args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			s.hasBy = true
+			i += 2
+		case "BYBOX":
+			if left < 3 || s.hasBy {
+				return nil, errors.New("syntax error")
+			}
+			s.width, err = parseFloat(args[i+1])
+			if err != nil || s.width < 0 {
+				return nil, errors.New("width or height cannot be negative")
+			}
+			s.height, err = parseFloat(args[i+2])
+			if err != nil || s.height < 0 {
+				return nil, errors.New("width or height cannot be negative")
+			}
+			s.unit, err = parseGeoUnit(args[i+3])
+			if err != nil {
+				return nil, err
+			}
+			s.byBox = true
+			s.hasBy = true
+			i += 3
+		case "ASC":
+			s.sort = 1
+		case "DESC":
+			s.sort = -1
+		case "COUNT":
+			if left < 1 {
+				return nil, errors.New("syntax error")
+			}
+			s.count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || s.count <= 0 {
+				return nil, errors.New("COUNT must be > 0")
+			}
+			i++
+			if left > 1 && strings.ToUpper(string(args[i+1])) == "ANY" {
+				s.any = true
+				i++
+			}
+		case "WITHCOORD":
+			s.withCoord = true
+		case "WITHDIST":
+			s.withDist = true
+		case "WITHHASH":
+			s.withHash = true
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+	if !s.hasFrom || !s.hasBy {
+		return nil, errors.New("exactly one of FROMMEMBER or FROMLONLAT and one of BYRADIUS or BYBOX can be specified")
+	}
+	// Like Redis, results are sorted when a count is given, to return the
+	// closest members
+	if s.count > 0 && !s.any && s.sort == 0 {
+		s.sort = 1
+	}
+	return s, nil
+}
+
+// Returns the distance from the center of the search, false if the point is
+// outside of the searched area.
+func (s *geoSearch) distance(lon float64, lat float64) (float64, bool) {
+	if !s.byBox {
+		d := geoDistance(s.lon, s.lat, lon, lat)
+		return d, d <= s.radius*s.unit
+	}
+	if earthRadiusInMeters*math.Abs(degRad(lat)-degRad(s.lat)) > s.height*s.unit/2 {
+		return 0, false
+	}
+	if geoDistance(lon, lat, s.lon, lat) > s.width*s.unit/2 {
+		return 0, false
+	}
+	return geoDistance(s.lon, s.lat, lon, lat), true
+}
+
+func (s *geoSearch) search(members map[interface{}]interface{}) []geoPoint {
+	res := make([]geoPoint, 0)
+	for m, h := range members {
+		hash := uint64(h.(int))
+		lon, lat := geohashDecode(hash)
+		d, ok := s.distance(lon, lat)
+		if !ok {
+			continue
+		}
+		res = append(res, geoPoint{m.(string), hash, lon, lat, d})
+		if s.any && len(res) == s.count {
+			break
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if s.sort == 0 || res[i].dist == res[j].dist {
+			return res[i].hash < res[j].hash
+		}
+		if s.sort > 0 {
+			return res[i].dist < res[j].dist
+		}
+		return res[i].dist > res[j].dist
+	})
+	if s.count > 0 && len(res) > s.count {
+		res = res[:s.count]
+	}
+	return res
+}
+
+func (s *geoSearch) writePoint(wf io.Writer, p geoPoint) error {
+	if !s.withCoord && !s.withDist && !s.withHash {
+		return writeByteArray(wf, []byte(p.member))
+	}
+	l := 1
+	for _, b := range []bool{s.withCoord, s.withDist, s.withHash} {
+		if b {
+			l++
+		}
+	}
+	err := writeLine(wf, "*"+strconv.Itoa(l))
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, []byte(p.member))
+	if err != nil {
+		return err
+	}
+	if s.withDist {
+		err = writeByteArray(wf, []byte(formatDistance(p.dist/s.unit)))
+		if err != nil {
+			return err
+		}
+	}
+	if s.withHash {
+		err = writeLine(wf, ":"+strconv.FormatUint(p.hash, 10))
+		if err != nil {
+			return err
+		}
+	}
+	if s.withCoord {
+		return writeCoordinates(wf, p.lon, p.lat)
+	}
+	return nil
+}
+
+func cmdGEOSEARCH(wf io.Writer, ctx *context, args [][]byte) error {
+	s, err := parseGeoSearch(args[1:])
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	members, err := geoMembers(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if s.fromMember != nil {
+		hash, ok := members[string(s.fromMember)]
+		if !ok {
+			return writeErrorReply(wf, "could not decode requested zset member")
+		}
+		s.lon, s.lat = geohashDecode(uint64(hash.(int)))
+	}
+	res := s.search(members)
+	err = writeLine(wf, "*"+strconv.Itoa(len(res)))
+	if err != nil {
+		return err
+	}
+	for _, p := range res {
+		err = s.writePoint(wf, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Same number of registers as Redis, for a standard error of 0.81%.
+// Min hash is not used, it is only needed for similarity queries.
+const hllIndexBitCount = 14
+const hllMinHashBitCount = 0
+
+const hllWrongTypeError = "WRONGTYPE Key is not a valid HyperLogLog string value."
+
+var hllCreatePolicy = newHLLPolicy(as.HLLWriteFlagsCreateOnly | as.HLLWriteFlagsNoFail)
+
+var errHllWrongType = errors.New(hllWrongTypeError)
+
+func pfadd(wf io.Writer, ctx *context, k []byte, elements [][]byte, policy *as.WritePolicy) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		exists, err := ctx.client.Exists(createMasterReadPolicy(), key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return writeLine(wf, ":0")
+		}
+		_, err = operate(ctx, policy, key, hllInitOp(hllCreatePolicy, binName, hllIndexBitCount, hllMinHashBitCount))
+		if err != nil {
+			return err
+		}
+		return writeLine(wf, ":1")
+	}
+	values := make([]as.Value, len(elements))
+	for i, e := range elements {
+		values[i] = as.NewBytesValue(e)
+	}
+	rec, err := operate(ctx, policy, key, hllAddOp(defaultHLLPolicy, binName, values, hllIndexBitCount, hllMinHashBitCount))
+	if err != nil {
+		if errResultCode(err) == ase.BIN_TYPE_ERROR {
+			return writeLine(wf, "-"+hllWrongTypeError)
+		}
+		return err
+	}
+	updated, _ := rec.Bins[binName].(int)
+	if updated > 0 {
+		return writeLine(wf, ":1")
+	}
+	return writeLine(wf, ":0")
+}
+
+func cmdPFADD(wf io.Writer, ctx *context, args [][]byte) error {
+	return pfadd(wf, ctx, args[0], args[1:], ctx.writePolicy)
+}
+
+func cmdPFADDEX(wf io.Writer, ctx *context, args [][]byte) error {
+	ttl, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	return pfadd(wf, ctx, args[0], args[2:], createWritePolicyEx(ttl, false))
+}
+
+// Returns the HLL stored in the given keys, skipping missing keys.
+func hllValues(ctx *context, keys [][]byte) ([]*as.Key, []as.HLLValue, error) {
+	resKeys := make([]*as.Key, 0, len(keys))
+	res := make([]as.HLLValue, 0, len(keys))
+	for _, k := range keys {
+		key, err := buildKey(ctx, k)
+		if err != nil {
+			return nil, nil, err
+		}
+		rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rec == nil || rec.Bins[binName] == nil {
+			continue
+		}
+		hll, ok := rec.Bins[binName].(as.HLLValue)
+		if !ok {
+			return nil, nil, errHllWrongType
+		}
+		resKeys = append(resKeys, key)
+		res = append(res, hll)
+	}
+	return resKeys, res, nil
+}
+
+// The count of several keys is computed by the server holding the first one.
+func cmdPFCOUNT(wf io.Writer, ctx *context, args [][]byte) error {
+	keys, hlls, err := hllValues(ctx, args)
+	if err == errHllWrongType {
+		return writeLine(wf, "-"+hllWrongTypeError)
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return writeLine(wf, ":0")
+	}
+	op := hllGetCountOp(binName)
+	if len(keys) > 1 {
+		op = hllGetUnionCountOp(binName, hlls[1:])
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, keys[0], op)
+	if err != nil {
+		if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+			return writeLine(wf, ":0")
+		}
+		return err
+	}
+	return writeBinInt(wf, rec, binName)
+}
+
+func pfmerge(wf io.Writer, ctx *context, k []byte, sources [][]byte, policy *as.WritePolicy) error {
+	_, hlls, err := hllValues(ctx, sources)
+	if err == errHllWrongType {
+		return writeLine(wf, "-"+hllWrongTypeError)
+	}
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	op := hllInitOp(hllCreatePolicy, binName, hllIndexBitCount, hllMinHashBitCount)
+	if len(hlls) > 0 {
+		op = hllSetUnionOp(defaultHLLPolicy, binName, hlls)
+	}
+	_, err = operate(ctx, policy, key, op)
+	if err != nil {
+		if errResultCode(err) == ase.BIN_TYPE_ERROR {
+			return writeLine(wf, "-"+hllWrongTypeError)
+		}
+		return err
+	}
+	return writeLine(wf, "+OK")
+}
+
+func cmdPFMERGE(wf io.Writer, ctx *context, args [][]byte) error {
+	return pfmerge(wf, ctx, args[0], args[1:], ctx.writePolicy)
+}
+
+func cmdPFMERGEEX(wf io.Writer, ctx *context, args [][]byte) error {
+	ttl, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	return pfmerge(wf, ctx, args[0], args[2:], createWritePolicyEx(ttl, false))
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Number of keys read by RANDOMKEY to choose one
+const randomKeySampleSize = 1000
+
+var errNoSuchKey = errors.New("no such key")
+
+// Maps are copied ordered, to keep the order of the streams, geo keys and cdt
+// maps.
+var copyMapPolicy = newMapPolicy(true, false)
+
+func recordCopyOps(rec *as.Record) []*operation {
+	ops := make([]*operation, 0, len(rec.Bins))
+	for name, value := range rec.Bins {
+		switch value.(type) {
+		case map[interface{}]interface{}:
+			ops = append(ops, mapPutItemsOp(copyMapPolicy, name, value.(map[interface{}]interface{})))
+		default:
+			ops = append(ops, putOp(as.NewBin(name, value)))
+		}
+	}
+	return ops
+}
+
+// Writes the bins of a record to another key, with the same ttl. The
+// destination is deleted first if replace is true, otherwise the copy fails
+// with KEY_EXISTS_ERROR if the destination exists.
+func copyRecord(ctx *context, rec *as.Record, dst *as.Key, replace bool) error {
+	if replace {
+		_, err := ctx.client.Delete(ctx.writePolicy, dst)
+		if err != nil {
+			return err
+		}
+	}
+	policy := createWritePolicyEx(-1, true)
+	policy.Expiration = rec.Expiration
+	_, err := ctx.client.Operate(policy, dst, recordCopyOps(rec)...)
+	return err
+}
+
+func tryRename(ctx *context, src *as.Key, dst *as.Key, replace bool) error {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), src)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return errNoSuchKey
+	}
+	err = copyRecord(ctx, rec, dst, replace)
+	if err != nil {
+		return err
+	}
+	_, err = ctx.client.Delete(createWritePolicyGeneration(rec.Generation, -1), src)
+	return err
+}
+
+// Copies the record then deletes the source. If the source is modified in
+// the meantime, the copy is done again.
+func rename(wf io.Writer, ctx *context, k []byte, newK []byte, nx bool) error {
+	src, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	if string(k) == string(newK) {
+		exists, err := ctx.client.Exists(createMasterReadPolicy(), src)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return writeErrorReply(wf, "no such key")
+		}
+		return writeRenameReply(wf, nx, false)
+	}
+	dst, err := buildKey(ctx, newK)
+	if err != nil {
+		return err
+	}
+	replace := !nx
+	for i := 0; i < ctx.generationRetries; i++ {
+		err = tryRename(ctx, src, dst, replace)
+		if err == errNoSuchKey {
+			return writeErrorReply(wf, "no such key")
+		}
+		if err == nil {
+			return writeRenameReply(wf, nx, true)
+		}
+		code := errResultCode(err)
+		if code == ase.KEY_EXISTS_ERROR && !replace {
+			return writeRenameReply(wf, nx, false)
+		}
+		if code != ase.GENERATION_ERROR {
+			return err
+		}
+		// The destination is the copy of the previous try
+		replace = true
+	}
+	return errors.New("Too many retry for rename")
+}
+
+func writeRenameReply(wf io.Writer, nx bool, renamed bool) error {
+	if !nx {
+		return writeLine(wf, "+OK")
+	}
+	if renamed {
+		return writeLine(wf, ":1")
+	}
+	return writeLine(wf, ":0")
+}
+
+func cmdRENAME(wf io.Writer, ctx *context, args [][]byte) error {
+	return rename(wf, ctx, args[0], args[1], false)
+}
+
+func cmdRENAMENX(wf io.Writer, ctx *context, args [][]byte) error {
+	return rename(wf, ctx, args[0], args[1], true)
+}
+
+// Returns the REPLACE flag of COPY. The DB option is only accepted for the
+// db 0, Aerodis has no databases.
+func parseCopyArgs(args [][]byte) (bool, error) {
+	replace := false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) || string(args[i+1]) != "0" {
+				return false, errors.New("DB option is not supported")
+			}
+			i++
+		default:
+			return false, errors.New("syntax error")
+		}
+	}
+	return replace, nil
+}
+
+func cmdCOPY(wf io.Writer, ctx *context, args [][]byte) error {
+	replace, err := parseCopyArgs(args[2:])
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	if string(args[0]) == string(args[1]) {
+		return writeErrorReply(wf, "source and destination objects are the same")
+	}
+	src, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := buildKey(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, src)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeLine(wf, ":0")
+	}
+	err = copyRecord(ctx, rec, dst, replace)
+	if errResultCode(err) == ase.KEY_EXISTS_ERROR {
+		return writeLine(wf, ":0")
+	}
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, ":1")
+}
+
+// Guesses the Redis type of a record from its bins. mapType is the type of a
+// map stored in the main bin: geo keys are sorted sets, but the main bin is
+// also used by hashes in cdt map mode.
+func recordType(rec *as.Record, mapType string) string {
+	if rec == nil {
+		return "none"
+	}
+	if rec.Bins[streamUIDBin] != nil || rec.Bins[streamBin] != nil {
+		return "stream"
+	}
+	if rec.Bins[sizeArrayField] != nil {
+		return "list"
+	}
+	switch rec.Bins[binName].(type) {
+	case nil:
+		return "hash"
+	case []interface{}:
+		return "list"
+	case map[interface{}]interface{}:
+		return mapType
+	}
+	return "string"
+}
+
+func keyType(wf io.Writer, ctx *context, k []byte, mapType string) error {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key)
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, "+"+recordType(rec, mapType))
+}
+
+func cmdTYPE(wf io.Writer, ctx *context, args [][]byte) error {
+	return keyType(wf, ctx, args[0], "zset")
+}
+
+func cmdCdtMapTYPE(wf io.Writer, ctx *context, args [][]byte) error {
+	return keyType(wf, ctx, args[0], "hash")
+}
+
+func persist(ctx *context, key *as.Key) (bool, error) {
+	rec, err := ctx.client.GetHeader(createMasterReadPolicy(), key)
+	if err != nil {
+		return false, err
+	}
+	if rec == nil || rec.Expiration == as.TTLDontExpire {
+		return false, nil
+	}
+	err = ctx.client.Touch(createWritePolicyEx(-2, false), key)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func cmdPERSIST(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	done, err := persist(ctx, key)
+	if err != nil {
+		return err
+	}
+	if done {
+		return writeLine(wf, ":1")
+	}
+	return writeLine(wf, ":0")
+}
+
+// Returns the Redis key of a record, nil for the internal records: fields of
+// expanded maps and consumer groups of streams.
+func redisKey(rec *as.Record) []byte {
+	if rec.Key == nil || rec.Key.Value() == nil {
+		return nil
+	}
+	k, ok := rec.Key.Value().GetObject().(string)
+	if !ok || strings.Contains(k, streamGroupSuffix) {
+		return nil
+	}
+	if strings.HasPrefix(k, "composite_") {
+		if !strings.HasSuffix(k, "_"+mainSuffix) {
+			return nil
+		}
+		return []byte(strings.TrimSuffix(strings.TrimPrefix(k, "composite_"), "_"+mainSuffix))
+	}
+	return []byte(k)
+}
+
+// Keys are only known for the records written with the send_key option. The
+// key is chosen among the first keys returned by a scan.
+func cmdRANDOMKEY(wf io.Writer, ctx *context, args [][]byte) error {
+	policy := as.NewScanPolicy()
+	policy.IncludeBinData = false
+	recordset, err := ctx.client.ScanAll(policy, ctx.ns, ctx.set)
+	if err != nil {
+		return err
+	}
+	defer recordset.Close()
+	var res []byte
+	count := 0
+	for r := range recordset.Results() {
+		if r.Err != nil {
+			return r.Err
+		}
+		k := redisKey(r.Record)
+		if k == nil {
+			continue
+		}
+		count++
+		if rand.Intn(count) == 0 {
+			res = k
+		}
+		if count >= randomKeySampleSize {
+			break
+		}
+	}
+	if res == nil {
+		return writeLine(wf, "$-1")
+	}
+	return writeByteArray(wf, res)
+}
+
+func expandedMapMainRecord(ctx *context, k []byte) (*as.Key, *as.Record, error) {
+	key, err := formatCompositeKey(ctx, string(k), mainSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, rec, nil
+}
+
+func expandedMapKeyExists(ctx *context, k []byte) (bool, error) {
+	_, rec, err := expandedMapMainRecord(ctx, k)
+	if err != nil || rec != nil {
+		return rec != nil, err
+	}
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return false, err
+	}
+	return ctx.client.Exists(createMasterReadPolicy(), key)
+}
+
+func expandedMapInvalidate(ctx *context, keys ...[]byte) {
+	if ctx.expandedMapCache != nil {
+		for _, k := range keys {
+			ctx.expandedMapCache.Del(k)
+		}
+	}
+}
+
+// The fields of an expanded map are stored with its suffixed key, so
+// renaming a map only moves its main record.
+func tryExpandedMapRename(ctx *context, k []byte, newK []byte, nx bool) error {
+	srcMain, rec, err := expandedMapMainRecord(ctx, k)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return errNoSuchKey
+	}
+	dstMain, err := formatCompositeKey(ctx, string(newK), mainSuffix)
+	if err != nil {
+		return err
+	}
+	if !nx {
+		dst, err := buildKey(ctx, newK)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.client.Delete(ctx.writePolicy, dst)
+		if err != nil {
+			return err
+		}
+	}
+	err = copyRecord(ctx, rec, dstMain, !nx)
+	if err != nil {
+		return err
+	}
+	expandedMapInvalidate(ctx, k, newK)
+	_, err = ctx.client.Delete(createWritePolicyGeneration(rec.Generation, -1), srcMain)
+	return err
+}
+
+func expandedMapRename(wf io.Writer, ctx *context, k []byte, newK []byte, nx bool) error {
+	srcMain, err := formatCompositeKey(ctx, string(k), mainSuffix)
+	if err != nil {
+		return err
+	}
+	isMap, err := ctx.client.Exists(createMasterReadPolicy(), srcMain)
+	if err != nil {
+		return err
+	}
+	if !isMap || string(k) == string(newK) {
+		if isMap {
+			return writeRenameReply(wf, nx, false)
+		}
+		if nx {
+			exists, err := expandedMapKeyExists(ctx, newK)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return writeLine(wf, ":0")
+			}
+		} else {
+			// The destination map is replaced by the renamed key
+			dstMain, err := formatCompositeKey(ctx, string(newK), mainSuffix)
+			if err != nil {
+				return err
+			}
+			_, err = ctx.client.Delete(ctx.writePolicy, dstMain)
+			if err != nil {
+				return err
+			}
+			expandedMapInvalidate(ctx, newK)
+		}
+		return rename(wf, ctx, k, newK, nx)
+	}
+	if nx {
+		exists, err := expandedMapKeyExists(ctx, newK)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return writeLine(wf, ":0")
+		}
+	}
+	replace := !nx
+	for i := 0; i < ctx.generationRetries; i++ {
+		err = tryExpandedMapRename(ctx, k, newK, !replace)
+		if err == errNoSuchKey {
+			return writeErrorReply(wf, "no such key")
+		}
+		if err == nil {
+			return writeRenameReply(wf, nx, true)
+		}
+		code := errResultCode(err)
+		if code == ase.KEY_EXISTS_ERROR && !replace {
+			return writeRenameReply(wf, nx, false)
+		}
+		if code != ase.GENERATION_ERROR {
+			return err
+		}
+		replace = true
+	}
+	return errors.New("Too many retry for rename")
+}
+
+func cmdExpandedMapRENAME(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapRename(wf, ctx, args[0], args[1], false)
+}
+
+func cmdExpandedMapRENAMENX(wf io.Writer, ctx *context, args [][]byte) error {
+	return expandedMapRename(wf, ctx, args[0], args[1], true)
+}
+
+// Copying an expanded map copies all its fields, to a new suffixed key.
+func cmdExpandedMapCOPY(wf io.Writer, ctx *context, args [][]byte) error {
+	replace, err := parseCopyArgs(args[2:])
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	_, rec, err := expandedMapMainRecord(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return cmdCOPY(wf, ctx, args)
+	}
+	if string(args[0]) == string(args[1]) {
+		return writeErrorReply(wf, "source and destination objects are the same")
+	}
+	exists, err := expandedMapKeyExists(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	if exists {
+		if !replace {
+			return writeLine(wf, ":0")
+		}
+		err = cmdExpandedMapDEL(ioutil.Discard, ctx, [][]byte{args[1]})
+		if err != nil {
+			return err
+		}
+	}
+	fields, err := expandedMapFields(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	suffixedKey, _, err := compositeExistsOrCreate(ctx, string(args[1]), int(rec.Expiration))
+	if err != nil {
+		return err
+	}
+	for _, e := range fields {
+		field := e.Bins[secondKeyBinName].(string)
+		key, err := formatCompositeKey(ctx, *suffixedKey, field)
+		if err != nil {
+			return err
+		}
+		err = ctx.client.PutBins(createWritePolicyEx(ctx.expandedMapDefaultTTL, false), key, expandedMapFieldBins(*suffixedKey, field, e.Bins[valueBinName])...)
+		if err != nil {
+			return err
+		}
+	}
+	return writeLine(wf, ":1")
+}
+
+func cmdExpandedMapTYPE(wf io.Writer, ctx *context, args [][]byte) error {
+	_, rec, err := expandedMapMainRecord(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if rec != nil {
+		return writeLine(wf, "+hash")
+	}
+	return cmdTYPE(wf, ctx, args)
+}
+
+func cmdExpandedMapPERSIST(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := formatCompositeKey(ctx, string(args[0]), mainSuffix)
+	if err != nil {
+		return err
+	}
+	exists, err := ctx.client.Exists(createMasterReadPolicy(), key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return cmdPERSIST(wf, ctx, args)
+	}
+	done, err := persist(ctx, key)
+	if err != nil {
+		return err
+	}
+	if done {
+		return writeLine(wf, ":1")
+	}
+	return writeLine(wf, ":0")
+}
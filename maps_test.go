@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashes(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"HGET myKey a", "nil"},
+			{"HSET myKey a 2", ":1"},
+			{"HGET myKey z", "nil"},
+			{"HSET myKey z 3", ":1"},
+			{"HGET myKey a", `"2"`},
+			{"HSET myKey a 1", ":0"},
+			{"HSET myKey b a", ":1"},
+			{"HGET myKey a", `"1"`},
+			{"HGET myKey b", `"a"`},
+			{"HDEL myKey a", ":1"},
+			{"HDEL myKey a", ":0"},
+			{"HGET myKey a", "nil"},
+			{"DEL myKey", ":1"},
+			{"DEL myKey", ":0"},
+			{"HGET myKey b", "nil"},
+			{"HSET myKey veryveryveryveryveryverylongke toto", ":1"},
+			{"HGET myKey veryveryveryveryveryverylongke", `"toto"`},
+			{"HGETALL myKey", `["veryveryveryveryveryverylongke" "toto"]`},
+			{"DEL myKey", ":1"},
+			{"HSET myKey a 1 b 2", ":2"},
+			{"HSET myKey a 3 c 4 c 5", ":1"},
+			{"HGETALL myKey", `["a" "3" "b" "2" "c" "5"]`},
+			{"HDEL myKey a b d", ":2"},
+			{"HGETALL myKey", `["c" "5"]`},
+		})
+		value := "toto\x00\r\n\x01"
+		if res := c.do("HSET", "myKey", "b", value); res != ":1" {
+			t.Errorf("HSET binary: got %s", res)
+		}
+		if res := c.do("HGET", "myKey", "b"); res != strconv.Quote(value) {
+			t.Errorf("HGET binary: got %s", res)
+		}
+	})
+}
+
+// Commands on a string key, the connection stays open.
+func TestHashesWrongType(t *testing.T) {
+	s := startTestServer(t, "cdt_map", nil)
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"SET myKey a", "+OK"},
+		{"HGETALL myKey", wrongType},
+		{"GET myKey", `"a"`},
+	})
+}
+
+func TestHashesMultiple(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"HMGET myKey a b c", "[nil nil nil]"},
+			{"HSET myKey b 2", ":1"},
+			{"HMGET myKey a b c", `[nil "2" nil]`},
+			{"HMSET myKey a 1 b 2 c a", "+OK"},
+			{"HMGET myKey a b c", `["1" "2" "a"]`},
+			{"HDEL myKey a", ":1"},
+			{"HMGET myKey a b c", `[nil "2" "a"]`},
+			{"DEL myKey", ":1"},
+			{"HGETALL myKey", "[]"},
+			{"HMSET myKey b 3 a 1 1 4 toto 2", "+OK"},
+			{"HGETALL myKey", `["1" "4" "a" "1" "b" "3" "toto" "2"]`},
+			{"HDEL myKey a", ":1"},
+			{"HDEL myKey 1", ":1"},
+			{"HGETALL myKey", `["b" "3" "toto" "2"]`},
+			{"HEXISTS myKey a", ":0"},
+			{"HEXISTS myKey b", ":1"},
+			{"HLEN myKey", ":2"},
+			{"HKEYS myKey", `["b" "toto"]`},
+			{"HVALS myKey", `["2" "3"]`},
+			{"HSTRLEN myKey toto", ":1"},
+			{"HSTRLEN myKey unknown", ":0"},
+			{"DEL myKey", ":1"},
+			{"HEXISTS myKey a", ":0"},
+			{"HLEN myKey", ":0"},
+			{"HKEYS myKey", "[]"},
+			{"HVALS myKey", "[]"},
+			{"HSETNX myKey a toto", ":1"},
+			{"HSETNX myKey a titi", ":0"},
+			{"HGET myKey a", `"toto"`},
+			{"HSTRLEN myKey a", ":4"},
+		})
+	})
+}
+
+func TestHashesIncr(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"HINCRBY myKey a 1", ":1"},
+			{"HINCRBY myKey a 10", ":11"},
+			{"HINCRBY myKey a -15", ":-4"},
+			{"HINCRBY myKey a 0", ":-4"},
+			{"HGET myKey a", `"-4"`},
+			{"HINCRBY myKey b 2", ":2"},
+			{"HGETALL myKey", `["a" "-4" "b" "2"]`},
+			{"HMGET myKey c a b", `[nil "-4" "2"]`},
+			{"DEL myKey", ":1"},
+			{"HINCRBY myKey a 0", ":0"},
+			{"HSET myKey b toto", ":1"},
+			{"HINCRBY myKey b 1", "nil"},
+			{"HGET myKey b", `"toto"`},
+			{"DEL myKey", ":1"},
+			{"HINCRBYFLOAT myKey a 1.5", `"1.5"`},
+			{"HINCRBYFLOAT myKey a 1.5", `"3"`},
+			{"HGET myKey a", `"3"`},
+			{"HINCRBY myKey a 2", ":5"},
+			{"HINCRBYFLOAT myKey a -0.25", `"4.75"`},
+			{"HGET myKey a", `"4.75"`},
+			{"HINCRBYFLOAT myKey c 0.1", `"0.1"`},
+			{"HINCRBYFLOAT myKey c 0.2", `"0.3"`},
+			{"HSET myKey b toto", ":1"},
+			{"HINCRBYFLOAT myKey b 1", "nil"},
+		})
+	})
+}
+
+func TestHashesEx(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"HINCRBYEX myKey a 2 500", ":2"},
+			{"HINCRBYEX myKey a 1 500", ":3"},
+			{"TTL myKey", ":500"},
+			{"HSETEX myKey2 500 a 2", ":1"},
+			{"HSETEX myKey2 500 a 4", ":0"},
+			{"HGET myKey2 a", `"4"`},
+			{"TTL myKey2", ":500"},
+			{"HMINCRBYEX myKey3 10 key 1 key2 5", "+OK"},
+			{"HGETALL myKey3", `["key" "1" "key2" "5"]`},
+			{"HMINCRBYEX myKey3 200 key2 6", "+OK"},
+			{"TTL myKey3", ":200"},
+			{"HMINCRBYEX myKey3 2 key3 12", "+OK"},
+			{"HGETALL myKey3", `["key" "1" "key2" "11" "key3" "12"]`},
+			{"HMINCRBYEX myKey4 -1 key 12", "+OK"},
+			{"HGETALL myKey4", `["key" "12"]`},
+			{"HMINCRBYEX myKey4 100", "+OK"},
+			{"TTL myKey4", ":100"},
+			{"HMINCRBYEX unknown 100", "+OK"},
+		})
+		s.sleep(5 * time.Second)
+		run(t, c, [][2]string{
+			{"HGETALL myKey3", "[]"},
+			{"HGET myKey a", `"3"`},
+			{"HGETALL myKey4", `["key" "12"]`},
+		})
+	})
+}
+
+func TestHashesTimeout(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"EXPIRE myKey 2", ":0"},
+			{"HSET myKey b 2", ":1"},
+			{"EXPIRE myKey 200", ":1"},
+			{"TTL myKey", ":200"},
+			{"HSET myKey b 3", ":0"},
+			{"HSET myKey a 3", ":1"},
+			{"TTL myKey", ":200"},
+			{"PTTL myKey", ":200000"},
+			{"PERSIST myKey", ":1"},
+			{"TTL myKey", ":-1"},
+			{"PEXPIRE myKey 2000", ":1"},
+			{"HGET myKey a", `"3"`},
+		})
+		s.sleep(3 * time.Second)
+		run(t, c, [][2]string{
+			{"HGET myKey a", "nil"},
+			{"HLEN myKey", ":0"},
+			{"TTL myKey", ":-2"},
+		})
+		at := time.Now().Unix() + 100
+		run(t, c, [][2]string{
+			{"HSET myKey a 1", ":1"},
+			{"EXPIREAT myKey " + strconv.FormatInt(at, 10), ":1"},
+		})
+		between(t, c, "EXPIRETIME myKey", at-1, at+1)
+		run(t, c, [][2]string{
+			{"PEXPIREAT myKey " + strconv.FormatInt(at*1000, 10), ":1"},
+		})
+		between(t, c, "PEXPIRETIME myKey", at*1000-1000, at*1000+1000)
+	})
+}
+
+func TestHashesKeys(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"HSET myKey a 1 b 2", ":2"},
+			{"TYPE myKey", "+hash"},
+			{"RENAME myKey myKey2", "+OK"},
+			{"HGETALL myKey", "[]"},
+			{"HGETALL myKey2", `["a" "1" "b" "2"]`},
+			{"RENAMENX myKey2 myKey", ":1"},
+			{"COPY myKey myKey2", ":1"},
+			{"COPY myKey myKey2", ":0"},
+			{"HSET myKey a 3", ":0"},
+			{"COPY myKey myKey2 REPLACE", ":1"},
+			{"HGET myKey2 a", `"3"`},
+			{"DEL myKey", ":1"},
+			{"HGETALL myKey", "[]"},
+			{"TYPE myKey", "+none"},
+		})
+	})
+}
+
+// A list is stored in the bins of the record, like a hash in the standard
+// mode: the error is replied before the array.
+func TestHashesListWrongType(t *testing.T) {
+	s := startTestServer(t, "standard", nil)
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"RPUSH myKey a", ":1"},
+		{"HGETALL myKey", wrongType},
+		{"HVALS myKey", wrongType},
+		{"HSCAN myKey 0", wrongType},
+		{"LRANGE myKey 0 -1", `["a"]`},
+	})
+}
+
+func TestHashesScan(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{{"HSCAN myKey 0", `["0" []]`}})
+		for i := 0; i < 25; i++ {
+			run(t, c, [][2]string{{fmt.Sprintf("HSET myKey field%d %d", i, i), ":1"}})
+		}
+		found := make(map[string]bool)
+		cursor := "0"
+		for {
+			c.send("HSCAN", "myKey", cursor, "COUNT", "7")
+			res := c.read()
+			// ["cursor" ["field" "value" ...]]
+			parts := strings.SplitN(strings.Trim(res, "[]"), " [", 2)
+			cursor, _ = strconv.Unquote(parts[0])
+			elements := strings.Fields(parts[1])
+			for i := 0; i+1 < len(elements); i += 2 {
+				found[elements[i]+"="+elements[i+1]] = true
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+		for i := 0; i < 25; i++ {
+			if !found[fmt.Sprintf(`"field%d"="%d"`, i, i)] {
+				t.Errorf("HSCAN: field%d not found", i)
+			}
+		}
+		if len(found) != 25 {
+			t.Errorf("HSCAN: got %d fields", len(found))
+		}
+		res := c.do("HSCAN", "myKey", "0", "MATCH", "field1?", "COUNT", "100")
+		if !strings.HasPrefix(res, `["0" [`) || strings.Count(res, `"field1`) != 10 {
+			t.Errorf("HSCAN MATCH: got %s", res)
+		}
+	})
+}
+
+// Fields deleted during a scan do not make it skip the other fields.
+func TestHashesScanDelete(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		for i := 0; i < 25; i++ {
+			run(t, c, [][2]string{{fmt.Sprintf("HSET myKey field%d %d", i, i), ":1"}})
+		}
+		found := make(map[string]bool)
+		cursor := "0"
+		for {
+			c.send("HSCAN", "myKey", cursor, "COUNT", "7")
+			res := c.read()
+			parts := strings.SplitN(strings.Trim(res, "[]"), " [", 2)
+			cursor, _ = strconv.Unquote(parts[0])
+			elements := strings.Fields(parts[1])
+			for i := 0; i+1 < len(elements); i += 2 {
+				field, _ := strconv.Unquote(elements[i])
+				found[field] = true
+				run(t, c, [][2]string{{"HDEL myKey " + field, ":1"}})
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+		if len(found) != 25 {
+			t.Errorf("HSCAN: got %d fields", len(found))
+		}
+		run(t, c, [][2]string{{"HLEN myKey", ":0"}})
+	})
+}
+
+func TestHashesIncrByFloatWrongType(t *testing.T) {
+	s := startTestServer(t, "standard", nil)
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"RPUSH myKey a", ":1"},
+		{"HINCRBYFLOAT myKey r 1", wrongType},
+		{"LRANGE myKey 0 -1", `["a"]`},
+	})
+}
+
+func TestExpandedMapCache(t *testing.T) {
+	s := startTestServer(t, "expanded_map", map[string]interface{}{"cache_size": 1048576.0})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"HGET myKey a", "nil"},
+		{"HSET myKey a 1", ":1"},
+		{"HGET myKey a", `"1"`},
+		{"HMSET myKey b 2 c 3", "+OK"},
+		{"HGETALL myKey", `["a" "1" "b" "2" "c" "3"]`},
+		{"DEL myKey", ":1"},
+		{"HGET myKey a", "nil"},
+		{"HSET myKey a 2", ":1"},
+		{"HGET myKey a", `"2"`},
+	})
+	if s.ctx.expandedMapCache.EntryCount() == 0 {
+		t.Error("The cache must be used")
+	}
+}
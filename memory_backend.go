@@ -0,0 +1,1018 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Backend keeping the records in memory, with the ttl and generation
+// semantics of Aerospike. Expired records are removed when they are read.
+// HyperLogLogs are exact sets of hashes, so their counts are exact.
+type memoryBackend struct {
+	mutex   sync.Mutex
+	records map[string]*memoryRecord
+	// Ttl of the records created without ttl, 0 when they do not expire,
+	// like the namespace default-ttl.
+	defaultTTL uint32
+	now        func() time.Time
+}
+
+type memoryRecord struct {
+	key        *as.Key
+	bins       map[string]interface{}
+	generation uint32
+	// Unix time in seconds, 0 when the record does not expire
+	expiration int64
+}
+
+type memoryRecordset struct {
+	results chan *as.Result
+}
+
+func (r *memoryRecordset) Results() <-chan *as.Result {
+	return r.results
+}
+
+func (r *memoryRecordset) Close() error {
+	return nil
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{records: make(map[string]*memoryRecord), now: time.Now}
+}
+
+func memoryError(code ase.ResultCode) error {
+	return ase.NewAerospikeError(code)
+}
+
+func memoryRecordID(key *as.Key) string {
+	return key.Namespace() + "/" + string(key.Digest())
+}
+
+// Returns the record, nil if it does not exist or is expired.
+func (b *memoryBackend) lookup(key *as.Key) *memoryRecord {
+	id := memoryRecordID(key)
+	r := b.records[id]
+	if r == nil {
+		return nil
+	}
+	if r.expiration != 0 && r.expiration <= b.now().Unix() {
+		delete(b.records, id)
+		return nil
+	}
+	return r
+}
+
+// Ttl in seconds, as returned by the client.
+func (b *memoryBackend) ttl(r *memoryRecord) uint32 {
+	if r.expiration == 0 {
+		return as.TTLDontExpire
+	}
+	return uint32(r.expiration - b.now().Unix())
+}
+
+func (b *memoryBackend) toRecord(key *as.Key, r *memoryRecord, bins as.BinMap) *as.Record {
+	return &as.Record{Key: key, Bins: bins, Generation: r.generation, Expiration: b.ttl(r)}
+}
+
+func (b *memoryBackend) expiration(policy *as.WritePolicy, r *memoryRecord) int64 {
+	ttl := policy.Expiration
+	switch ttl {
+	case as.TTLDontExpire:
+		return 0
+	case as.TTLDontUpdate:
+		if r != nil {
+			return r.expiration
+		}
+		ttl = b.defaultTTL
+	case as.TTLServerDefault:
+		ttl = b.defaultTTL
+	}
+	if ttl == 0 {
+		return 0
+	}
+	return b.now().Unix() + int64(ttl)
+}
+
+func checkGeneration(policy *as.WritePolicy, r *memoryRecord) error {
+	switch policy.GenerationPolicy {
+	case as.EXPECT_GEN_EQUAL:
+		if r == nil || r.generation != policy.Generation {
+			return memoryError(ase.GENERATION_ERROR)
+		}
+	case as.EXPECT_GEN_GT:
+		if r == nil || r.generation >= policy.Generation {
+			return memoryError(ase.GENERATION_ERROR)
+		}
+	}
+	return nil
+}
+
+// Checks the policy of a write, and returns the bins the write starts from.
+func checkWrite(policy *as.WritePolicy, r *memoryRecord) (map[string]interface{}, error) {
+	switch policy.RecordExistsAction {
+	case as.UPDATE_ONLY, as.REPLACE_ONLY:
+		if r == nil {
+			return nil, memoryError(ase.KEY_NOT_FOUND_ERROR)
+		}
+	case as.CREATE_ONLY:
+		if r != nil {
+			return nil, memoryError(ase.KEY_EXISTS_ERROR)
+		}
+	}
+	err := checkGeneration(policy, r)
+	if err != nil {
+		return nil, err
+	}
+	bins := make(map[string]interface{})
+	if r != nil && policy.RecordExistsAction != as.REPLACE && policy.RecordExistsAction != as.REPLACE_ONLY {
+		for name, value := range r.bins {
+			bins[name] = memoryValue(value)
+		}
+	}
+	return bins, nil
+}
+
+// Stores the bins of a write. A record without bins is deleted.
+func (b *memoryBackend) store(policy *as.WritePolicy, key *as.Key, r *memoryRecord, bins map[string]interface{}) *memoryRecord {
+	id := memoryRecordID(key)
+	if len(bins) == 0 {
+		delete(b.records, id)
+		return nil
+	}
+	stored := &memoryRecord{key: key, bins: bins, generation: 1, expiration: b.expiration(policy, r)}
+	if r != nil {
+		stored.generation = r.generation + 1
+		if memoryValue(r.key.Value()) != nil {
+			stored.key = r.key
+		}
+	}
+	if !policy.SendKey && stored.key == key {
+		stored.key, _ = as.NewKeyWithDigest(key.Namespace(), key.SetName(), nil, key.Digest())
+	}
+	b.records[id] = stored
+	return stored
+}
+
+func (b *memoryBackend) Get(policy *as.BasePolicy, key *as.Key, binNames ...string) (*as.Record, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.lookup(key)
+	if r == nil {
+		return nil, nil
+	}
+	bins := make(as.BinMap)
+	if len(binNames) == 0 {
+		for name, value := range r.bins {
+			bins[name] = memoryValue(value)
+		}
+	}
+	for _, name := range binNames {
+		value, ok := r.bins[name]
+		if ok {
+			bins[name] = memoryValue(value)
+		}
+	}
+	return b.toRecord(key, r, bins), nil
+}
+
+func (b *memoryBackend) GetHeader(policy *as.BasePolicy, key *as.Key) (*as.Record, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.lookup(key)
+	if r == nil {
+		return nil, nil
+	}
+	return b.toRecord(key, r, nil), nil
+}
+
+func (b *memoryBackend) Exists(policy *as.BasePolicy, key *as.Key) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.lookup(key) != nil, nil
+}
+
+func (b *memoryBackend) PutBins(policy *as.WritePolicy, key *as.Key, bins ...*as.Bin) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.lookup(key)
+	values, err := checkWrite(policy, r)
+	if err != nil {
+		return err
+	}
+	for _, bin := range bins {
+		value := memoryValue(bin.Value)
+		if value == nil {
+			delete(values, bin.Name)
+		} else {
+			values[bin.Name] = value
+		}
+	}
+	b.store(policy, key, r, values)
+	return nil
+}
+
+// Operations are applied on a copy of the bins, so a failed operate does not
+// change the record.
+func (b *memoryBackend) Operate(policy *as.WritePolicy, key *as.Key, ops ...*operation) (*as.Record, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.lookup(key)
+	write := false
+	respondAll := false
+	for _, op := range ops {
+		write = write || op.isWrite()
+		respondAll = respondAll || op.respondAll()
+	}
+	var bins map[string]interface{}
+	if write {
+		var err error
+		bins, err = checkWrite(policy, r)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if r == nil {
+			return nil, nil
+		}
+		bins = r.bins
+	}
+	res := make(as.BinMap)
+	for _, op := range ops {
+		value, respond, err := applyOperation(bins, op)
+		if err != nil {
+			return nil, err
+		}
+		if op.bin != "" && (respond || respondAll) {
+			addResult(res, op.bin, value)
+		}
+	}
+	if !write {
+		return b.toRecord(key, r, res), nil
+	}
+	stored := b.store(policy, key, r, bins)
+	if stored == nil {
+		return &as.Record{Key: key, Bins: res}, nil
+	}
+	return b.toRecord(key, stored, res), nil
+}
+
+func (b *memoryBackend) Delete(policy *as.WritePolicy, key *as.Key) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.lookup(key)
+	if r == nil {
+		return false, nil
+	}
+	err := checkGeneration(policy, r)
+	if err != nil {
+		return false, err
+	}
+	delete(b.records, memoryRecordID(key))
+	return true, nil
+}
+
+func (b *memoryBackend) Touch(policy *as.WritePolicy, key *as.Key) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	r := b.lookup(key)
+	if r == nil {
+		return memoryError(ase.KEY_NOT_FOUND_ERROR)
+	}
+	err := checkGeneration(policy, r)
+	if err != nil {
+		return err
+	}
+	r.generation++
+	r.expiration = b.expiration(policy, r)
+	return nil
+}
+
+// Returns a snapshot of the records of a set, all the records of the
+// namespace if the set is empty.
+func (b *memoryBackend) snapshot(ns string, set string, includeBins bool, match func(*memoryRecord) bool) recordset {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	res := make([]*as.Result, 0)
+	for id := range b.records {
+		r := b.records[id]
+		if r.key.Namespace() != ns || (set != "" && r.key.SetName() != set) {
+			continue
+		}
+		r = b.lookup(r.key)
+		if r == nil || !match(r) {
+			continue
+		}
+		var bins as.BinMap
+		if includeBins {
+			bins = make(as.BinMap)
+			for name, value := range r.bins {
+				bins[name] = memoryValue(value)
+			}
+		}
+		res = append(res, &as.Result{Record: b.toRecord(r.key, r, bins)})
+	}
+	results := make(chan *as.Result, len(res))
+	for _, x := range res {
+		results <- x
+	}
+	close(results)
+	return &memoryRecordset{results}
+}
+
+func (b *memoryBackend) ScanAll(policy *as.ScanPolicy, ns string, set string) (recordset, error) {
+	return b.snapshot(ns, set, policy.IncludeBinData, func(r *memoryRecord) bool {
+		return true
+	}), nil
+}
+
+func (b *memoryBackend) Query(policy *as.QueryPolicy, ns string, set string, binName string, value string) (recordset, error) {
+	return b.snapshot(ns, set, true, func(r *memoryRecord) bool {
+		v, ok := r.bins[binName].(string)
+		return ok && v == value
+	}), nil
+}
+
+func (b *memoryBackend) IsConnected() bool {
+	return true
+}
+
+// Results of several operations on the same bin are merged like the client
+// does: in a list, which is the result of the first operation if it is a
+// list.
+func addResult(bins as.BinMap, name string, value interface{}) {
+	prev, ok := bins[name]
+	if !ok {
+		bins[name] = value
+		return
+	}
+	list, ok := prev.([]interface{})
+	if ok {
+		bins[name] = append(list, value)
+		return
+	}
+	bins[name] = []interface{}{prev, value}
+}
+
+// Copies a value, with the types returned by the client: integers are int,
+// lists and maps have interface{} elements.
+func memoryValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case as.HLLValue:
+		return as.HLLValue(append([]byte{}, x...))
+	case as.Value:
+		return memoryValue(x.GetObject())
+	case []byte:
+		return append([]byte{}, x...)
+	case string, bool, int, float64:
+		return x
+	case float32:
+		return float64(x)
+	case []interface{}:
+		res := make([]interface{}, len(x))
+		for i, e := range x {
+			res[i] = memoryValue(e)
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[interface{}]interface{}, len(x))
+		for k, e := range x {
+			res[memoryValue(k)] = memoryValue(e)
+		}
+		return res
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint())
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			res[i] = memoryValue(rv.Index(i).Interface())
+		}
+		return res
+	case reflect.Map:
+		res := make(map[interface{}]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			res[memoryValue(k.Interface())] = memoryValue(rv.MapIndex(k).Interface())
+		}
+		return res
+	}
+	return v
+}
+
+// Order of the values in the maps of Aerospike: by type, then by value.
+func memoryTypeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	case map[interface{}]interface{}:
+		return 5
+	case []byte:
+		return 6
+	case float64:
+		return 7
+	}
+	return 8
+}
+
+func memoryCompare(a interface{}, b interface{}) int {
+	ra, rb := memoryTypeRank(a), memoryTypeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case int:
+		y := b.(int)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	case float64:
+		y := b.(float64)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	case string:
+		return strings.Compare(x, b.(string))
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			c := memoryCompare(x[i], y[i])
+			if c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	}
+	return 0
+}
+
+// Returns the slice bounds of a range of count elements starting at index, in
+// a list or a map of the given size. A negative index starts from the end.
+// The count is unsigned for the server: a negative count selects all the
+// elements after index.
+func memoryRange(size int, index int, count int) (int, int) {
+	if index < 0 {
+		index += size
+	}
+	end := size
+	if count >= 0 {
+		end = index + count
+	}
+	if index < 0 {
+		index = 0
+	}
+	if index > size {
+		index = size
+	}
+	if end > size {
+		end = size
+	}
+	if end < index {
+		end = index
+	}
+	return index, end
+}
+
+func memoryClamp(index int, size int) int {
+	if index < 0 {
+		return 0
+	}
+	if index > size {
+		return size
+	}
+	return index
+}
+
+// Returns the position of an element of a list, an error if it is out of
+// the list.
+func memoryListIndex(size int, index int) (int, error) {
+	if index < 0 {
+		index += size
+	}
+	if index < 0 || index >= size {
+		return 0, memoryError(ase.PARAMETER_ERROR)
+	}
+	return index, nil
+}
+
+func applyOperation(bins map[string]interface{}, op *operation) (interface{}, bool, error) {
+	switch op.kind {
+	case opGet:
+		value, ok := bins[op.bin]
+		return memoryValue(value), ok, nil
+	case opPut:
+		value := memoryValue(op.args[0])
+		if value == nil {
+			delete(bins, op.bin)
+		} else {
+			bins[op.bin] = value
+		}
+		return nil, false, nil
+	case opAdd, opAppend:
+		return nil, false, applyBinOperation(bins, op)
+	case opTouch:
+		return nil, false, nil
+	}
+	if op.kind < opMapPut {
+		return applyListOperation(bins, op)
+	}
+	if op.kind < opBitGrow {
+		return applyMapOperation(bins, op)
+	}
+	if op.kind < opHLLInit {
+		return applyBitOperation(bins, op)
+	}
+	return applyHLLOperation(bins, op)
+}
+
+func applyBinOperation(bins map[string]interface{}, op *operation) error {
+	value := memoryValue(op.args[0])
+	current, ok := bins[op.bin]
+	if !ok {
+		bins[op.bin] = value
+		return nil
+	}
+	switch x := current.(type) {
+	case int:
+		y, ok := value.(int)
+		if op.kind == opAdd && ok {
+			bins[op.bin] = x + y
+			return nil
+		}
+	case float64:
+		y, ok := value.(float64)
+		if op.kind == opAdd && ok {
+			bins[op.bin] = x + y
+			return nil
+		}
+	case string:
+		y, ok := value.(string)
+		if op.kind == opAppend && ok {
+			bins[op.bin] = x + y
+			return nil
+		}
+	case []byte:
+		y, ok := value.([]byte)
+		if op.kind == opAppend && ok {
+			bins[op.bin] = append(x, y...)
+			return nil
+		}
+	}
+	return memoryError(ase.BIN_TYPE_ERROR)
+}
+
+func applyListOperation(bins map[string]interface{}, op *operation) (interface{}, bool, error) {
+	current, exists := bins[op.bin]
+	list, ok := current.([]interface{})
+	if exists && !ok {
+		return nil, false, memoryError(ase.BIN_TYPE_ERROR)
+	}
+	switch op.kind {
+	case opListAppend:
+		list = append(list, memoryValue(op.args[0]).([]interface{})...)
+		bins[op.bin] = list
+		return len(list), true, nil
+	case opListInsert:
+		index := op.args[0].(int)
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 {
+			return nil, false, memoryError(ase.PARAMETER_ERROR)
+		}
+		for len(list) < index {
+			list = append(list, nil)
+		}
+		values := memoryValue(op.args[1]).([]interface{})
+		list = append(list[:index], append(values, list[index:]...)...)
+		bins[op.bin] = list
+		return len(list), true, nil
+	case opListSet:
+		index := op.args[0].(int)
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 {
+			return nil, false, memoryError(ase.PARAMETER_ERROR)
+		}
+		for len(list) <= index {
+			list = append(list, nil)
+		}
+		list[index] = memoryValue(op.args[1])
+		bins[op.bin] = list
+		return nil, false, nil
+	case opListClear:
+		if exists {
+			bins[op.bin] = []interface{}{}
+		}
+		return nil, false, nil
+	}
+	if !exists {
+		return nil, true, nil
+	}
+	switch op.kind {
+	case opListPop, opListRemove, opListGet:
+		index, err := memoryListIndex(len(list), op.args[0].(int))
+		if err != nil {
+			return nil, false, err
+		}
+		value := list[index]
+		if op.kind == opListGet {
+			return memoryValue(value), true, nil
+		}
+		bins[op.bin] = append(list[:index], list[index+1:]...)
+		if op.kind == opListRemove {
+			return 1, true, nil
+		}
+		return value, true, nil
+	case opListSize:
+		return len(list), true, nil
+	case opListGetRange:
+		// Like the server, an index out of the list is an error
+		index := op.args[0].(int)
+		if index < -len(list) || index > len(list) {
+			return nil, false, memoryError(ase.PARAMETER_ERROR)
+		}
+		begin, end := memoryRange(len(list), index, op.args[1].(int))
+		return memoryValue(list[begin:end]), true, nil
+	}
+	return nil, false, memoryError(ase.PARAMETER_ERROR)
+}
+
+func memoryMapKeys(m map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return memoryCompare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// Keys between begin included and end excluded, a nil bound is unbounded.
+func memoryMapKeyRange(m map[interface{}]interface{}, begin interface{}, end interface{}) []interface{} {
+	res := make([]interface{}, 0)
+	for _, k := range memoryMapKeys(m) {
+		if (begin == nil || memoryCompare(k, begin) >= 0) && (end == nil || memoryCompare(k, end) < 0) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// Result of a map operation on the given keys. Operations on a single key
+// return the key or the value, and not a list.
+func memoryMapResult(m map[interface{}]interface{}, keys []interface{}, returnType mapReturn, single bool) interface{} {
+	switch returnType {
+	case returnCount:
+		return len(keys)
+	case returnKey, returnValue:
+		res := make([]interface{}, len(keys))
+		for i, k := range keys {
+			res[i] = k
+			if returnType == returnValue {
+				res[i] = m[k]
+			}
+		}
+		if single {
+			if len(res) == 0 {
+				return nil
+			}
+			return memoryValue(res[0])
+		}
+		return memoryValue(res)
+	}
+	return nil
+}
+
+func applyMapOperation(bins map[string]interface{}, op *operation) (interface{}, bool, error) {
+	current, exists := bins[op.bin]
+	m, ok := current.(map[interface{}]interface{})
+	if exists && !ok {
+		return nil, false, memoryError(ase.BIN_TYPE_ERROR)
+	}
+	if m == nil {
+		m = make(map[interface{}]interface{})
+	}
+	switch op.kind {
+	case opMapPut, opMapPutItems:
+		policy := op.args[0].(*mapPolicy)
+		items, ok := op.args[1].(map[interface{}]interface{})
+		if op.kind == opMapPut || !ok {
+			items = map[interface{}]interface{}{op.args[1]: op.args[2]}
+		}
+		for k, v := range items {
+			k = memoryValue(k)
+			_, found := m[k]
+			if found && policy.createOnly {
+				return nil, false, memoryError(ase.FAIL_ELEMENT_EXISTS)
+			}
+			m[k] = memoryValue(v)
+		}
+		bins[op.bin] = m
+		return len(m), true, nil
+	case opMapIncrement:
+		k := memoryValue(op.args[1])
+		incr := memoryValue(op.args[2])
+		value, found := m[k]
+		if !found {
+			m[k] = incr
+		} else {
+			switch x := value.(type) {
+			case int:
+				y, ok := incr.(int)
+				if !ok {
+					return nil, false, memoryError(ase.PARAMETER_ERROR)
+				}
+				m[k] = x + y
+			case float64:
+				y, ok := incr.(float64)
+				if !ok {
+					return nil, false, memoryError(ase.PARAMETER_ERROR)
+				}
+				m[k] = x + y
+			default:
+				return nil, false, memoryError(ase.PARAMETER_ERROR)
+			}
+		}
+		bins[op.bin] = m
+		return m[k], true, nil
+	}
+	if !exists {
+		if op.kind == opMapSize {
+			return nil, true, nil
+		}
+		returnType := op.args[0].(mapReturn)
+		if returnType == returnCount {
+			return 0, true, nil
+		}
+		single := op.kind == opMapGetByKey || op.kind == opMapRemoveByKey
+		if single || returnType == returnNone {
+			return nil, true, nil
+		}
+		return []interface{}{}, true, nil
+	}
+	if op.kind == opMapSize {
+		return len(m), true, nil
+	}
+	returnType := op.args[0].(mapReturn)
+	var keys []interface{}
+	single := false
+	switch op.kind {
+	case opMapGetByKey, opMapRemoveByKey:
+		single = true
+		k := memoryValue(op.args[1])
+		keys = []interface{}{}
+		_, found := m[k]
+		if found {
+			keys = append(keys, k)
+		}
+	case opMapGetByKeyList, opMapRemoveByKeyList:
+		keys = []interface{}{}
+		for _, k := range op.args[1].([]interface{}) {
+			k = memoryValue(k)
+			_, found := m[k]
+			if found {
+				keys = append(keys, k)
+			}
+		}
+	case opMapGetByKeyRange, opMapRemoveByKeyRange:
+		keys = memoryMapKeyRange(m, memoryValue(op.args[1]), memoryValue(op.args[2]))
+	case opMapGetByIndexRange, opMapGetByIndexRangeCount, opMapRemoveByIndexRangeCount:
+		all := memoryMapKeys(m)
+		count := len(all)
+		if op.kind != opMapGetByIndexRange {
+			count = op.args[2].(int)
+		}
+		begin, end := memoryRange(len(all), op.args[1].(int), count)
+		keys = all[begin:end]
+	case opMapGetByKeyRelativeIndexRangeCount:
+		all := memoryMapKeys(m)
+		k := memoryValue(op.args[1])
+		begin := sort.Search(len(all), func(i int) bool {
+			return memoryCompare(all[i], k) >= 0
+		}) + op.args[2].(int)
+		end := begin + op.args[3].(int)
+		begin, end = memoryClamp(begin, len(all)), memoryClamp(end, len(all))
+		if end < begin {
+			end = begin
+		}
+		keys = all[begin:end]
+	}
+	res := memoryMapResult(m, keys, returnType, single)
+	switch op.kind {
+	case opMapRemoveByKey, opMapRemoveByKeyList, opMapRemoveByKeyRange, opMapRemoveByIndexRangeCount:
+		for _, k := range keys {
+			delete(m, k)
+		}
+	}
+	return res, true, nil
+}
+
+func memoryGetBit(buf []byte, offset int) bool {
+	return buf[offset/8]&(0x80>>uint(offset%8)) != 0
+}
+
+func applyBitOperation(bins map[string]interface{}, op *operation) (interface{}, bool, error) {
+	current, exists := bins[op.bin]
+	buf, ok := current.([]byte)
+	if exists && !ok {
+		return nil, false, memoryError(ase.BIN_TYPE_ERROR)
+	}
+	switch op.kind {
+	case opBitGrow:
+		policy := op.args[0].(*bitPolicy)
+		size := op.args[1].(int)
+		if size < len(buf) {
+			if policy.flags&as.BitWriteFlagsNoFail != 0 {
+				return nil, false, nil
+			}
+			return nil, false, memoryError(ase.OP_NOT_APPLICABLE)
+		}
+		res := make([]byte, size)
+		copy(res, buf)
+		bins[op.bin] = res
+		return nil, false, nil
+	case opBitSet:
+		policy := op.args[0].(*bitPolicy)
+		offset := op.args[1].(int)
+		size := op.args[2].(int)
+		value := op.args[3].([]byte)
+		if !exists {
+			return nil, false, memoryError(ase.BIN_NOT_FOUND)
+		}
+		if offset < 0 || size < 0 || offset+size > len(buf)*8 || size > len(value)*8 {
+			if policy.flags&as.BitWriteFlagsNoFail != 0 {
+				return nil, false, nil
+			}
+			return nil, false, memoryError(ase.OP_NOT_APPLICABLE)
+		}
+		for i := 0; i < size; i++ {
+			mask := byte(0x80 >> uint((offset+i)%8))
+			if memoryGetBit(value, i) {
+				buf[(offset+i)/8] |= mask
+			} else {
+				buf[(offset+i)/8] &^= mask
+			}
+		}
+		return nil, false, nil
+	case opBitGet:
+		offset := op.args[0].(int)
+		size := op.args[1].(int)
+		if !exists {
+			return nil, true, nil
+		}
+		if offset < 0 || size < 0 || offset+size > len(buf)*8 {
+			return nil, false, memoryError(ase.OP_NOT_APPLICABLE)
+		}
+		res := make([]byte, (size+7)/8)
+		for i := 0; i < size; i++ {
+			if memoryGetBit(buf, offset+i) {
+				res[i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+		return res, true, nil
+	}
+	return nil, false, memoryError(ase.PARAMETER_ERROR)
+}
+
+// HyperLogLogs are stored as sorted lists of 8 bytes hashes.
+func memoryHLLHashes(hll []byte) map[uint64]bool {
+	res := make(map[uint64]bool)
+	for i := 0; i+8 <= len(hll); i += 8 {
+		res[binary.BigEndian.Uint64(hll[i:])] = true
+	}
+	return res
+}
+
+func memoryHLLValue(hashes map[uint64]bool) as.HLLValue {
+	sorted := make([]uint64, 0, len(hashes))
+	for h := range hashes {
+		sorted = append(sorted, h)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	res := make([]byte, 8*len(sorted))
+	for i, h := range sorted {
+		binary.BigEndian.PutUint64(res[8*i:], h)
+	}
+	return as.HLLValue(res)
+}
+
+func memoryHash(value interface{}) uint64 {
+	h := fnv.New64a()
+	switch x := value.(type) {
+	case []byte:
+		h.Write(x)
+	case string:
+		h.Write([]byte(x))
+	default:
+		h.Write([]byte(fmt.Sprint(x)))
+	}
+	return h.Sum64()
+}
+
+func applyHLLOperation(bins map[string]interface{}, op *operation) (interface{}, bool, error) {
+	current, exists := bins[op.bin]
+	hll, ok := current.(as.HLLValue)
+	if exists && !ok {
+		return nil, false, memoryError(ase.BIN_TYPE_ERROR)
+	}
+	hashes := memoryHLLHashes(hll)
+	switch op.kind {
+	case opHLLInit, opHLLAdd, opHLLSetUnion:
+		policy := op.args[0].(*hllPolicy)
+		fail := ase.OK
+		if exists && policy.flags&as.HLLWriteFlagsCreateOnly != 0 {
+			fail = ase.BIN_EXISTS_ERROR
+		}
+		if !exists && policy.flags&as.HLLWriteFlagsUpdateOnly != 0 {
+			fail = ase.BIN_NOT_FOUND
+		}
+		if fail != ase.OK {
+			if policy.flags&as.HLLWriteFlagsNoFail != 0 {
+				return nil, false, nil
+			}
+			return nil, false, memoryError(fail)
+		}
+		added := 0
+		switch op.kind {
+		case opHLLInit:
+			hashes = make(map[uint64]bool)
+		case opHLLAdd:
+			for _, v := range op.args[1].([]as.Value) {
+				h := memoryHash(v.GetObject())
+				if !hashes[h] {
+					hashes[h] = true
+					added++
+				}
+			}
+		case opHLLSetUnion:
+			for _, other := range op.args[1].([]as.HLLValue) {
+				for h := range memoryHLLHashes(other) {
+					hashes[h] = true
+				}
+			}
+		}
+		bins[op.bin] = memoryHLLValue(hashes)
+		if op.kind == opHLLAdd {
+			return added, true, nil
+		}
+		return nil, false, nil
+	case opHLLGetCount, opHLLGetUnionCount:
+		if !exists {
+			return nil, true, nil
+		}
+		if op.kind == opHLLGetUnionCount {
+			for _, other := range op.args[0].([]as.HLLValue) {
+				for h := range memoryHLLHashes(other) {
+					hashes[h] = true
+				}
+			}
+		}
+		return len(hashes), true, nil
+	}
+	return nil, false, memoryError(ase.PARAMETER_ERROR)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lines buffered for a monitor, the next ones are dropped until it reads
+// them, so a slow monitor does not slow down the other clients.
+const monitorBufferSize = 4096
+
+// Clients running MONITOR on a listener. Commands are formatted only when
+// there is at least one of them.
+type monitors struct {
+	count   int32
+	lock    sync.Mutex
+	clients map[chan string]bool
+}
+
+func newMonitors() *monitors {
+	return &monitors{clients: make(map[chan string]bool)}
+}
+
+func (m *monitors) add(c chan string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.clients[c] = true
+	atomic.AddInt32(&m.count, 1)
+}
+
+func (m *monitors) remove(c chan string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.clients, c)
+	atomic.AddInt32(&m.count, -1)
+}
+
+// Sends a command received on a connection to the monitors.
+func (m *monitors) feed(conn net.Conn, args [][]byte) {
+	if atomic.LoadInt32(&m.count) == 0 {
+		return
+	}
+	line := formatMonitorLine(time.Now(), clientAddr(conn), args)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for c := range m.clients {
+		select {
+		case c <- line:
+		default:
+		}
+	}
+}
+
+// Address of the client, like Redis does: ip:port, or unix:path.
+func clientAddr(conn net.Conn) string {
+	if conn.LocalAddr().Network() == "unix" {
+		return "unix:" + conn.LocalAddr().String()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// Quotes a string like the Redis sdscatrepr function.
+func quoteRedis(buf []byte) string {
+	res := make([]byte, 0, len(buf)+2)
+	res = append(res, '"')
+	for _, b := range buf {
+		switch b {
+		case '\\', '"':
+			res = append(res, '\\', b)
+		case '\n':
+			res = append(res, '\\', 'n')
+		case '\r':
+			res = append(res, '\\', 'r')
+		case '\t':
+			res = append(res, '\\', 't')
+		case '\a':
+			res = append(res, '\\', 'a')
+		case '\b':
+			res = append(res, '\\', 'b')
+		default:
+			if b >= 0x20 && b < 0x7f {
+				res = append(res, b)
+			} else {
+				res = append(res, '\\', 'x', "0123456789abcdef"[b>>4], "0123456789abcdef"[b&0xf])
+			}
+		}
+	}
+	return string(append(res, '"'))
+}
+
+// Formats a line like Redis: +1339518083.107412 [0 127.0.0.1:60866] "SET" "a" "1"
+func formatMonitorLine(at time.Time, addr string, args [][]byte) string {
+	micros := at.UnixNano() / 1000
+	line := "+" + strconv.FormatInt(micros/1000000, 10) + "." + strconv.FormatInt(1000000+micros%1000000, 10)[1:] + " [0 " + addr + "]"
+	for _, a := range args {
+		line += " " + quoteRedis(a)
+	}
+	return line
+}
+
+// Streams the commands received by the other clients of the listener, until
+// the monitor sends QUIT or closes the connection.
+func handleMonitor(conn net.Conn, reader *bufio.Reader, ctx *context) error {
+	// Monitors are never idle
+	conn.SetReadDeadline(time.Time{})
+	err := writeLine(conn, "+OK")
+	if err != nil {
+		return handleError(err, ctx, conn)
+	}
+	lines := make(chan string, monitorBufferSize)
+	ctx.monitors.add(lines)
+	defer ctx.monitors.remove(lines)
+	done := make(chan bool)
+	go func() {
+		for {
+			args, err := parse(reader)
+			if err != nil || (len(args) > 0 && string(args[0]) == "QUIT") {
+				close(done)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case line := <-lines:
+			err := writeLine(conn, line)
+			if err != nil {
+				return handleError(err, ctx, conn)
+			}
+		case <-done:
+			return handleError(nil, ctx, conn)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	as "github.com/aerospike/aerospike-client-go"
+)
+
+// The operations of the Aerospike client can not be inspected, so each
+// operation given to Operate also keeps its kind and its arguments, used by
+// the memory backend.
+type operation struct {
+	op   *as.Operation
+	kind opKind
+	bin  string
+	args []interface{}
+}
+
+type opKind int
+
+const (
+	opGet opKind = iota
+	opPut
+	opAdd
+	opAppend
+	opTouch
+	opListAppend
+	opListInsert
+	opListPop
+	opListRemove
+	opListSet
+	opListClear
+	opListSize
+	opListGet
+	opListGetRange
+	opMapPut
+	opMapPutItems
+	opMapIncrement
+	opMapSize
+	opMapRemoveByKey
+	opMapRemoveByKeyList
+	opMapRemoveByKeyRange
+	opMapRemoveByIndexRangeCount
+	opMapGetByKey
+	opMapGetByKeyList
+	opMapGetByKeyRange
+	opMapGetByIndexRange
+	opMapGetByIndexRangeCount
+	opMapGetByKeyRelativeIndexRangeCount
+	opBitGrow
+	opBitSet
+	opBitGet
+	opHLLInit
+	opHLLAdd
+	opHLLSetUnion
+	opHLLGetCount
+	opHLLGetUnionCount
+)
+
+// Return types of the map operations. The type of the client return types is
+// not exported, so they can not be given as parameters.
+type mapReturn int
+
+const (
+	returnNone mapReturn = iota
+	returnCount
+	returnKey
+	returnValue
+)
+
+// Maps are always ordered by key, the memory backend relies on it.
+type mapPolicy struct {
+	policy     *as.MapPolicy
+	createOnly bool
+}
+
+func newMapPolicy(keyValueOrdered bool, createOnly bool) *mapPolicy {
+	order := as.MapOrder.KEY_ORDERED
+	if keyValueOrdered {
+		order = as.MapOrder.KEY_VALUE_ORDERED
+	}
+	writeMode := as.MapWriteMode.UPDATE
+	if createOnly {
+		writeMode = as.MapWriteMode.CREATE_ONLY
+	}
+	return &mapPolicy{as.NewMapPolicy(order, writeMode), createOnly}
+}
+
+type bitPolicy struct {
+	policy *as.BitPolicy
+	flags  int
+}
+
+func newBitPolicy(flags int) *bitPolicy {
+	return &bitPolicy{as.NewBitPolicy(flags), flags}
+}
+
+var defaultBitPolicy = newBitPolicy(as.BitWriteFlagsDefault)
+
+type hllPolicy struct {
+	policy *as.HLLPolicy
+	flags  int
+}
+
+func newHLLPolicy(flags int) *hllPolicy {
+	return &hllPolicy{as.NewHLLPolicy(flags), flags}
+}
+
+var defaultHLLPolicy = newHLLPolicy(as.HLLWriteFlagsDefault)
+
+func newOperation(op *as.Operation, kind opKind, bin string, args ...interface{}) *operation {
+	return &operation{op, kind, bin, args}
+}
+
+func getOpForBin(bin string) *operation {
+	return newOperation(as.GetOpForBin(bin), opGet, bin)
+}
+
+func putOp(bin *as.Bin) *operation {
+	return newOperation(as.PutOp(bin), opPut, bin.Name, bin.Value)
+}
+
+func addOp(bin *as.Bin) *operation {
+	return newOperation(as.AddOp(bin), opAdd, bin.Name, bin.Value)
+}
+
+func appendOp(bin *as.Bin) *operation {
+	return newOperation(as.AppendOp(bin), opAppend, bin.Name, bin.Value)
+}
+
+func touchOp() *operation {
+	return newOperation(as.TouchOp(), opTouch, "")
+}
+
+func listAppendOp(bin string, values ...interface{}) *operation {
+	return newOperation(as.ListAppendOp(bin, values...), opListAppend, bin, values)
+}
+
+func listInsertOp(bin string, index int, values ...interface{}) *operation {
+	return newOperation(as.ListInsertOp(bin, index, values...), opListInsert, bin, index, values)
+}
+
+func listPopOp(bin string, index int) *operation {
+	return newOperation(as.ListPopOp(bin, index), opListPop, bin, index)
+}
+
+func listRemoveOp(bin string, index int) *operation {
+	return newOperation(as.ListRemoveOp(bin, index), opListRemove, bin, index)
+}
+
+func listSetOp(bin string, index int, value interface{}) *operation {
+	return newOperation(as.ListSetOp(bin, index, value), opListSet, bin, index, value)
+}
+
+func listClearOp(bin string) *operation {
+	return newOperation(as.ListClearOp(bin), opListClear, bin)
+}
+
+func listSizeOp(bin string) *operation {
+	return newOperation(as.ListSizeOp(bin), opListSize, bin)
+}
+
+func listGetOp(bin string, index int) *operation {
+	return newOperation(as.ListGetOp(bin, index), opListGet, bin, index)
+}
+
+func listGetRangeOp(bin string, index int, count int) *operation {
+	return newOperation(as.ListGetRangeOp(bin, index, count), opListGetRange, bin, index, count)
+}
+
+func mapPutOp(policy *mapPolicy, bin string, key interface{}, value interface{}) *operation {
+	return newOperation(as.MapPutOp(policy.policy, bin, key, value), opMapPut, bin, policy, key, value)
+}
+
+func mapPutItemsOp(policy *mapPolicy, bin string, items map[interface{}]interface{}) *operation {
+	return newOperation(as.MapPutItemsOp(policy.policy, bin, items), opMapPutItems, bin, policy, items)
+}
+
+func mapIncrementOp(policy *mapPolicy, bin string, key interface{}, incr interface{}) *operation {
+	return newOperation(as.MapIncrementOp(policy.policy, bin, key, incr), opMapIncrement, bin, policy, key, incr)
+}
+
+func mapSizeOp(bin string) *operation {
+	return newOperation(as.MapSizeOp(bin), opMapSize, bin)
+}
+
+func mapRemoveByKeyOp(bin string, key interface{}, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapRemoveByKeyOp(bin, key, r), opMapRemoveByKey, bin, returnType, key)
+}
+
+func mapRemoveByKeyListOp(bin string, keys []interface{}, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapRemoveByKeyListOp(bin, keys, r), opMapRemoveByKeyList, bin, returnType, keys)
+}
+
+// A nil begin is before the first key, a nil end is after the last one.
+func mapRemoveByKeyRangeOp(bin string, begin interface{}, end interface{}, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapRemoveByKeyRangeOp(bin, begin, end, r), opMapRemoveByKeyRange, bin, returnType, begin, end)
+}
+
+func mapRemoveByIndexRangeCountOp(bin string, index int, count int, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapRemoveByIndexRangeCountOp(bin, index, count, r), opMapRemoveByIndexRangeCount, bin, returnType, index, count)
+}
+
+func mapGetByKeyOp(bin string, key interface{}, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapGetByKeyOp(bin, key, r), opMapGetByKey, bin, returnType, key)
+}
+
+func mapGetByKeyListOp(bin string, keys []interface{}, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapGetByKeyListOp(bin, keys, r), opMapGetByKeyList, bin, returnType, keys)
+}
+
+func mapGetByKeyRangeOp(bin string, begin interface{}, end interface{}, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapGetByKeyRangeOp(bin, begin, end, r), opMapGetByKeyRange, bin, returnType, begin, end)
+}
+
+func mapGetByIndexRangeOp(bin string, index int, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapGetByIndexRangeOp(bin, index, r), opMapGetByIndexRange, bin, returnType, index)
+}
+
+func mapGetByIndexRangeCountOp(bin string, index int, count int, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapGetByIndexRangeCountOp(bin, index, count, r), opMapGetByIndexRangeCount, bin, returnType, index, count)
+}
+
+// Resizes a bitmap to byteSize, if it is smaller. The resize flags are
+// constants whose type depends on the version of the client, so only the
+// grow only resize is wrapped.
+// Returns count elements from the index relative to the position of key, which
+// does not have to be in the map.
+func mapGetByKeyRelativeIndexRangeCountOp(bin string, key interface{}, index int, count int, returnType mapReturn) *operation {
+	r := as.MapReturnType.NONE
+	switch returnType {
+	case returnCount:
+		r = as.MapReturnType.COUNT
+	case returnKey:
+		r = as.MapReturnType.KEY
+	case returnValue:
+		r = as.MapReturnType.VALUE
+	}
+	return newOperation(as.MapGetByKeyRelativeIndexRangeCountOp(bin, key, index, count, r), opMapGetByKeyRelativeIndexRangeCount, bin, returnType, key, index, count)
+}
+
+func bitGrowOp(policy *bitPolicy, bin string, byteSize int) *operation {
+	return newOperation(as.BitResizeOp(policy.policy, bin, byteSize, as.BitResizeFlagsGrowOnly), opBitGrow, bin, policy, byteSize)
+}
+
+func bitSetOp(policy *bitPolicy, bin string, bitOffset int, bitSize int, value []byte) *operation {
+	return newOperation(as.BitSetOp(policy.policy, bin, bitOffset, bitSize, value), opBitSet, bin, policy, bitOffset, bitSize, value)
+}
+
+func bitGetOp(bin string, bitOffset int, bitSize int) *operation {
+	return newOperation(as.BitGetOp(bin, bitOffset, bitSize), opBitGet, bin, bitOffset, bitSize)
+}
+
+func hllInitOp(policy *hllPolicy, bin string, indexBitCount int, minHashBitCount int) *operation {
+	return newOperation(as.HLLInitOp(policy.policy, bin, indexBitCount, minHashBitCount), opHLLInit, bin, policy)
+}
+
+func hllAddOp(policy *hllPolicy, bin string, values []as.Value, indexBitCount int, minHashBitCount int) *operation {
+	return newOperation(as.HLLAddOp(policy.policy, bin, values, indexBitCount, minHashBitCount), opHLLAdd, bin, policy, values)
+}
+
+func hllSetUnionOp(policy *hllPolicy, bin string, hlls []as.HLLValue) *operation {
+	return newOperation(as.HLLSetUnionOp(policy.policy, bin, hlls), opHLLSetUnion, bin, policy, hlls)
+}
+
+func hllGetCountOp(bin string) *operation {
+	return newOperation(as.HLLGetCountOp(bin), opHLLGetCount, bin)
+}
+
+func hllGetUnionCountOp(bin string, hlls []as.HLLValue) *operation {
+	return newOperation(as.HLLGetUnionCountOp(bin, hlls), opHLLGetUnionCount, bin, hlls)
+}
+
+// Map, bit and HLL operations make the server respond for each operation,
+// and not only for the reads.
+func (o *operation) respondAll() bool {
+	return o.kind >= opMapPut
+}
+
+func (o *operation) isWrite() bool {
+	switch o.kind {
+	case opGet, opListSize, opListGet, opListGetRange, opMapSize, opMapGetByKey, opMapGetByKeyList, opMapGetByKeyRange, opMapGetByIndexRange, opMapGetByIndexRangeCount, opMapGetByKeyRelativeIndexRangeCount, opBitGet, opHLLGetCount, opHLLGetUnionCount:
+		return false
+	}
+	return true
+}
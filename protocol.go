@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"strconv"
@@ -89,3 +90,72 @@ func parse(reader *bufio.Reader) ([][]byte, error) {
 	}
 	return args, nil
 }
+
+// Reads a reply, used by the bench and replay subcommands, and returns its
+// raw bytes.
+func readReply(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("Protocol error: '" + string(line) + "'")
+	}
+	switch line[0] {
+	case '-', '+', ':':
+		return line, nil
+	case '$':
+		size, err := strconv.Atoi(string(bytes.TrimRight(line[1:], "\r\n")))
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return line, nil
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		return append(line, buf...), nil
+	case '*':
+		size, err := strconv.Atoi(string(bytes.TrimRight(line[1:], "\r\n")))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < size; i++ {
+			element, err := readReply(reader)
+			if err != nil {
+				return nil, err
+			}
+			line = append(line, element...)
+		}
+		return line, nil
+	}
+	return nil, errors.New("Protocol error: '" + string(line) + "'")
+}
+
+// Splits the raw bytes of an array reply, returned by readReply, in the raw
+// bytes of its elements. Returns nil for the other replies and the nil array.
+func replyElements(raw []byte) ([][]byte, error) {
+	if len(raw) == 0 || raw[0] != '*' {
+		return nil, nil
+	}
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(string(bytes.TrimRight(line[1:], "\r\n")))
+	if err != nil || size < 0 {
+		return nil, err
+	}
+	elements := make([][]byte, size)
+	for i := range elements {
+		elements[i], err = readReply(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
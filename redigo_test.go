@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Connects a real Go client, which parses the replies itself, to check the
+// common commands and pipelining the way applications use them.
+func dialRedigo(t *testing.T, s *testServer) redis.Conn {
+	conn, err := redis.Dial("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestRedigo(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		conn := dialRedigo(t, s)
+		defer conn.Close()
+
+		ok, err := redis.String(conn.Do("SET", "myKey", "a"))
+		if err != nil || ok != "OK" {
+			t.Errorf("SET: got %s, %v", ok, err)
+		}
+		v, err := redis.String(conn.Do("GET", "myKey"))
+		if err != nil || v != "a" {
+			t.Errorf("GET: got %s, %v", v, err)
+		}
+		_, err = redis.String(conn.Do("GET", "unknown"))
+		if err != redis.ErrNil {
+			t.Errorf("GET unknown: got %v", err)
+		}
+		values, err := redis.Strings(conn.Do("MGET", "myKey", "unknown"))
+		if err != nil || !reflect.DeepEqual(values, []string{"a", ""}) {
+			t.Errorf("MGET: got %q, %v", values, err)
+		}
+		n, err := redis.Int(conn.Do("INCRBY", "myCounter", 5))
+		if err != nil || n != 5 {
+			t.Errorf("INCRBY: got %d, %v", n, err)
+		}
+		exists, err := redis.Bool(conn.Do("EXISTS", "myCounter"))
+		if err != nil || !exists {
+			t.Errorf("EXISTS: got %t, %v", exists, err)
+		}
+		n, err = redis.Int(conn.Do("EXPIRE", "myCounter", 100))
+		if err != nil || n != 1 {
+			t.Errorf("EXPIRE: got %d, %v", n, err)
+		}
+		n, err = redis.Int(conn.Do("TTL", "myCounter"))
+		if err != nil || n != 100 {
+			t.Errorf("TTL: got %d, %v", n, err)
+		}
+		n, err = redis.Int(conn.Do("DEL", "myKey"))
+		if err != nil || n != 1 {
+			t.Errorf("DEL: got %d, %v", n, err)
+		}
+
+		n, err = redis.Int(conn.Do("HSET", "myHash", "a", 1, "b", "x"))
+		if err != nil || n != 2 {
+			t.Errorf("HSET: got %d, %v", n, err)
+		}
+		hash, err := redis.StringMap(conn.Do("HGETALL", "myHash"))
+		if err != nil || !reflect.DeepEqual(hash, map[string]string{"a": "1", "b": "x"}) {
+			t.Errorf("HGETALL: got %v, %v", hash, err)
+		}
+		n, err = redis.Int(conn.Do("HINCRBY", "myHash", "a", 2))
+		if err != nil || n != 3 {
+			t.Errorf("HINCRBY: got %d, %v", n, err)
+		}
+		_, err = redis.String(conn.Do("HGET", "myHash", "unknown"))
+		if err != redis.ErrNil {
+			t.Errorf("HGET unknown: got %v", err)
+		}
+
+		n, err = redis.Int(conn.Do("RPUSH", "myList", "a", "b", "c"))
+		if err != nil || n != 3 {
+			t.Errorf("RPUSH: got %d, %v", n, err)
+		}
+		values, err = redis.Strings(conn.Do("LRANGE", "myList", 0, -1))
+		if err != nil || !reflect.DeepEqual(values, []string{"a", "b", "c"}) {
+			t.Errorf("LRANGE: got %q, %v", values, err)
+		}
+		v, err = redis.String(conn.Do("LPOP", "myList"))
+		if err != nil || v != "a" {
+			t.Errorf("LPOP: got %s, %v", v, err)
+		}
+
+		// Errors are replied, the connection stays usable
+		_, err = conn.Do("GET", "myList")
+		if _, ok := err.(redis.Error); !ok {
+			t.Errorf("GET on a list: got %v", err)
+		}
+		n, err = redis.Int(conn.Do("LLEN", "myList"))
+		if err != nil || n != 2 {
+			t.Errorf("LLEN: got %d, %v", n, err)
+		}
+	})
+}
+
+func TestRedigoPipeline(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		conn := dialRedigo(t, s)
+		defer conn.Close()
+
+		for i := 0; i < 10; i++ {
+			conn.Send("RPUSH", "myList", i)
+		}
+		conn.Send("LLEN", "myList")
+		err := conn.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			n, err := redis.Int(conn.Receive())
+			if err != nil || n != i+1 {
+				t.Errorf("RPUSH: got %d, %v", n, err)
+			}
+		}
+		n, err := redis.Int(conn.Receive())
+		if err != nil || n != 10 {
+			t.Errorf("LLEN: got %d, %v", n, err)
+		}
+
+		conn.Send("MULTI")
+		conn.Send("SET", "myKey", "a")
+		conn.Send("GET", "myKey")
+		res, err := redis.Values(conn.Do("EXEC"))
+		if err != nil || len(res) != 2 {
+			t.Fatalf("EXEC: got %v, %v", res, err)
+		}
+		v, err := redis.String(res[1], nil)
+		if err != nil || v != "a" {
+			t.Errorf("GET in EXEC: got %s, %v", v, err)
+		}
+	})
+}
@@ -39,6 +39,41 @@ func standardHandlers() map[string]handler {
 	handlers["SETNX"] = handler{2, 1, cmdSETNX, false}
 	handlers["MGET"] = handler{2, 2, cmdMGET, false}
 	handlers["MSET"] = handler{2, 2, cmdMSET, false}
+	handlers["MSETNX"] = handler{2, 2, cmdMSETNX, false}
+	handlers["APPEND"] = handler{2, 1, cmdAPPEND, false}
+	handlers["STRLEN"] = handler{1, 1, cmdSTRLEN, false}
+	handlers["GETRANGE"] = handler{3, 3, cmdGETRANGE, false}
+	handlers["SETRANGE"] = handler{3, 2, cmdSETRANGE, false}
+	handlers["GETSET"] = handler{2, 1, cmdGETSET, false}
+	handlers["GETDEL"] = handler{1, 1, cmdGETDEL, false}
+	handlers["GETEX"] = handler{1, 1, cmdGETEX, false}
+	handlers["INCRBYFLOAT"] = handler{2, 2, cmdINCRBYFLOAT, false}
+	handlers["SETBIT"] = handler{3, 3, cmdSETBIT, false}
+	handlers["GETBIT"] = handler{2, 2, cmdGETBIT, false}
+	handlers["BITCOUNT"] = handler{1, 1, cmdBITCOUNT, false}
+	handlers["BITPOS"] = handler{2, 2, cmdBITPOS, false}
+	handlers["BITOP"] = handler{3, 3, cmdBITOP, false}
+	handlers["PFADD"] = handler{1, 1, cmdPFADD, false}
+	handlers["PFADDEX"] = handler{2, 2, cmdPFADDEX, false}
+	handlers["PFCOUNT"] = handler{1, 1, cmdPFCOUNT, false}
+	handlers["PFMERGE"] = handler{1, 1, cmdPFMERGE, false}
+	handlers["PFMERGEEX"] = handler{2, 2, cmdPFMERGEEX, false}
+	handlers["GEOADD"] = handler{4, 1, cmdGEOADD, false}
+	handlers["GEOPOS"] = handler{1, 1, cmdGEOPOS, false}
+	handlers["GEOHASH"] = handler{1, 1, cmdGEOHASH, false}
+	handlers["GEODIST"] = handler{3, 3, cmdGEODIST, false}
+	handlers["GEOSEARCH"] = handler{6, 1, cmdGEOSEARCH, false}
+	handlers["XADD"] = handler{4, 1, cmdXADD, false}
+	handlers["XLEN"] = handler{1, 1, cmdXLEN, false}
+	handlers["XRANGE"] = handler{3, 3, cmdXRANGE, false}
+	handlers["XREVRANGE"] = handler{3, 3, cmdXREVRANGE, false}
+	handlers["XDEL"] = handler{2, 1, cmdXDEL, false}
+	handlers["XTRIM"] = handler{3, 1, cmdXTRIM, false}
+	handlers["XREAD"] = handler{3, 3, cmdXREAD, false}
+	handlers["XGROUP"] = handler{3, 3, cmdXGROUP, false}
+	handlers["XREADGROUP"] = handler{6, 3, cmdXREADGROUP, false}
+	handlers["XACK"] = handler{3, 2, cmdXACK, false}
+	handlers["XPENDING"] = handler{2, 2, cmdXPENDING, false}
 	handlers["LLEN"] = handler{1, 1, cmdLLEN, false}
 	handlers["RPUSH"] = handler{2, 1, cmdRPUSH, false}
 	handlers["LPUSH"] = handler{2, 1, cmdLPUSH, false}
@@ -48,6 +83,19 @@ func standardHandlers() map[string]handler {
 	handlers["LPOP"] = handler{1, 1, cmdLPOP, false}
 	handlers["LRANGE"] = handler{3, 1, cmdLRANGE, false}
 	handlers["LTRIM"] = handler{3, 3, cmdLTRIM, false}
+	handlers["RPUSHX"] = handler{2, 1, cmdRPUSHX, false}
+	handlers["LPUSHX"] = handler{2, 1, cmdLPUSHX, false}
+	handlers["LINDEX"] = handler{2, 2, cmdLINDEX, false}
+	handlers["LSET"] = handler{3, 2, cmdLSET, false}
+	handlers["LINSERT"] = handler{4, 3, cmdLINSERT, false}
+	handlers["LREM"] = handler{3, 2, cmdLREM, false}
+	handlers["LPOS"] = handler{2, 1, cmdLPOS, false}
+	handlers["BLPOP"] = handler{2, 1, cmdBLPOP, false}
+	handlers["BRPOP"] = handler{2, 1, cmdBRPOP, false}
+	handlers["BLMOVE"] = handler{5, 2, cmdBLMOVE, false}
+	handlers["BRPOPLPUSH"] = handler{3, 2, cmdBRPOPLPUSH, false}
+	handlers["RPOPLPUSH"] = handler{2, 2, cmdRPOPLPUSH, false}
+	handlers["LMOVE"] = handler{4, 2, cmdLMOVE, false}
 	handlers["INCR"] = handler{1, 1, cmdINCR, false}
 	handlers["INCRBY"] = handler{2, 2, cmdINCRBY, false}
 	handlers["INCRBYEX"] = handler{3, 3, cmdINCRBYEX, false}
@@ -64,9 +112,30 @@ func standardHandlers() map[string]handler {
 	handlers["HMSET"] = handler{3, 2, cmdHMSET, false}
 	handlers["HMINCRBYEX"] = handler{2, 2, cmdHMINCRBYEX, false}
 	handlers["HGETALL"] = handler{1, 1, cmdHGETALL, false}
+	handlers["HEXISTS"] = handler{2, 2, cmdHEXISTS, false}
+	handlers["HLEN"] = handler{1, 1, cmdHLEN, false}
+	handlers["HKEYS"] = handler{1, 1, cmdHKEYS, false}
+	handlers["HVALS"] = handler{1, 1, cmdHVALS, false}
+	handlers["HSETNX"] = handler{3, 2, cmdHSETNX, false}
+	handlers["HSTRLEN"] = handler{2, 2, cmdHSTRLEN, false}
+	handlers["HINCRBYFLOAT"] = handler{3, 3, cmdHINCRBYFLOAT, false}
+	handlers["HSCAN"] = handler{2, 2, cmdHSCAN, false}
 	handlers["EXPIRE"] = handler{2, 2, cmdEXPIRE, false}
 	handlers["TTL"] = handler{1, 1, cmdTTL, false}
+	handlers["PEXPIRE"] = handler{2, 2, cmdPEXPIRE, false}
+	handlers["EXPIREAT"] = handler{2, 2, cmdEXPIREAT, false}
+	handlers["PEXPIREAT"] = handler{2, 2, cmdPEXPIREAT, false}
+	handlers["PTTL"] = handler{1, 1, cmdPTTL, false}
+	handlers["EXPIRETIME"] = handler{1, 1, cmdEXPIRETIME, false}
+	handlers["PEXPIRETIME"] = handler{1, 1, cmdPEXPIRETIME, false}
+	handlers["PERSIST"] = handler{1, 1, cmdPERSIST, false}
+	handlers["RENAME"] = handler{2, 2, cmdRENAME, false}
+	handlers["RENAMENX"] = handler{2, 2, cmdRENAMENX, false}
+	handlers["COPY"] = handler{2, 2, cmdCOPY, false}
+	handlers["TYPE"] = handler{1, 1, cmdTYPE, false}
+	handlers["RANDOMKEY"] = handler{0, 0, cmdRANDOMKEY, false}
 	handlers["FLUSHDB"] = handler{0, 0, cmdFLUSHDB, false}
+	handlers["SLOWLOG"] = handler{1, 1, cmdSLOWLOG, false}
 	return handlers
 }
 
@@ -83,8 +152,51 @@ func expandedMapHandlers() map[string]handler {
 	handlers["HMSET"] = handler{3, 2, cmdExpandedMapHMSET, false}
 	handlers["HMINCRBYEX"] = handler{2, 2, cmdExpandedMapHMINCRBYEX, false}
 	handlers["HGETALL"] = handler{1, 1, cmdExpandedMapHGETALL, false}
+	handlers["HEXISTS"] = handler{2, 2, cmdExpandedMapHEXISTS, false}
+	handlers["HLEN"] = handler{1, 1, cmdExpandedMapHLEN, false}
+	handlers["HKEYS"] = handler{1, 1, cmdExpandedMapHKEYS, false}
+	handlers["HVALS"] = handler{1, 1, cmdExpandedMapHVALS, false}
+	handlers["HSETNX"] = handler{3, 2, cmdExpandedMapHSETNX, false}
+	handlers["HSTRLEN"] = handler{2, 2, cmdExpandedMapHSTRLEN, false}
+	handlers["HINCRBYFLOAT"] = handler{3, 3, cmdExpandedMapHINCRBYFLOAT, false}
+	handlers["HSCAN"] = handler{2, 2, cmdExpandedMapHSCAN, false}
 	handlers["EXPIRE"] = handler{2, 2, cmdExpandedMapEXPIRE, false}
 	handlers["TTL"] = handler{1, 1, cmdExpandedMapTTL, false}
+	handlers["PEXPIRE"] = handler{2, 2, cmdExpandedMapPEXPIRE, false}
+	handlers["EXPIREAT"] = handler{2, 2, cmdExpandedMapEXPIREAT, false}
+	handlers["PEXPIREAT"] = handler{2, 2, cmdExpandedMapPEXPIREAT, false}
+	handlers["PTTL"] = handler{1, 1, cmdExpandedMapPTTL, false}
+	handlers["EXPIRETIME"] = handler{1, 1, cmdExpandedMapEXPIRETIME, false}
+	handlers["PEXPIRETIME"] = handler{1, 1, cmdExpandedMapPEXPIRETIME, false}
+	handlers["PERSIST"] = handler{1, 1, cmdExpandedMapPERSIST, false}
+	handlers["RENAME"] = handler{2, 2, cmdExpandedMapRENAME, false}
+	handlers["RENAMENX"] = handler{2, 2, cmdExpandedMapRENAMENX, false}
+	handlers["COPY"] = handler{2, 2, cmdExpandedMapCOPY, false}
+	handlers["TYPE"] = handler{1, 1, cmdExpandedMapTYPE, false}
+	return handlers
+}
+
+func cdtMapHandlers() map[string]handler {
+	handlers := standardHandlers()
+	handlers["HINCRBY"] = handler{3, 3, cmdCdtMapHINCRBY, false}
+	handlers["HINCRBYEX"] = handler{4, 4, cmdCdtMapHINCRBYEX, false}
+	handlers["HGET"] = handler{2, 2, cmdCdtMapHGET, false}
+	handlers["HSET"] = handler{3, 2, cmdCdtMapHSET, false}
+	handlers["HSETEX"] = handler{4, 3, cmdCdtMapHSETEX, false}
+	handlers["HDEL"] = handler{2, 2, cmdCdtMapHDEL, false}
+	handlers["HMGET"] = handler{2, 2, cmdCdtMapHMGET, false}
+	handlers["HMSET"] = handler{3, 2, cmdCdtMapHMSET, false}
+	handlers["HMINCRBYEX"] = handler{2, 2, cmdCdtMapHMINCRBYEX, false}
+	handlers["HGETALL"] = handler{1, 1, cmdCdtMapHGETALL, false}
+	handlers["HEXISTS"] = handler{2, 2, cmdCdtMapHEXISTS, false}
+	handlers["HLEN"] = handler{1, 1, cmdCdtMapHLEN, false}
+	handlers["HKEYS"] = handler{1, 1, cmdCdtMapHKEYS, false}
+	handlers["HVALS"] = handler{1, 1, cmdCdtMapHVALS, false}
+	handlers["HSETNX"] = handler{3, 2, cmdCdtMapHSETNX, false}
+	handlers["HSTRLEN"] = handler{2, 2, cmdCdtMapHSTRLEN, false}
+	handlers["HINCRBYFLOAT"] = handler{3, 3, cmdCdtMapHINCRBYFLOAT, false}
+	handlers["HSCAN"] = handler{2, 2, cmdCdtMapHSCAN, false}
+	handlers["TYPE"] = handler{1, 1, cmdCdtMapTYPE, false}
 	return handlers
 }
 
@@ -109,12 +221,138 @@ func displayExpandedMapCacheStat(ctx *context) {
 	}
 }
 
+func connectAerospike(hosts []string, aPort int, connectionQueueSize int, ns string) backend {
+	for {
+		for _, i := range hosts {
+			log.Printf("Connecting to aero on %s:%d", i, aPort)
+			policy := as.NewClientPolicy()
+			policy.ConnectionQueueSize = connectionQueueSize
+			client, err := as.NewClientWithPolicy(policy, i, aPort)
+			if err == nil {
+				log.Printf("Connected to aero on %s:%d, namespace %s", i, aPort, ns)
+				return &aerospikeBackend{client}
+			}
+			log.Printf("Unable to connect to %s:%d, %s", i, aPort, err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func newBackend(name string, hosts []string, aPort int, connectionQueueSize int, ns string) backend {
+	switch name {
+	case "aerospike":
+		return connectAerospike(hosts, aPort, connectionQueueSize, ns)
+	case "memory":
+		log.Printf("Using memory backend, namespace %s", ns)
+		nsNeverExpire = true
+		return newMemoryBackend()
+	}
+	panic("Unknown backend " + name)
+}
+
+// Builds the context and the handlers of a set, from its configuration.
+func setContext(client backend, exitOnClusterLost bool, ns string, generationRetries int, readPolicy *as.BasePolicy, writePolicy *as.WritePolicy, m map[string]interface{}) (*context, map[string]handler) {
+	set := m["set"].(string)
+	ctx := &context{client, exitOnClusterLost, ns, set, readPolicy, writePolicy, 0, 0, 0, 0, 0, nil, 0, false, generationRetries, false, compressionNone, 0, 0, 0, newWaiters(), nil, newMonitors(), nil, 0, -1, 0, 0, 0}
+
+	if m["log_commands"] != nil {
+		ctx.logCommands = true
+	}
+	if m["strict_encoding"] != nil {
+		ctx.strictEncoding = true
+		log.Printf("%s: Strict encoding mode", set)
+	}
+	if m["compression"] != nil {
+		var err error
+		ctx.compression, err = compressionAlgorithm(m["compression"].(string))
+		if err != nil {
+			panic(err)
+		}
+		ctx.compressionThreshold = defaultCompressionThreshold
+		if m["compression_threshold"] != nil {
+			ctx.compressionThreshold = getIntFromJson(m["compression_threshold"])
+		}
+		log.Printf("%s: Using %s compression for values above %d bytes", set, m["compression"], ctx.compressionThreshold)
+	}
+	if m["timeout"] != nil {
+		ctx.idleTimeout = time.Duration(getIntFromJson(m["timeout"])) * time.Second
+		log.Printf("%s: Closing the connections idle for %s", set, ctx.idleTimeout)
+	}
+	if m["tcp_keepalive"] != nil {
+		ctx.tcpKeepAlive = time.Duration(getIntFromJson(m["tcp_keepalive"])) * time.Second
+	}
+	if m["maxclients"] != nil {
+		ctx.maxClients = int32(getIntFromJson(m["maxclients"]))
+		log.Printf("%s: Accepting at most %d connections", set, ctx.maxClients)
+	}
+	slowlogThreshold := defaultSlowlogThreshold
+	if m["slowlog_log_slower_than"] != nil {
+		slowlogThreshold = time.Duration(getIntFromJson(m["slowlog_log_slower_than"])) * time.Microsecond
+	}
+	slowlogMaxLen := defaultSlowlogMaxLen
+	if m["slowlog_max_len"] != nil {
+		slowlogMaxLen = getIntFromJson(m["slowlog_max_len"])
+	}
+	ctx.slowlog = newSlowlog(slowlogThreshold, slowlogMaxLen)
+	if m["capture"] != nil {
+		sample := 1.0
+		if m["capture_sample"] != nil {
+			sample = m["capture_sample"].(float64)
+		}
+		redact := m["capture_redact"] != nil && m["capture_redact"].(bool)
+		var err error
+		ctx.capture, err = newCapture(m["capture"].(string), sample, redact)
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("%s: Capturing %.2f %% of the connections to %s, redacted keys: %t", set, sample*100, m["capture"], redact)
+	}
+	if m["expanded_map"] != nil {
+		if m["default_ttl"] != nil {
+			ctx.expandedMapDefaultTTL = getIntFromJson(m["default_ttl"])
+		} else {
+			ctx.expandedMapDefaultTTL = 3600 * 24 * 31
+		}
+		log.Printf("%s: Expanded map mode, ttl %d", set, ctx.expandedMapDefaultTTL)
+		if m["cache_size"] != nil {
+			size := getIntFromJson(m["cache_size"])
+			ctx.expandedMapCache = freecache.NewCache(size)
+			ctx.expandedMapCacheTTL = 600
+			if m["cache_ttl"] != nil {
+				ctx.expandedMapCacheTTL = getIntFromJson(m["cache_ttl"])
+			}
+			log.Printf("%s: Using a cache of %d bytes, ttl %d", set, size, ctx.expandedMapCacheTTL)
+			go displayExpandedMapCacheStat(ctx)
+		}
+		return ctx, writeBack(expandedMapHandlers(), m, ctx)
+	}
+	if m["cdt_map"] != nil {
+		log.Printf("%s: Cdt map mode", set)
+		return ctx, writeBack(cdtMapHandlers(), m, ctx)
+	}
+	return ctx, writeBack(standardHandlers(), m, ctx)
+}
+
+// Tools run with aerodis <subcommand> [flags], instead of the proxy.
+var subcommands = map[string]func([]string, io.Writer) error{
+	"bench":  bench,
+	"replay": replay,
+}
+
 func main() {
 	// to change the flags on the default logger
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
 	rand.Seed(time.Now().UnixNano())
 
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		err := subcommands[os.Args[1]](os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	aeroHost := flag.String("aero_host", "localhost", "Aerospike server host")
 	aeroPort := flag.Int("aero_port", 3000, "Aerospike server port")
 	ns := flag.String("ns", "test", "Aerospike namespace")
@@ -122,6 +360,11 @@ func main() {
 	exitOnClusterLost := flag.Bool("exit_on_cluster_lost", true, "Exit with an error when the connection to the cluster is lost")
 	generationRetries := flag.Int("generation_retries", 10, "Number of retry when error conflict in HSET / HDEL / LTRIM")
 	connectionQueueSize := flag.Int("connection_queue_size", 256, "Max number of connections to each aerospike node")
+	sendKeyFlag := flag.Bool("send_key", false, "Store the keys in Aerospike, needed by RANDOMKEY")
+	nsNeverExpireFlag := flag.Bool("ns_never_expire", false, "The namespace default-ttl is 0, records can be created without ttl in one write")
+	backendFlag := flag.String("backend", "aerospike", "Storage backend: aerospike, or memory to run without cluster")
+	adminFlag := flag.String("admin", "", "Admin HTTP listener, host:port or a port on localhost, serving pprof and the stats")
+	profileCommandFlag := flag.Bool("profile_command", true, "Enable the PROFILE command")
 	flag.Parse()
 
 	config := []byte("{\"sets\":[{\"proto\":\"tcp\",\"listen\":\"127.0.0.1:6379\",\"set\":\"redis\"}]}")
@@ -146,6 +389,21 @@ func main() {
 		connectionQueueSize = &jsonConnectionQueueSize
 	}
 
+	sendKey = *sendKeyFlag
+	if m["send_key"] != nil {
+		sendKey = m["send_key"].(bool)
+	}
+
+	nsNeverExpire = *nsNeverExpireFlag
+	if m["ns_never_expire"] != nil {
+		nsNeverExpire = m["ns_never_expire"].(bool)
+	}
+
+	profileCommand = *profileCommandFlag
+	if m["profile_command"] != nil {
+		profileCommand = m["profile_command"].(bool)
+	}
+
 	if m["max_fds"] != nil {
 		maxFds := getIntFromJson(m["max_fds"])
 		var rLimit syscall.Rlimit
@@ -173,30 +431,13 @@ func main() {
 		hosts = append(hosts, *aeroHost)
 	}
 
-	var client *as.Client
-	var err error
-	connected := false
-
-	for !connected {
-		for _, i := range hosts {
-			log.Printf("Connecting to aero on %s:%d", i, aPort)
-			policy := as.NewClientPolicy()
-			policy.RequestProleReplicas = true
-			policy.ConnectionQueueSize = *connectionQueueSize
-			client, err = as.NewClientWithPolicy(policy, i, aPort)
-			if err == nil {
-				log.Printf("Connected to aero on %s:%d, namespace %s", i, aPort, *ns)
-				connected = true
-				break
-			} else {
-				log.Printf("Unable to connect to %s:%d, %s", i, aPort, err)
-			}
-		}
-		if !connected {
-			time.Sleep(5 * time.Second)
-		}
+	backendName := *backendFlag
+	if m["backend"] != nil {
+		backendName = m["backend"].(string)
 	}
 
+	client := newBackend(backendName, hosts, aPort, *connectionQueueSize, *ns)
+
 	readPolicy := createReadPolicy()
 	writePolicy := createWritePolicyEx(-1, false)
 
@@ -206,6 +447,12 @@ func main() {
 
 	statsdConfig := m["statsd"]
 
+	adminListen := *adminFlag
+	if m["admin"] != nil {
+		adminListen = m["admin"].(string)
+	}
+	adminSets := make([]adminSet, 0)
+
 	for _, c := range sets.([]interface{}) {
 		wg.Add(1)
 
@@ -233,37 +480,20 @@ func main() {
 
 		log.Printf("%s: Listening on %s", set, listen)
 
-		ctx := context{client, *exitOnClusterLost, *ns, set, readPolicy, writePolicy, 0, 0, 0, 0, 0, nil, 0, false, *generationRetries}
+		ctx, handlers := setContext(client, *exitOnClusterLost, *ns, *generationRetries, readPolicy, writePolicy, m)
 
 		if statsdConfig != nil {
 			log.Printf("%s: Sending stats to statsd %s", set, statsdConfig)
-			go statsd(statsdConfig.(string), &ctx)
+			go statsd(statsdConfig.(string), ctx)
 		}
 
-		if m["log_commands"] != nil {
-			ctx.logCommands = true
-		}
-		if m["expanded_map"] != nil {
-			if m["default_ttl"] != nil {
-				ctx.expandedMapDefaultTTL = getIntFromJson(m["default_ttl"])
-			} else {
-				ctx.expandedMapDefaultTTL = 3600 * 24 * 31
-			}
-			log.Printf("%s: Expanded map mode, ttl %d", set, ctx.expandedMapDefaultTTL)
-			if m["cache_size"] != nil {
-				size := getIntFromJson(m["cache_size"])
-				ctx.expandedMapCache = freecache.NewCache(size)
-				ctx.expandedMapCacheTTL = 600
-				if m["cache_ttl"] != nil {
-					ctx.expandedMapCacheTTL = getIntFromJson(m["cache_ttl"])
-				}
-				log.Printf("%s: Using a cache of %d bytes, ttl %d", set, size, ctx.expandedMapCacheTTL)
-				go displayExpandedMapCacheStat(&ctx)
-			}
-			go handlePort(&ctx, l, writeBack(expandedMapHandlers(), m, &ctx))
-		} else {
-			go handlePort(&ctx, l, writeBack(standardHandlers(), m, &ctx))
-		}
+		adminSets = append(adminSets, adminSet{listen, ctx})
+
+		go handlePort(ctx, l, handlers)
+	}
+
+	if adminListen != "" {
+		go serveAdmin(adminListen, m, adminSets)
 	}
 
 	wg.Wait()
@@ -274,38 +504,88 @@ func handlePort(ctx *context, l net.Listener, handlers map[string]handler) {
 		conn, err := l.Accept()
 		if err != nil {
 			log.Print("Error accepting: ", err.Error())
+		} else if ctx.maxClients > 0 && atomic.LoadInt32(&ctx.gaugeConn) >= ctx.maxClients {
+			atomic.AddUint32(&ctx.counterRejected, 1)
+			write(conn, []byte("-ERR max number of clients reached\r\n"))
+			conn.Close()
 		} else {
+			setKeepAlive(ctx, conn)
 			atomic.AddInt32(&ctx.gaugeConn, 1)
 			go handleConnection(conn, handlers, ctx)
 		}
 	}
 }
 
+// A negative tcp_keepalive keeps the Go default, 0 disables the keepalive.
+func setKeepAlive(ctx *context, conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || ctx.tcpKeepAlive < 0 {
+		return
+	}
+	if ctx.tcpKeepAlive == 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(ctx.tcpKeepAlive)
+}
+
 func handleConnection(conn net.Conn, handlers map[string]handler, ctx *context) error {
 	multiBuffer := bytes.NewBuffer(nil)
 	multiMode := false
 	multiCounter := 0
 
 	errorPrefix := "[" + (*ctx).set + "]"
+	cl, tracked := clients.add(conn, ctx.set)
+	defer clients.remove(cl)
+	conn = tracked
 
 	reader := bufio.NewReaderSize(conn, 1024)
+	var wf io.Writer = &blockingConn{conn, reader}
+	var captured *captureWriter
+	captureConnection := uint64(0)
+	if ctx.capture != nil {
+		captureConnection = ctx.capture.connection()
+		if captureConnection != 0 {
+			captured = &captureWriter{w: wf}
+			wf = captured
+		}
+	}
+
 	for {
+		if ctx.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(ctx.idleTimeout))
+		}
 		args, err := parse(reader)
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || atomic.LoadInt32(&cl.killed) == 1 {
+				return handleError(nil, ctx, conn)
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				atomic.AddUint32(&ctx.counterTimeout, 1)
 				return handleError(nil, ctx, conn)
 			}
 			writeErr(conn, errorPrefix, err.Error(), args)
 			atomic.AddUint32(&ctx.counterErr, 1)
 			return handleError(err, ctx, conn)
 		}
+		at := time.Now()
 
 		cmd := string(args[0])
+		cl.command(cmd)
 		switch cmd {
 		case "QUIT":
 			return handleError(nil, ctx, conn)
 
+		case "MONITOR":
+			atomic.StoreInt32(&cl.monitor, 1)
+			return handleMonitor(conn, reader, ctx)
+
 		case "PROFILE":
+			if !profileCommand {
+				writeErrorReply(wf, "PROFILE is disabled")
+				continue
+			}
 			fname := "/tmp/redis_go_profile"
 			f, err := os.Create(fname)
 			if err != nil {
@@ -322,16 +602,32 @@ func handleConnection(conn net.Conn, handlers map[string]handler, ctx *context)
 			return handleError(err, ctx, conn)
 		}
 
-		execErr := handleCommand(conn, args, handlers, ctx, &multiMode, &multiCounter, multiBuffer)
+		ctx.monitors.feed(conn, args)
+		var execErr error
+		if cmd == "CLIENT" {
+			execErr = handleClientCommand(wf, cl, args)
+		} else {
+			waitClientsPause()
+			execErr = handleCommand(wf, args, handlers, ctx, cl.addr, &multiMode, &multiCounter, multiBuffer)
+		}
+		if execErr == errClientClosed || (execErr != nil && atomic.LoadInt32(&cl.killed) == 1) {
+			return handleError(nil, ctx, conn)
+		}
+		if execErr != nil {
+			writeErr(wf, errorPrefix, execErr.Error(), args)
+		}
+		if captured != nil {
+			ctx.capture.record(captureConnection, at, args, captured.reply.Bytes())
+			captured.reply.Reset()
+		}
 		if execErr != nil {
-			writeErr(conn, errorPrefix, execErr.Error(), args)
 			atomic.AddUint32(&ctx.counterErr, 1)
 			return handleError(execErr, ctx, conn)
 		}
 	}
 }
 
-func handleCommand(wf io.Writer, args [][]byte, handlers map[string]handler, ctx *context, multiMode *bool, multiCounter *int, multiBuffer *bytes.Buffer) error {
+func handleCommand(wf io.Writer, args [][]byte, handlers map[string]handler, ctx *context, client string, multiMode *bool, multiCounter *int, multiBuffer *bytes.Buffer) error {
 	cmd := string(args[0])
 	switch cmd {
 	case "MULTI":
@@ -371,6 +667,7 @@ func handleCommand(wf io.Writer, args [][]byte, handlers map[string]handler, ctx
 		}
 
 	default:
+		command := args
 		args = args[1:]
 		h, ok := handlers[cmd]
 		if ok {
@@ -393,7 +690,15 @@ func handleCommand(wf io.Writer, args [][]byte, handlers map[string]handler, ctx
 				}
 				targetWriter = multiBuffer
 			}
+			start := time.Now()
 			err := h.f(targetWriter, ctx, args)
+			ctx.slowlog.add(start, time.Since(start), command, client, err)
+			if err == errClientClosed {
+				return err
+			}
+			if err == errWrongType {
+				err = writeLine(targetWriter, "-"+errWrongType.Error())
+			}
 			if err != nil {
 				if !ctx.client.IsConnected() && ctx.exitOnClusterLost {
 					panic(fmt.Errorf("Connection to cluster lost: '%s'", err))
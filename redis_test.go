@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Modes of the tests, and the handlers each of them must cover.
+var testModes = []string{"standard", "expanded_map", "cdt_map"}
+
+var testModeHandlers = map[string]func() map[string]handler{
+	"standard":     standardHandlers,
+	"expanded_map": expandedMapHandlers,
+	"cdt_map":      cdtMapHandlers,
+}
+
+// Commands sent by the tests, by mode.
+var testedCommands = make(map[string]map[string]bool)
+var testedCommandsMutex sync.Mutex
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	// Like the memory mode of main, with the keys needed by RANDOMKEY
+	sendKey = true
+	nsNeverExpire = true
+	code := m.Run()
+	if code == 0 && flag.Lookup("test.run").Value.String() == "" {
+		code = checkTestedCommands()
+	}
+	os.Exit(code)
+}
+
+// Fails when a handler has not been called by any test.
+func checkTestedCommands() int {
+	code := 0
+	for _, mode := range testModes {
+		missing := make([]string, 0)
+		for cmd := range testModeHandlers[mode]() {
+			if !testedCommands[mode][cmd] {
+				missing = append(missing, cmd)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			fmt.Printf("Untested commands in %s mode: %s\n", mode, strings.Join(missing, " "))
+			code = 1
+		}
+	}
+	return code
+}
+
+type testServer struct {
+	mode    string
+	addr    string
+	ctx     *context
+	backend *memoryBackend
+	// The clock of the backend is stopped, so the ttls are exact. It is
+	// moved forward to expire the records without waiting.
+	offset int64
+}
+
+// Starts a listener on a random port, backed by a new memory backend.
+// The config is the one of a set in the configuration file.
+func startTestServer(t testing.TB, mode string, config map[string]interface{}) *testServer {
+	s := &testServer{mode: mode, backend: newMemoryBackend()}
+	start := time.Now()
+	s.backend.now = func() time.Time {
+		return start.Add(time.Duration(atomic.LoadInt64(&s.offset)))
+	}
+	m := map[string]interface{}{"set": "test_" + mode}
+	if mode != "standard" {
+		m[mode] = 1
+	}
+	for k, v := range config {
+		m[k] = v
+	}
+	ctx, handlers := setContext(s.backend, false, "test", 10, createReadPolicy(), createWritePolicyEx(-1, false), m)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The listener is never closed, handlePort does not stop on errors
+	go handlePort(ctx, l, handlers)
+	s.addr = l.Addr().String()
+	s.ctx = ctx
+	return s
+}
+
+// Moves the clock of the backend forward.
+func (s *testServer) sleep(d time.Duration) {
+	atomic.AddInt64(&s.offset, int64(d))
+}
+
+// The tests use this minimal client rather than a Go Redis client: they
+// check the raw replies, like a nil array or an integer sent as a bulk string,
+// which the clients convert. The redigo tests and the PHP integration tests
+// use a real client.
+type testClient struct {
+	t      testing.TB
+	mode   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *testServer) connect(t testing.TB) *testClient {
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	return &testClient{t, s.mode, conn, bufio.NewReader(conn)}
+}
+
+func (c *testClient) close() {
+	c.conn.Close()
+}
+
+// Sends a command as an array of bulk strings.
+func (c *testClient) send(args ...string) {
+	testedCommandsMutex.Lock()
+	if testedCommands[c.mode] == nil {
+		testedCommands[c.mode] = make(map[string]bool)
+	}
+	testedCommands[c.mode][args[0]] = true
+	testedCommandsMutex.Unlock()
+	buf := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, a := range args {
+		buf += "$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n"
+	}
+	_, err := c.conn.Write([]byte(buf))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Reads a reply, formatted as +OK, -ERR msg, :12, "bulk", nil, or [a b] for
+// the arrays.
+func (c *testClient) read() string {
+	res, err := readTestReply(c.reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return res
+}
+
+func (c *testClient) do(args ...string) string {
+	c.send(args...)
+	return c.read()
+}
+
+// Checks the server has closed the connection.
+func (c *testClient) closed() bool {
+	_, err := c.reader.ReadByte()
+	return err == io.EOF
+}
+
+func readTestReply(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	// Errors closing the connection end with \n only
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return "", fmt.Errorf("Empty reply")
+	}
+	switch line[0] {
+	case '+', '-', ':':
+		return line, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		if size < 0 {
+			return "nil", nil
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(reader, buf)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Quote(string(buf[:size])), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		if size < 0 {
+			return "nil", nil
+		}
+		elements := make([]string, size)
+		for i := range elements {
+			elements[i], err = readTestReply(reader)
+			if err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(elements, " ") + "]", nil
+	}
+	return "", fmt.Errorf("Unknown reply: %s", line)
+}
+
+// The fields of a hash are not ordered in the standard and expanded map modes,
+// so the replies of HGETALL, HKEYS and HVALS are sorted. Values must not
+// contain spaces.
+func sortReply(cmd string, res string) string {
+	if !strings.HasPrefix(res, "[") {
+		return res
+	}
+	elements := strings.Fields(strings.Trim(res, "[]"))
+	switch cmd {
+	case "HGETALL":
+		pairs := make([]string, 0, len(elements)/2)
+		for i := 0; i+1 < len(elements); i += 2 {
+			pairs = append(pairs, elements[i]+" "+elements[i+1])
+		}
+		sort.Strings(pairs)
+		return "[" + strings.Join(pairs, " ") + "]"
+	case "HKEYS", "HVALS":
+		sort.Strings(elements)
+		return "[" + strings.Join(elements, " ") + "]"
+	}
+	return res
+}
+
+// Replied to the commands called on a key of another type.
+const wrongType = "-WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// Runs commands, split on spaces, and checks their replies.
+func run(t *testing.T, c *testClient, cases [][2]string) {
+	t.Helper()
+	for _, cs := range cases {
+		args := strings.Fields(cs[0])
+		res := sortReply(args[0], c.do(args...))
+		if res != cs[1] {
+			t.Errorf("%s: got %s, expected %s", cs[0], res, cs[1])
+		}
+	}
+}
+
+// Checks an integer reply is between min and max, for the replies depending
+// on the clock of the server.
+func between(t *testing.T, c *testClient, cmd string, min int64, max int64) {
+	t.Helper()
+	res := c.do(strings.Fields(cmd)...)
+	n, err := strconv.ParseInt(strings.TrimPrefix(res, ":"), 10, 64)
+	if err != nil || n < min || n > max {
+		t.Errorf("%s: got %s, expected between %d and %d", cmd, res, min, max)
+	}
+}
+
+// Runs the test in each mode, with a new server.
+func forEachMode(t *testing.T, f func(t *testing.T, s *testServer, c *testClient)) {
+	for _, mode := range testModes {
+		t.Run(mode, func(t *testing.T) {
+			s := startTestServer(t, mode, nil)
+			c := s.connect(t)
+			defer c.close()
+			f(t, s, c)
+		})
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	s := startTestServer(t, "standard", nil)
+	c := s.connect(t)
+	defer c.close()
+
+	// Inline commands, as sent by telnet
+	_, err := c.conn.Write([]byte("SET myKey inline\r\nGET myKey\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := c.read(); res != "+OK" {
+		t.Errorf("Inline SET: got %s", res)
+	}
+	if res := c.read(); res != `"inline"` {
+		t.Errorf("Inline GET: got %s", res)
+	}
+
+	// Pipelined commands, and binary values
+	value := "toto\r\ntiti\x00\x01"
+	c.send("SET", "myKey", value)
+	c.send("GET", "myKey")
+	c.send("STRLEN", "myKey")
+	if res := c.read(); res != "+OK" {
+		t.Errorf("SET: got %s", res)
+	}
+	if res := c.read(); res != strconv.Quote(value) {
+		t.Errorf("GET: got %s", res)
+	}
+	if res := c.read(); res != ":12" {
+		t.Errorf("STRLEN: got %s", res)
+	}
+
+	// Big values are read in several chunks
+	big := strings.Repeat("0123456789", 10000)
+	if res := c.do("SET", "myKey", big); res != "+OK" {
+		t.Errorf("SET big: got %s", res)
+	}
+	if res := c.do("GET", "myKey"); res != strconv.Quote(big) {
+		t.Errorf("GET big: got %d bytes", len(res))
+	}
+
+	c.send("QUIT")
+	if !c.closed() {
+		t.Error("QUIT must close the connection")
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"MULTI", "+OK"},
+			{"EXEC", "[]"},
+			{"MULTI", "+OK"},
+			{"GET myKey", "+QUEUED"},
+			{"SET myKey toto2", "+QUEUED"},
+			{"GET myKey", "+QUEUED"},
+			{"DEL myKey", "+QUEUED"},
+			{"RPUSH myKey a", "+QUEUED"},
+			{"RPUSH myKey b", "+QUEUED"},
+			{"RPOP myKey", "+QUEUED"},
+			{"EXPIRE myKey 12", "+QUEUED"},
+			{"HSET myKey2 a 12", "+QUEUED"},
+			{"HGET myKey2 a", "+QUEUED"},
+			{"HMGET myKey2 a b", "+QUEUED"},
+			{"EXEC", `[nil +OK "toto2" :1 :1 :2 "b" :1 :1 "12" ["12" nil]]`},
+			{"TTL myKey", ":12"},
+		})
+
+		// Commands are executed when queued, DISCARD only drops their replies
+		run(t, c, [][2]string{
+			{"SET myKey 1", "+OK"},
+			{"MULTI", "+OK"},
+			{"INCR myKey", "+QUEUED"},
+			{"DISCARD", "+OK"},
+			{"GET myKey", `"2"`},
+		})
+	})
+}
+
+func TestMonitor(t *testing.T) {
+	s := startTestServer(t, "standard", nil)
+	m := s.connect(t)
+	run(t, m, [][2]string{{"MONITOR", "+OK"}})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{{"SET myKey toto", "+OK"}})
+	c.do("SET", "myKey", "a\"b\\\n\x01\xff")
+	run(t, c, [][2]string{{"GET myKey", `"a\"b\\\n\x01\xff"`}})
+	prefix := regexp.QuoteMeta("[0 " + c.conn.LocalAddr().String() + "] ")
+	for _, expected := range []string{`"SET" "myKey" "toto"`, `"SET" "myKey" "a\"b\\\n\x01\xff"`, `"GET" "myKey"`} {
+		line := m.read()
+		if !regexp.MustCompile(`^\+\d{10}\.\d{6} ` + prefix + regexp.QuoteMeta(expected) + "$").MatchString(line) {
+			t.Errorf("MONITOR: got %s, expected %s", line, expected)
+		}
+	}
+	m.send("QUIT")
+	if !m.closed() {
+		t.Error("MONITOR: the connection must be closed by QUIT")
+	}
+	for i := 0; atomic.LoadInt32(&s.ctx.monitors.count) != 0; i++ {
+		if i == 100 {
+			t.Fatal("MONITOR: the monitor is not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	run(t, c, [][2]string{{"DEL myKey", ":1"}})
+}
+
+func TestSlowlog(t *testing.T) {
+	for _, mode := range testModes {
+		s := startTestServer(t, mode, map[string]interface{}{"slowlog_log_slower_than": 0.0, "slowlog_max_len": 3.0})
+		c := s.connect(t)
+		run(t, c, [][2]string{
+			{"SLOWLOG RESET", "+OK"},
+			{"SLOWLOG LEN", ":0"},
+			{"SET myKey a", "+OK"},
+			{"GET myKey", `"a"`},
+			{"BLPOP myList 0.01", "nil"},
+			{"LPOP myList", "nil"},
+			{"GET myKey", `"a"`},
+			{"SLOWLOG LEN", ":3"},
+		})
+		prefix := `"` + c.conn.LocalAddr().String() + `" "" :0]`
+		res := c.do("SLOWLOG", "GET")
+		expected := regexp.MustCompile(`^\[\[:3 :\d+ :\d+ \["GET" "myKey"\] ` + prefix + ` \[:2 :\d+ :\d+ \["LPOP" "myList"\] ` + prefix + ` \[:1 :\d+ :\d+ \["GET" "myKey"\] ` + prefix + `\]$`)
+		if !expected.MatchString(res) {
+			t.Errorf("%s: SLOWLOG GET: got %s", mode, res)
+		}
+		res = c.do("SLOWLOG", "GET", "1")
+		if !strings.HasPrefix(res, "[[:3 ") || strings.Count(res, `["GET" "myKey"]`) != 1 {
+			t.Errorf("%s: SLOWLOG GET 1: got %s", mode, res)
+		}
+		args := []string{"MSET"}
+		for i := 0; i < 20; i++ {
+			args = append(args, fmt.Sprintf("key%d", i), strings.Repeat("x", 200))
+		}
+		c.do(args...)
+		res = c.do("SLOWLOG", "GET", "1")
+		if !strings.Contains(res, `"key0" "`+strings.Repeat("x", 128)+`... (72 more bytes)"`) || !strings.Contains(res, `"... (10 more arguments)"]`) {
+			t.Errorf("%s: SLOWLOG GET truncated: got %s", mode, res)
+		}
+		run(t, c, [][2]string{
+			{"SLOWLOG RESET", "+OK"},
+			{"SLOWLOG GET", "[]"},
+		})
+		c.close()
+	}
+	s := startTestServer(t, "standard", map[string]interface{}{"slowlog_log_slower_than": -1.0})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"GET myKey", "nil"},
+		{"SLOWLOG LEN", ":0"},
+	})
+	run(t, c, [][2]string{
+		{"SLOWLOG UNKNOWN", "-ERR unknown subcommand 'UNKNOWN'. Try SLOWLOG GET, LEN or RESET."},
+		{"SLOWLOG GET a", "-ERR value is not an integer or out of range"},
+		{"SLOWLOG GET", "[]"},
+	})
+}
+
+func TestClient(t *testing.T) {
+	s := startTestServer(t, "standard", nil)
+	c1 := s.connect(t)
+	c2 := s.connect(t)
+	defer c2.close()
+	id1 := strings.TrimPrefix(c1.do("CLIENT", "ID"), ":")
+	run(t, c1, [][2]string{
+		{"CLIENT GETNAME", "nil"},
+		{"CLIENT SETNAME worker1", "+OK"},
+		{"CLIENT GETNAME", `"worker1"`},
+		{"SET myKey a", "+OK"},
+	})
+	if res := c1.do("CLIENT", "SETNAME", "php worker"); res != "-ERR Client names cannot contain spaces, newlines or special characters." {
+		t.Errorf("CLIENT SETNAME: got %s", res)
+	}
+	line := regexp.QuoteMeta("id="+id1+" addr="+c1.conn.LocalAddr().String()+" laddr="+s.addr+" name=worker1 age=0 idle=0 flags=N db=0 set=test_standard tot-net-in=") +
+		`\d+ tot-net-out=\d+ cmd=`
+	res, _ := strconv.Unquote(c2.do("CLIENT", "LIST", "ID", id1, "0"))
+	if !regexp.MustCompile("^" + line + "client\n$").MatchString(res) {
+		t.Errorf("CLIENT LIST: got %q", res)
+	}
+	res, _ = strconv.Unquote(c2.do("CLIENT", "LIST"))
+	if !regexp.MustCompile("(^|\n)" + line + "client\n").MatchString(res) {
+		t.Errorf("CLIENT LIST: got %q", res)
+	}
+	res, _ = strconv.Unquote(c1.do("CLIENT", "INFO"))
+	if !regexp.MustCompile("^" + line + "client\n$").MatchString(res) {
+		t.Errorf("CLIENT INFO: got %q", res)
+	}
+	run(t, c2, [][2]string{
+		{"CLIENT KILL ID " + id1, ":1"},
+		{"CLIENT KILL 127.0.0.1:1", "-ERR No such client"},
+		{"CLIENT KILL ID a", "-ERR client-id should be greater than 0"},
+		{"CLIENT KILL ID", "-ERR No such client"},
+		{"CLIENT KILL ID 1 SKIPME", "-ERR syntax error"},
+		{"CLIENT UNKNOWN", "-ERR unknown subcommand 'UNKNOWN'. Try CLIENT ID, SETNAME, GETNAME, INFO, LIST, KILL, PAUSE or UNPAUSE."},
+		{"CLIENT", "-ERR wrong number of arguments for 'client' command"},
+	})
+	if !c1.closed() {
+		t.Error("CLIENT KILL: the connection must be closed")
+	}
+
+	c3 := s.connect(t)
+	run(t, c3, [][2]string{{"GET myKey", `"a"`}})
+	run(t, c2, [][2]string{{"CLIENT KILL " + c3.conn.LocalAddr().String(), "+OK"}})
+	if !c3.closed() {
+		t.Error("CLIENT KILL addr: the connection must be closed")
+	}
+	id2 := strings.TrimPrefix(c2.do("CLIENT", "ID"), ":")
+	run(t, c2, [][2]string{
+		{"CLIENT KILL ID " + id2, ":0"},
+		{"CLIENT PAUSE 200", "+OK"},
+		{"CLIENT PAUSE 200 WRITE", "-ERR only the ALL pause mode is supported"},
+	})
+	c4 := s.connect(t)
+	defer c4.close()
+	start := time.Now()
+	run(t, c4, [][2]string{{"GET myKey", `"a"`}})
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("CLIENT PAUSE: GET done after %s", d)
+	}
+	run(t, c2, [][2]string{
+		{"CLIENT PAUSE 10000", "+OK"},
+		{"CLIENT UNPAUSE", "+OK"},
+		{"GET myKey", `"a"`},
+		{"CLIENT LIST ID " + id1, `""`},
+	})
+	c2.send("CLIENT", "KILL", "ID", id2, "SKIPME", "no")
+	if !c2.closed() {
+		t.Error("CLIENT KILL SKIPME no: the connection must be closed")
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	s := startTestServer(t, "standard", map[string]interface{}{"timeout": 1.0, "tcp_keepalive": 60.0, "maxclients": 2.0})
+	c1 := s.connect(t)
+	c2 := s.connect(t)
+	defer c2.close()
+	run(t, c1, [][2]string{{"SET a 1", "+OK"}})
+	run(t, c2, [][2]string{{"GET a", `"1"`}})
+	c3 := s.connect(t)
+	if res := c3.read(); res != "-ERR max number of clients reached" {
+		t.Errorf("maxclients: got %s", res)
+	}
+	if !c3.closed() {
+		t.Error("maxclients: the connection must be closed")
+	}
+	if n := atomic.LoadUint32(&s.ctx.counterRejected); n != 1 {
+		t.Errorf("maxclients: %d rejected connections", n)
+	}
+
+	// c2 is blocked, so it is not idle
+	c2.send("BLPOP", "l", "0")
+	for i := 0; i < 3; i++ {
+		time.Sleep(400 * time.Millisecond)
+		run(t, c1, [][2]string{{"GET a", `"1"`}})
+	}
+	c1.send("RPUSH", "l", "x")
+	if res := c2.read(); res != `["l" "x"]` {
+		t.Errorf("BLPOP: got %s", res)
+	}
+	c1.read()
+	start := time.Now()
+	if !c2.closed() {
+		t.Error("timeout: the connection must be closed")
+	}
+	if d := time.Since(start); d < 800*time.Millisecond {
+		t.Errorf("timeout: closed after %s", d)
+	}
+	if n := atomic.LoadUint32(&s.ctx.counterTimeout); n < 1 {
+		t.Errorf("timeout: %d closed connections", n)
+	}
+	c1.close()
+	for atomic.LoadInt32(&s.ctx.gaugeConn) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c4 := s.connect(t)
+	defer c4.close()
+	run(t, c4, [][2]string{{"GET a", `"1"`}})
+}
+
+func TestErrors(t *testing.T) {
+	s := startTestServer(t, "standard", nil)
+	c := s.connect(t)
+	run(t, c, [][2]string{{"SET myString a", "+OK"}})
+	c.close()
+	// The messages of the Aerospike errors depend on the client version, only
+	// the start of the replies is checked
+	cases := [][]string{
+		{"UNKNOWN", "-ERR Unknown command 'UNKNOWN'"},
+		{"GET", "-ERR Wrong number of params for 'GET': 0"},
+		{"HSET myKey", "-ERR Wrong number of params for 'HSET': 1"},
+		{"EXEC", "-ERR Exec received, but no MULTI before"},
+		{"DISCARD", "-ERR Exec received, but no MULTI before"},
+		{"INCRBY myKey a", `-ERR Aerospike error: 'strconv.Atoi: parsing "a": invalid syntax'`},
+		{"LRANGE myString 0 0", "-ERR Aerospike error: "},
+		{"RPUSH myString a", "-ERR Aerospike error: "},
+	}
+	for _, cs := range cases {
+		c := s.connect(t)
+		if res := c.do(strings.Fields(cs[0])...); !strings.HasPrefix(res, cs[1]) {
+			t.Errorf("%s: got %s, expected %s", cs[0], res, cs[1])
+		}
+		if !c.closed() {
+			t.Errorf("%s: the connection must be closed", cs[0])
+		}
+		c.close()
+	}
+	if n := atomic.LoadUint32(&s.ctx.counterErr); n != uint32(len(cases)) {
+		t.Errorf("Error counter: got %d, expected %d", n, len(cases))
+	}
+
+	// Errors replied to the client keep the connection open
+	c = s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"SET myKey a", "+OK"},
+		{"LSET unknown 0 a", "-ERR no such key"},
+		{"PFADD myKey b", "-" + hllWrongTypeError},
+		{"GET myKey", `"a"`},
+	})
+}
+
+func TestWriteBack(t *testing.T) {
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	config := map[string]interface{}{
+		"write_back_target":     udp.LocalAddr().String(),
+		"write_back_setTimeout": 1,
+		"write_back_hIncrBy":    1,
+	}
+	s := startTestServer(t, "standard", config)
+	c := s.connect(t)
+	defer c.close()
+
+	receive := func() string {
+		udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, err := udp.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	run(t, c, [][2]string{{"EXPIRE my|Key 12", "+OK"}})
+	expected := `CACHE_TEST_STANDARD_my_Key|{"args":["my|Key",12],"cache_name":"CACHE_TEST_STANDARD","method":"setTimeout"}`
+	if res := receive(); res != expected {
+		t.Errorf("setTimeout: got %s, expected %s", res, expected)
+	}
+	run(t, c, [][2]string{{"HINCRBY myKey a -3", "+OK"}})
+	expected = `CACHE_TEST_STANDARD_myKey|{"args":["myKey","a",-3],"cache_name":"CACHE_TEST_STANDARD","method":"hIncrBy"}`
+	if res := receive(); res != expected {
+		t.Errorf("hIncrBy: got %s, expected %s", res, expected)
+	}
+	// Written back commands are not applied
+	run(t, c, [][2]string{{"HGET myKey a", "nil"}})
+	if n := atomic.LoadUint32(&s.ctx.counterWbOk); n != 2 {
+		t.Errorf("Write back counter: got %d, expected 2", n)
+	}
+}
+
+func TestStrictEncoding(t *testing.T) {
+	s := startTestServer(t, "standard", map[string]interface{}{"strict_encoding": 1})
+	c := s.connect(t)
+	defer c.close()
+	run(t, c, [][2]string{
+		{"SET myKey 007", "+OK"},
+		{"GET myKey", `"007"`},
+		{"SET myKey +5", "+OK"},
+		{"GET myKey", `"+5"`},
+		{"SET myKey -12", "+OK"},
+		{"INCR myKey", ":-11"},
+		{"RPUSH myList 007 12", ":2"},
+		{"LRANGE myList 0 -1", `["007" "12"]`},
+		{"HMSET myHash a 007 b +5", "+OK"},
+		{"HGETALL myHash", `["a" "007" "b" "+5"]`},
+	})
+}
+
+func TestCompression(t *testing.T) {
+	s := startTestServer(t, "standard", map[string]interface{}{"compression": "gzip", "compression_threshold": 100.0})
+	c := s.connect(t)
+	defer c.close()
+	big := strings.Repeat("compressed", 100)
+	for _, cmd := range [][]string{{"SET", "myKey", big}, {"RPUSH", "myList", big}, {"HSET", "myHash", "a", big}} {
+		c.do(cmd...)
+	}
+	run(t, c, [][2]string{
+		{"GET myKey", strconv.Quote(big)},
+		{"STRLEN myKey", ":1000"},
+		{"LPOP myList", strconv.Quote(big)},
+		{"HGET myHash a", strconv.Quote(big)},
+		{"SET myKey small", "+OK"},
+		{"GET myKey", `"small"`},
+	})
+	if atomic.LoadUint64(&s.ctx.counterCompressionIn) == 0 {
+		t.Error("Values must be compressed")
+	}
+
+	// Values looking like compressed values are read back as is
+	for _, v := range []string{compressionMagic, compressionMagic + "\x00", compressionMagic + "g" + big, compressionMagic + "\x00" + big} {
+		c.do("SET", "myKey", v)
+		c.do("RPUSH", "myList", v)
+		run(t, c, [][2]string{
+			{"GET myKey", strconv.Quote(v)},
+			{"RPOP myList", strconv.Quote(v)},
+		})
+	}
+
+	// Bitmaps are rewritten as plain blobs
+	c.do("SET", "myKey", strings.Repeat("\x01", 200))
+	run(t, c, [][2]string{
+		{"GETBIT myKey 7", ":1"},
+		{"SETBIT myKey 8 1", ":0"},
+		{"GETBIT myKey 8", ":1"},
+		{"BITCOUNT myKey", ":201"},
+		{"STRLEN myKey", ":200"},
+	})
+}
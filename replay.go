@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replays a capture file against an Aerodis. Each captured connection is
+// replayed on its own connection, and the replies are compared byte for byte
+// with the captured ones:
+//   aerodis replay -file /tmp/capture -host 127.0.0.1:6379 -speed 2
+
+type replayResult struct {
+	latencies  map[string][]time.Duration
+	mismatches map[string]int
+	examples   map[string][]string
+}
+
+func newReplayResult() *replayResult {
+	return &replayResult{make(map[string][]time.Duration), make(map[string]int), make(map[string][]string)}
+}
+
+func formatCommand(args [][]byte) string {
+	res := make([]string, len(args))
+	for i, a := range args {
+		res[i] = string(a)
+	}
+	return strconv.Quote(strings.Join(res, " "))
+}
+
+// Sends a command, and reads its reply. Aerodis closes the connection after
+// most errors, the command is sent again on a new connection if the previous
+// one has been closed.
+func replayCommand(conn *net.Conn, reader **bufio.Reader, addr string, args [][]byte) ([]byte, time.Duration, error) {
+	buf := bytes.NewBufferString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf.WriteString("$" + strconv.Itoa(len(a)) + "\r\n")
+		buf.Write(a)
+		buf.WriteString("\r\n")
+	}
+	for retry := 0; ; retry++ {
+		start := time.Now()
+		_, err := (*conn).Write(buf.Bytes())
+		if err == nil {
+			var reply []byte
+			reply, err = readReply(*reader)
+			if err == nil {
+				return reply, time.Since(start), nil
+			}
+		}
+		if retry > 0 {
+			return nil, 0, err
+		}
+		(*conn).Close()
+		*conn, err = net.Dial("tcp", addr)
+		if err != nil {
+			return nil, 0, err
+		}
+		*reader = bufio.NewReader(*conn)
+	}
+}
+
+func replayConnection(addr string, commands []*capturedCommand, start time.Time, speed float64, examples int, res *replayResult) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for _, c := range commands {
+		if speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(c.at) / speed))))
+		}
+		reply, latency, err := replayCommand(&conn, &reader, addr, c.args)
+		if err != nil {
+			return errors.New("Unable to replay " + formatCommand(c.args) + ": " + err.Error())
+		}
+		name := strings.ToUpper(string(c.args[0]))
+		res.latencies[name] = append(res.latencies[name], latency)
+		if len(c.reply) > 0 && !bytes.Equal(reply, c.reply) {
+			res.mismatches[name]++
+			if len(res.examples[name]) < examples {
+				res.examples[name] = append(res.examples[name], formatCommand(c.args)+": captured "+strconv.Quote(string(c.reply))+", got "+strconv.Quote(string(reply)))
+			}
+		}
+	}
+	return nil
+}
+
+// Entry point of the replay subcommand. Fails if a reply does not match.
+func replay(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := flags.String("file", "", "Capture file to replay")
+	host := flags.String("host", "127.0.0.1:6379", "Address of the Redis interface to replay the capture to")
+	speed := flags.Float64("speed", 1, "Speed of the replay, 1 for the original speed, 2 to go twice faster, 0 to send the commands without waiting, keeping only the order of the commands of each connection")
+	examples := flags.Int("examples", 3, "Number of mismatching replies displayed for each command")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("No capture file, use -file")
+	}
+	if *speed < 0 {
+		return errors.New("The speed must be positive")
+	}
+	commands, err := readCapture(*file)
+	if err != nil {
+		return err
+	}
+	connections := make(map[uint64][]*capturedCommand)
+	for _, c := range commands {
+		connections[c.connection] = append(connections[c.connection], c)
+	}
+	fmt.Fprintf(out, "Replay of %d commands on %d connections from %s to %s, speed %g\n", len(commands), len(connections), *file, *host, *speed)
+
+	results := make([]*replayResult, 0, len(connections))
+	errs := make([]error, len(connections))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, commands := range connections {
+		res := newReplayResult()
+		results = append(results, res)
+		wg.Add(1)
+		go func(i int, commands []*capturedCommand) {
+			defer wg.Done()
+			errs[i] = replayConnection(*host, commands, start, *speed, *examples, res)
+		}(len(results)-1, commands)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	latencies := make(map[string][]time.Duration)
+	mismatches := make(map[string]int)
+	examplesByCommand := make(map[string][]string)
+	all := make([]time.Duration, 0, len(commands))
+	allMismatches := 0
+	for _, res := range results {
+		for name, l := range res.latencies {
+			latencies[name] = append(latencies[name], l...)
+			all = append(all, l...)
+		}
+		for name, n := range res.mismatches {
+			mismatches[name] += n
+			allMismatches += n
+		}
+		for name, e := range res.examples {
+			examplesByCommand[name] = append(examplesByCommand[name], e...)
+		}
+	}
+	fmt.Fprintf(out, "%d commands in %.2f s, %d mismatching replies\n", len(all), elapsed.Seconds(), allMismatches)
+	if len(all) == 0 {
+		return nil
+	}
+	writeLatencyHeader(out, "mismatches")
+	names := make([]string, 0, len(latencies))
+	for name := range latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeLatencyStats(out, name, latencies[name], mismatches[name])
+	}
+	writeLatencyStats(out, "all", all, allMismatches)
+	for _, name := range names {
+		e := examplesByCommand[name]
+		if len(e) > *examples {
+			e = e[:*examples]
+		}
+		for _, x := range e {
+			fmt.Fprintf(out, "Mismatch %s\n", x)
+		}
+	}
+	if allMismatches > 0 {
+		return fmt.Errorf("%d mismatching replies", allMismatches)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultScanCount = 10
+
+type scanArgs struct {
+	cursor int
+	match  []byte
+	count  int
+}
+
+func parseScanArgs(args [][]byte) (*scanArgs, error) {
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil || cursor < 0 {
+		return nil, fmt.Errorf("Invalid cursor '%s'", string(args[0]))
+	}
+	res := &scanArgs{cursor, nil, defaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("Wrong number of params for scan: %d", len(args))
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			res.match = args[i+1]
+		case "COUNT":
+			res.count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, err
+			}
+			if res.count < 1 {
+				return nil, fmt.Errorf("Invalid count %d", res.count)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown scan option '%s'", string(args[i]))
+		}
+	}
+	return res, nil
+}
+
+func (s *scanArgs) matches(field []byte) bool {
+	return s.match == nil || matchPattern(s.match, field)
+}
+
+// Names are scanned in the order of their hash, and the cursor is the hash of
+// the next name to return, like the buckets of Redis: deleting a field
+// between two calls does not make the scan skip other fields.
+func cursorOf(name string) int {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int(h.Sum64()>>2) + 1
+}
+
+// Returns the next cursor, and the matching names of the page selected by the
+// cursor. The names are sorted in place.
+func (s *scanArgs) page(names []string) (int, []string) {
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := cursorOf(names[i]), cursorOf(names[j])
+		return ci < cj || (ci == cj && names[i] < names[j])
+	})
+	start := sort.Search(len(names), func(i int) bool {
+		return cursorOf(names[i]) >= s.cursor
+	})
+	end := start + s.count
+	if end >= len(names) {
+		return 0, s.filter(names[start:])
+	}
+	// Names with the same hash are in the same page, so the next cursor is
+	// after all the returned names.
+	for cursorOf(names[end]) == cursorOf(names[end-1]) {
+		end++
+		if end == len(names) {
+			return 0, s.filter(names[start:])
+		}
+	}
+	return cursorOf(names[end]), s.filter(names[start:end])
+}
+
+func (s *scanArgs) filter(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, e := range names {
+		if s.matches([]byte(e)) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Writes a scan reply. Items are field / value pairs when values is not nil.
+func writeScanResult(wf io.Writer, cursor int, fields [][]byte, values []interface{}) error {
+	var bufs [][]byte
+	if values != nil {
+		var err error
+		bufs, err = decodeValues(values)
+		if err != nil {
+			return err
+		}
+	}
+	err := writeLine(wf, "*2")
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, []byte(strconv.Itoa(cursor)))
+	if err != nil {
+		return err
+	}
+	l := len(fields)
+	if values != nil {
+		l *= 2
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(l))
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		err = writeByteArray(wf, f)
+		if err != nil {
+			return err
+		}
+		if values != nil {
+			err = writeByteArray(wf, bufs[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Glob-style matching, with the same syntax as Redis: *, ?, [abc], [^abc],
+// [a-z] and \ to escape special chars.
+func matchPattern(pattern []byte, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := bytes.IndexByte(pattern[1:], ']')
+			if end == -1 {
+				if s[0] != '[' {
+					return false
+				}
+				s = s[1:]
+				break
+			}
+			class := pattern[1 : end+1]
+			pattern = pattern[end+1:]
+			not := len(class) > 0 && class[0] == '^'
+			if not {
+				class = class[1:]
+			}
+			match := false
+			for i := 0; i < len(class); i++ {
+				if class[i] == '\\' && i+1 < len(class) {
+					i++
+					match = match || class[i] == s[0]
+				} else if i+2 < len(class) && class[i+1] == '-' {
+					start, stop := class[i], class[i+2]
+					if start > stop {
+						start, stop = stop, start
+					}
+					match = match || (s[0] >= start && s[0] <= stop)
+					i += 2
+				} else {
+					match = match || class[i] == s[0]
+				}
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Same defaults and limits as Redis
+const defaultSlowlogThreshold = 10 * time.Millisecond
+const defaultSlowlogMaxLen = 128
+const slowlogMaxArgs = 32
+const slowlogMaxArgLen = 128
+
+type slowlogEntry struct {
+	id         int64
+	at         time.Time
+	duration   time.Duration
+	args       [][]byte
+	client     string
+	resultCode ase.ResultCode
+}
+
+// Ring buffer of the commands slower than the threshold, a negative threshold
+// disables it.
+type slowlog struct {
+	lock      sync.Mutex
+	threshold time.Duration
+	entries   []*slowlogEntry
+	next      int
+	count     int
+	nextID    int64
+}
+
+func newSlowlog(threshold time.Duration, maxLen int) *slowlog {
+	return &slowlog{threshold: threshold, entries: make([]*slowlogEntry, maxLen)}
+}
+
+// Arguments are truncated like Redis does, to limit the memory used.
+func slowlogArgs(args [][]byte) [][]byte {
+	n := len(args)
+	if n > slowlogMaxArgs {
+		n = slowlogMaxArgs
+	}
+	res := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			res[i] = []byte("... (" + strconv.Itoa(len(args)-slowlogMaxArgs+1) + " more arguments)")
+		} else if len(args[i]) > slowlogMaxArgLen {
+			res[i] = []byte(string(args[i][:slowlogMaxArgLen]) + "... (" + strconv.Itoa(len(args[i])-slowlogMaxArgLen) + " more bytes)")
+		} else {
+			res[i] = append([]byte(nil), args[i]...)
+		}
+	}
+	return res
+}
+
+// Blocking commands are not logged, their duration is mostly the time spent
+// waiting, neither is SLOWLOG.
+func slowlogIgnored(args [][]byte) bool {
+	switch string(args[0]) {
+	case "SLOWLOG", "BLPOP", "BRPOP", "BLMOVE", "BRPOPLPUSH":
+		return true
+	case "XREAD", "XREADGROUP":
+		for _, a := range args[1:] {
+			if strings.ToUpper(string(a)) == "BLOCK" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *slowlog) add(at time.Time, duration time.Duration, args [][]byte, client string, err error) {
+	if s.threshold < 0 || duration < s.threshold || len(s.entries) == 0 || slowlogIgnored(args) {
+		return
+	}
+	resultCode := ase.OK
+	if err != nil {
+		resultCode = errResultCode(err)
+	}
+	entry := &slowlogEntry{0, at, duration, slowlogArgs(args), client, resultCode}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry.id = s.nextID
+	s.nextID++
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % len(s.entries)
+	if s.count < len(s.entries) {
+		s.count++
+	}
+}
+
+// Returns the n last entries, the newest first, all of them if n is negative.
+func (s *slowlog) get(n int) []*slowlogEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n < 0 || n > s.count {
+		n = s.count
+	}
+	res := make([]*slowlogEntry, n)
+	for i := 0; i < n; i++ {
+		res[i] = s.entries[(s.next-1-i+len(s.entries))%len(s.entries)]
+	}
+	return res
+}
+
+func (s *slowlog) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.count
+}
+
+func (s *slowlog) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.entries {
+		s.entries[i] = nil
+	}
+	s.next = 0
+	s.count = 0
+}
+
+// Entries have the Redis fields: id, timestamp, duration in microseconds,
+// arguments, client address and client name, then the Aerospike result code.
+func writeSlowlogEntry(wf io.Writer, e *slowlogEntry) error {
+	lines := []string{"*7", ":" + strconv.FormatInt(e.id, 10), ":" + strconv.FormatInt(e.at.Unix(), 10),
+		":" + strconv.FormatInt(int64(e.duration/time.Microsecond), 10), "*" + strconv.Itoa(len(e.args))}
+	for _, l := range lines {
+		err := writeLine(wf, l)
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range e.args {
+		err := writeByteArray(wf, a)
+		if err != nil {
+			return err
+		}
+	}
+	err := writeByteArray(wf, []byte(e.client))
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, nil)
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, ":"+strconv.Itoa(int(e.resultCode)))
+}
+
+func cmdSLOWLOG(wf io.Writer, ctx *context, args [][]byte) error {
+	switch strings.ToUpper(string(args[0])) {
+	case "GET":
+		n := 10
+		if len(args) > 1 {
+			var err error
+			n, err = strconv.Atoi(string(args[1]))
+			if err != nil {
+				return writeErrorReply(wf, "value is not an integer or out of range")
+			}
+		}
+		entries := ctx.slowlog.get(n)
+		err := writeLine(wf, "*"+strconv.Itoa(len(entries)))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			err := writeSlowlogEntry(wf, e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "LEN":
+		return writeLine(wf, ":"+strconv.Itoa(ctx.slowlog.len()))
+	case "RESET":
+		ctx.slowlog.reset()
+		return writeLine(wf, "+OK")
+	}
+	return writeErrorReply(wf, "unknown subcommand '"+string(args[0])+"'. Try SLOWLOG GET, LEN or RESET.")
+}
@@ -45,5 +45,18 @@ func statsd(target string, ctx *context) {
 		udpSend(conn, start+"ops,type=wbok"+end+":"+strconv.Itoa(int(wbOk))+"|c")
 		udpSend(conn, start+"ops,type=err"+end+":"+strconv.Itoa(int(err))+"|c")
 		udpSend(conn, start+"conn"+end+":"+strconv.Itoa(int(c))+"|g")
+		rejected := atomic.SwapUint32(&(*ctx).counterRejected, 0)
+		timeout := atomic.SwapUint32(&(*ctx).counterTimeout, 0)
+		udpSend(conn, start+"conn_closed,type=rejected"+end+":"+strconv.Itoa(int(rejected))+"|c")
+		udpSend(conn, start+"conn_closed,type=timeout"+end+":"+strconv.Itoa(int(timeout))+"|c")
+		if ctx.compression != compressionNone {
+			in := atomic.SwapUint64(&(*ctx).counterCompressionIn, 0)
+			out := atomic.SwapUint64(&(*ctx).counterCompressionOut, 0)
+			udpSend(conn, start+"compression,type=in"+end+":"+strconv.FormatUint(in, 10)+"|c")
+			udpSend(conn, start+"compression,type=out"+end+":"+strconv.FormatUint(out, 10)+"|c")
+			if in > 0 {
+				udpSend(conn, start+"compression_ratio"+end+":"+strconv.FormatFloat(float64(out)/float64(in), 'f', 3, 64)+"|g")
+			}
+		}
 	}
 }
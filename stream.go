@@ -0,0 +1,1207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// A stream is stored in a key ordered map bin, from the entry ID to the list
+// of fields and values of the entry. IDs are stored with a fixed width, so
+// the order of the map is the order of the IDs, and ranges of IDs are read
+// by the Aerospike server.
+//
+// Each consumer group has its own record, holding the last delivered ID and
+// the pending entries list. Groups are tied to the stream by a random uid
+// generated when the stream is created: the groups of a deleted stream are
+// ignored, and overwritten when a group with the same name is created.
+
+const streamBin = "__stream__"
+const streamLastIDBin = "__last_id__"
+const streamUIDBin = "__stream_uid__"
+
+const streamGroupSuffix = "__xgroup__"
+const groupUIDBin = "uid"
+const groupLastIDBin = "last_id"
+const groupPendingBin = "pending"
+const groupConsumersBin = "consumers"
+
+var streamMapPolicy = newMapPolicy(false, false)
+var streamMapCreateOnlyPolicy = newMapPolicy(false, true)
+
+var errNoGroup = errors.New("No such key or consumer group")
+
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+// Key of the entry in the stream map
+func (id streamID) key() string {
+	return fmt.Sprintf("%020d-%020d", id.ms, id.seq)
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// Returns the next ID, false if id is the last possible ID.
+func (id streamID) next() (streamID, bool) {
+	if id.seq < math.MaxUint64 {
+		return streamID{id.ms, id.seq + 1}, true
+	}
+	if id.ms < math.MaxUint64 {
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+func streamIDFromKey(k interface{}) streamID {
+	s, _ := k.(string)
+	i := strings.IndexByte(s, '-')
+	if i == -1 {
+		return streamID{}
+	}
+	ms, _ := strconv.ParseUint(s[:i], 10, 64)
+	seq, _ := strconv.ParseUint(s[i+1:], 10, 64)
+	return streamID{ms, seq}
+}
+
+// Parses an ID, given as ms-seq or ms. The sequence is missingSeq when only
+// the milliseconds are given. - and + are the first and the last IDs.
+func parseStreamID(buf []byte, missingSeq uint64) (streamID, error) {
+	s := string(buf)
+	if s == "-" {
+		return streamID{}, nil
+	}
+	if s == "+" {
+		return maxStreamID, nil
+	}
+	i := strings.IndexByte(s, '-')
+	if i == -1 {
+		ms, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return streamID{}, errors.New("Invalid stream ID specified as stream command argument")
+		}
+		return streamID{ms, missingSeq}, nil
+	}
+	ms, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return streamID{}, errors.New("Invalid stream ID specified as stream command argument")
+	}
+	seq, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return streamID{}, errors.New("Invalid stream ID specified as stream command argument")
+	}
+	return streamID{ms, seq}, nil
+}
+
+// Returns the map keys bounding an inclusive range of IDs, the end being
+// exclusive. Bounds prefixed by ( are exclusive, like in Redis. A nil bound
+// is the start or the end of the stream. Returns false if the range is empty.
+func streamKeyRange(startBuf []byte, endBuf []byte) (interface{}, interface{}, bool, error) {
+	var begin, end interface{}
+	exclusive := len(startBuf) > 0 && startBuf[0] == '('
+	if exclusive {
+		startBuf = startBuf[1:]
+	}
+	start, err := parseStreamID(startBuf, 0)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if exclusive {
+		var ok bool
+		start, ok = start.next()
+		if !ok {
+			return nil, nil, false, nil
+		}
+	}
+	if string(startBuf) != "-" || exclusive {
+		begin = start.key()
+	}
+	exclusive = len(endBuf) > 0 && endBuf[0] == '('
+	if exclusive {
+		endBuf = endBuf[1:]
+	}
+	stop, err := parseStreamID(endBuf, math.MaxUint64)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if exclusive {
+		end = stop.key()
+	} else if next, ok := stop.next(); ok && string(endBuf) != "+" {
+		end = next.key()
+	}
+	if end != nil && begin != nil && begin.(string) >= end.(string) {
+		return nil, nil, false, nil
+	}
+	return begin, end, true, nil
+}
+
+func nowMillis() int {
+	return int(time.Now().UnixNano() / int64(time.Millisecond))
+}
+
+// Reads the entries of a stream between two map keys. If count is positive,
+// reads at most count entries, the first ones, or the last ones if last is
+// set. The count is applied server side from one bound of the range, by
+// index when it is unbounded, and the entries beyond the other bound are
+// dropped.
+func streamRange(ctx *context, key *as.Key, begin interface{}, end interface{}, count int, last bool) ([]interface{}, []interface{}, error) {
+	read := func(returnType mapReturn) *operation {
+		switch {
+		case count <= 0:
+			return mapGetByKeyRangeOp(streamBin, begin, end, returnType)
+		case !last && begin == nil:
+			return mapGetByIndexRangeCountOp(streamBin, 0, count, returnType)
+		case !last:
+			return mapGetByKeyRelativeIndexRangeCountOp(streamBin, begin, 0, count, returnType)
+		case end == nil:
+			return mapGetByIndexRangeCountOp(streamBin, -count, count, returnType)
+		}
+		return mapGetByKeyRelativeIndexRangeCountOp(streamBin, end, -count, count, returnType)
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapSizeOp(streamBin), read(returnKey), read(returnValue))
+	if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	res := binResults(rec, streamBin, 3)
+	ids, _ := res[1].([]interface{})
+	entries, _ := res[2].([]interface{})
+	if count <= 0 {
+		return ids, entries, nil
+	}
+	var inIDs, inEntries []interface{}
+	for i, id := range ids {
+		s, _ := id.(string)
+		if (begin == nil || s >= begin.(string)) && (end == nil || s < end.(string)) {
+			inIDs = append(inIDs, id)
+			inEntries = append(inEntries, entries[i])
+		}
+	}
+	return inIDs, inEntries, nil
+}
+
+// Writes a list of entries. Entries deleted from the stream are nil.
+func writeStreamEntries(wf io.Writer, ids []interface{}, entries []interface{}) error {
+	err := writeLine(wf, "*"+strconv.Itoa(len(ids)))
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		err = writeLine(wf, "*2")
+		if err != nil {
+			return err
+		}
+		err = writeByteArray(wf, []byte(streamIDFromKey(id).String()))
+		if err != nil {
+			return err
+		}
+		if entries[i] == nil {
+			err = writeLine(wf, "*-1")
+		} else {
+			err = writeArray(wf, entries[i].([]interface{}))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type streamTrim struct {
+	maxLen int
+	minID  *streamID
+}
+
+// Parses MAXLEN / MINID [=|~] threshold [LIMIT count]. Trimming is always
+// exact, LIMIT is accepted and ignored.
+func parseStreamTrim(args [][]byte) (*streamTrim, int, error) {
+	strategy := strings.ToUpper(string(args[0]))
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return nil, 0, nil
+	}
+	i := 1
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, errors.New("syntax error")
+	}
+	trim := &streamTrim{maxLen: -1}
+	if strategy == "MAXLEN" {
+		n, err := strconv.Atoi(string(args[i]))
+		if err != nil || n < 0 {
+			return nil, 0, errors.New("The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = n
+	} else {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		trim.minID = &id
+	}
+	i++
+	if i+1 < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// Returns the operation trimming a stream of the given size.
+func (t *streamTrim) op(size int) *operation {
+	if t.minID != nil {
+		return mapRemoveByKeyRangeOp(streamBin, nil, t.minID.key(), returnCount)
+	}
+	if size <= t.maxLen {
+		return nil
+	}
+	return mapRemoveByIndexRangeCountOp(streamBin, 0, size-t.maxLen, returnCount)
+}
+
+// Reads the last ID and the size of a stream, with the generation of its
+// record. The record is nil if the stream does not exist.
+func streamHeader(ctx *context, key *as.Key) (streamID, int, *as.Record, error) {
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, getOpForBin(streamLastIDBin), getOpForBin(streamUIDBin), mapSizeOp(streamBin))
+	if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+		return streamID{}, 0, nil, nil
+	}
+	if err != nil || rec == nil {
+		return streamID{}, 0, nil, err
+	}
+	size, _ := rec.Bins[streamBin].(int)
+	return streamIDFromKey(rec.Bins[streamLastIDBin]), size, rec, nil
+}
+
+// Returns the ID of the new entry, nil if the stream does not exist and
+// NOMKSTREAM is given.
+func tryXAdd(ctx *context, key *as.Key, idBuf []byte, entry []interface{}, trim *streamTrim, noMkStream bool) (*streamID, error) {
+	last, size, rec, err := streamHeader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil && noMkStream {
+		return nil, nil
+	}
+	var id streamID
+	s := string(idBuf)
+	if s == "*" || strings.HasSuffix(s, "-*") {
+		ms := uint64(nowMillis())
+		if s != "*" {
+			ms, err = strconv.ParseUint(strings.TrimSuffix(s, "-*"), 10, 64)
+			if err != nil {
+				return nil, errors.New("Invalid stream ID specified as stream command argument")
+			}
+		}
+		if ms < last.ms {
+			ms = last.ms
+		}
+		id = streamID{ms, 0}
+		if ms == 0 {
+			id.seq = 1
+		}
+		if ms == last.ms && rec != nil {
+			var ok bool
+			id, ok = last.next()
+			if !ok || id.ms != ms {
+				return nil, errors.New("The stream has exhausted the last possible ID, unable to add more items")
+			}
+		}
+	} else {
+		id, err = parseStreamID(idBuf, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if id == (streamID{}) {
+		return nil, errors.New("The ID specified in XADD must be greater than 0-0")
+	}
+	if rec != nil && !last.less(id) {
+		return nil, errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	ops := []*operation{
+		mapPutOp(streamMapPolicy, streamBin, id.key(), entry),
+		putOp(as.NewBin(streamLastIDBin, id.key())),
+	}
+	policy := createWritePolicyEx(-1, true)
+	if rec != nil {
+		policy = createWritePolicyGeneration(rec.Generation, -1)
+	} else {
+		ops = append(ops, putOp(as.NewBin(streamUIDBin, rand.Int63())))
+	}
+	if trim != nil {
+		op := trim.op(size + 1)
+		if op != nil {
+			ops = append(ops, op)
+		}
+	}
+	_, err = ctx.client.Operate(policy, key, ops...)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func cmdXADD(wf io.Writer, ctx *context, args [][]byte) error {
+	noMkStream := false
+	var trim *streamTrim
+	i := 1
+	for i < len(args) {
+		if strings.ToUpper(string(args[i])) == "NOMKSTREAM" {
+			noMkStream = true
+			i++
+			continue
+		}
+		t, n, err := parseStreamTrim(args[i:])
+		if err != nil {
+			return writeErrorReply(wf, err.Error())
+		}
+		if t == nil {
+			break
+		}
+		trim = t
+		i += n
+	}
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return errors.New("Wrong number of params for xadd")
+	}
+	idBuf := args[i]
+	entry := make([]interface{}, len(args)-i-1)
+	for j, e := range args[i+1:] {
+		if j%2 == 0 {
+			entry[j] = e
+		} else {
+			entry[j] = encode(ctx, e)
+		}
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for j := 0; j < ctx.generationRetries; j++ {
+		id, err := tryXAdd(ctx, key, idBuf, entry, trim, noMkStream)
+		code := errResultCode(err)
+		if err == nil {
+			if id == nil {
+				return writeLine(wf, "$-1")
+			}
+			ctx.listWaiters.notify(args[0])
+			return writeByteArray(wf, []byte(id.String()))
+		}
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			if _, ok := err.(ase.AerospikeError); ok {
+				return err
+			}
+			return writeErrorReply(wf, err.Error())
+		}
+	}
+	return errors.New("Too many retry for xadd")
+}
+
+func cmdXLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, size, _, err := streamHeader(ctx, key)
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, ":"+strconv.Itoa(size))
+}
+
+func parseCount(args [][]byte) (int, error) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	if len(args) != 2 || strings.ToUpper(string(args[0])) != "COUNT" {
+		return 0, errors.New("syntax error")
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func xrange(wf io.Writer, ctx *context, k []byte, start []byte, end []byte, countArgs [][]byte, reverse bool) error {
+	count, err := parseCount(countArgs)
+	if err != nil {
+		return err
+	}
+	begin, stop, ok, err := streamKeyRange(start, end)
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	if !ok || count == 0 {
+		return writeLine(wf, "*0")
+	}
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return err
+	}
+	ids, entries, err := streamRange(ctx, key, begin, stop, count, reverse)
+	if err != nil {
+		return err
+	}
+	if reverse {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return writeStreamEntries(wf, ids, entries)
+}
+
+func cmdXRANGE(wf io.Writer, ctx *context, args [][]byte) error {
+	return xrange(wf, ctx, args[0], args[1], args[2], args[3:], false)
+}
+
+func cmdXREVRANGE(wf io.Writer, ctx *context, args [][]byte) error {
+	return xrange(wf, ctx, args[0], args[2], args[1], args[3:], true)
+}
+
+func cmdXDEL(wf io.Writer, ctx *context, args [][]byte) error {
+	ids := make([]interface{}, len(args)-1)
+	for i, e := range args[1:] {
+		id, err := parseStreamID(e, 0)
+		if err != nil {
+			return writeErrorReply(wf, err.Error())
+		}
+		ids[i] = id.key()
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(ctx.writePolicy, key, mapRemoveByKeyListOp(streamBin, ids, returnCount))
+	if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+		return writeLine(wf, ":0")
+	}
+	if err != nil {
+		return err
+	}
+	return writeBinInt(wf, rec, streamBin)
+}
+
+func tryXTrim(ctx *context, key *as.Key, trim *streamTrim) (int, error) {
+	_, size, rec, err := streamHeader(ctx, key)
+	if err != nil || rec == nil {
+		return 0, err
+	}
+	op := trim.op(size)
+	if op == nil {
+		return 0, nil
+	}
+	rec, err = ctx.client.Operate(createWritePolicyGeneration(rec.Generation, -1), key, op)
+	if err != nil {
+		return 0, err
+	}
+	removed, _ := rec.Bins[streamBin].(int)
+	return removed, nil
+}
+
+func cmdXTRIM(wf io.Writer, ctx *context, args [][]byte) error {
+	trim, n, err := parseStreamTrim(args[1:])
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	if trim == nil || n != len(args)-1 {
+		return writeErrorReply(wf, "syntax error")
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		removed, err := tryXTrim(ctx, key, trim)
+		if err == nil {
+			return writeLine(wf, ":"+strconv.Itoa(removed))
+		}
+		if errResultCode(err) != ase.GENERATION_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for xtrim")
+}
+
+type streamRead struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    [][]byte
+	ids     [][]byte
+}
+
+// Parses [COUNT n] [BLOCK ms] [NOACK] STREAMS key... id...
+func parseStreamRead(args [][]byte, group bool) (*streamRead, error) {
+	r := &streamRead{count: -1}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, errors.New("syntax error")
+			}
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				r.count = count
+			}
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, errors.New("syntax error")
+			}
+			ms, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || ms < 0 {
+				return nil, errors.New("timeout is negative")
+			}
+			r.block = true
+			r.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "NOACK":
+			if !group {
+				return nil, errors.New("syntax error")
+			}
+			r.noAck = true
+		case "STREAMS":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return nil, errors.New("Unbalanced XREAD list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			r.keys = streams[:len(streams)/2]
+			r.ids = streams[len(streams)/2:]
+			return r, nil
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+	return nil, errors.New("syntax error")
+}
+
+type streamReadResult struct {
+	key     []byte
+	ids     []interface{}
+	entries []interface{}
+}
+
+func writeStreamReadResults(wf io.Writer, res []streamReadResult) error {
+	if len(res) == 0 {
+		return writeLine(wf, "*-1")
+	}
+	err := writeLine(wf, "*"+strconv.Itoa(len(res)))
+	if err != nil {
+		return err
+	}
+	for _, e := range res {
+		err = writeLine(wf, "*2")
+		if err != nil {
+			return err
+		}
+		err = writeByteArray(wf, e.key)
+		if err != nil {
+			return err
+		}
+		err = writeStreamEntries(wf, e.ids, e.entries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the streams, calling f for each key. When no stream has entries and
+// BLOCK is given, waits for new entries. Like for the blocking list pops,
+// only the first stream with entries is returned after waiting.
+func readStreams(wf io.Writer, ctx *context, r *streamRead, f func(int) (*streamReadResult, error)) error {
+	res := make([]streamReadResult, 0)
+	for i := range r.keys {
+		e, err := f(i)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			res = append(res, *e)
+		}
+	}
+	if len(res) > 0 || !r.block {
+		return writeStreamReadResults(wf, res)
+	}
+	index := make(map[string]int)
+	for i, k := range r.keys {
+		index[string(k)] = i
+	}
+	_, value, err := waitForValue(wf, ctx, r.keys, r.timeout, func(k []byte) (interface{}, error) {
+		e, err := f(index[string(k)])
+		if e == nil || err != nil {
+			return nil, err
+		}
+		return e, nil
+	})
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return writeLine(wf, "*-1")
+	}
+	return writeStreamReadResults(wf, []streamReadResult{*value.(*streamReadResult)})
+}
+
+func cmdXREAD(wf io.Writer, ctx *context, args [][]byte) error {
+	r, err := parseStreamRead(args, false)
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	keys := make([]*as.Key, len(r.keys))
+	begins := make([]interface{}, len(r.keys))
+	for i, k := range r.keys {
+		keys[i], err = buildKey(ctx, k)
+		if err != nil {
+			return err
+		}
+		var id streamID
+		if string(r.ids[i]) == "$" {
+			id, _, _, err = streamHeader(ctx, keys[i])
+			if err != nil {
+				return err
+			}
+		} else {
+			id, err = parseStreamID(r.ids[i], 0)
+			if err != nil {
+				return writeErrorReply(wf, err.Error())
+			}
+		}
+		next, ok := id.next()
+		if ok {
+			begins[i] = next.key()
+		}
+	}
+	return readStreams(wf, ctx, r, func(i int) (*streamReadResult, error) {
+		if begins[i] == nil {
+			return nil, nil
+		}
+		ids, entries, err := streamRange(ctx, keys[i], begins[i], nil, r.count, false)
+		if err != nil || len(ids) == 0 {
+			return nil, err
+		}
+		return &streamReadResult{r.keys[i], ids, entries}, nil
+	})
+}
+
+func groupKey(ctx *context, k []byte, group []byte) (*as.Key, error) {
+	return buildKey(ctx, []byte(string(k)+streamGroupSuffix+string(group)))
+}
+
+// Reads a consumer group record. The record is nil if the group does not
+// exist, or belongs to a deleted stream.
+func streamGroup(ctx *context, k []byte, group []byte) (*as.Key, *as.Record, error) {
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return nil, nil, err
+	}
+	gKey, err := groupKey(ctx, k, group)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := ctx.client.Get(createMasterReadPolicy(), key, streamUIDBin)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, err := ctx.client.Get(createMasterReadPolicy(), gKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil || rec == nil || stream.Bins[streamUIDBin] == nil || rec.Bins[groupUIDBin] != stream.Bins[streamUIDBin] {
+		return gKey, nil, nil
+	}
+	return gKey, rec, nil
+}
+
+func writeNoGroup(wf io.Writer, k []byte, group []byte, cmd string) error {
+	return writeLine(wf, "-NOGROUP No such key '"+string(k)+"' or consumer group '"+string(group)+"' in "+cmd)
+}
+
+func groupPending(rec *as.Record) map[interface{}]interface{} {
+	pending, _ := rec.Bins[groupPendingBin].(map[interface{}]interface{})
+	return pending
+}
+
+// Returns the ID given to XGROUP, resolving $ to the last ID of the stream.
+func groupStartID(ctx *context, k []byte, buf []byte) (streamID, error) {
+	if string(buf) != "$" {
+		return parseStreamID(buf, 0)
+	}
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return streamID{}, err
+	}
+	last, _, _, err := streamHeader(ctx, key)
+	return last, err
+}
+
+func xgroupCreate(wf io.Writer, ctx *context, args [][]byte) error {
+	mkStream := len(args) > 3 && strings.ToUpper(string(args[3])) == "MKSTREAM"
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, _, stream, err := streamHeader(ctx, key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		if !mkStream {
+			return writeErrorReply(wf, "The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		err = ctx.client.PutBins(createWritePolicyEx(-1, true), key, as.NewBin(streamUIDBin, rand.Int63()), as.NewBin(streamLastIDBin, streamID{}.key()))
+		if err != nil && errResultCode(err) != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	id, err := groupStartID(ctx, args[0], args[2])
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	gKey, group, err := streamGroup(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if group != nil {
+		return writeLine(wf, "-BUSYGROUP Consumer Group name already exists")
+	}
+	stream, err = ctx.client.Get(createMasterReadPolicy(), key, streamUIDBin)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return writeErrorReply(wf, "The XGROUP subcommand requires the key to exist.")
+	}
+	// Overwrites the group of a deleted stream
+	old, err := ctx.client.GetHeader(createMasterReadPolicy(), gKey)
+	if err != nil {
+		return err
+	}
+	policy := createWritePolicyEx(-1, true)
+	if old != nil {
+		policy = createWritePolicyGeneration(old.Generation, -1)
+	}
+	err = ctx.client.PutBins(policy, gKey,
+		as.NewBin(groupUIDBin, stream.Bins[streamUIDBin]),
+		as.NewBin(groupLastIDBin, id.key()),
+		as.NewBin(groupPendingBin, nil),
+		as.NewBin(groupConsumersBin, nil),
+	)
+	code := errResultCode(err)
+	if code == ase.GENERATION_ERROR || code == ase.KEY_EXISTS_ERROR {
+		return writeLine(wf, "-BUSYGROUP Consumer Group name already exists")
+	}
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, "+OK")
+}
+
+func tryXGroupDelConsumer(ctx *context, gKey *as.Key, rec *as.Record, consumer string) (int, error) {
+	ids := make([]interface{}, 0)
+	for id, e := range groupPending(rec) {
+		if e.([]interface{})[0] == consumer {
+			ids = append(ids, id)
+		}
+	}
+	_, err := ctx.client.Operate(createWritePolicyGeneration(rec.Generation, -1), gKey,
+		mapRemoveByKeyListOp(groupPendingBin, ids, returnNone),
+		mapRemoveByKeyOp(groupConsumersBin, consumer, returnNone),
+	)
+	return len(ids), err
+}
+
+func cmdXGROUP(wf io.Writer, ctx *context, args [][]byte) error {
+	sub := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	if sub == "CREATE" {
+		if len(args) < 3 {
+			return errors.New("Wrong number of params for xgroup create")
+		}
+		return xgroupCreate(wf, ctx, args)
+	}
+	if len(args) < 2 {
+		return errors.New("Wrong number of params for xgroup " + strings.ToLower(sub))
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		gKey, rec, err := streamGroup(ctx, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			if sub == "DESTROY" {
+				return writeLine(wf, ":0")
+			}
+			return writeNoGroup(wf, args[0], args[1], "XGROUP "+sub)
+		}
+		switch sub {
+		case "DESTROY":
+			_, err = ctx.client.Delete(ctx.writePolicy, gKey)
+			if err != nil {
+				return err
+			}
+			return writeLine(wf, ":1")
+		case "SETID":
+			if len(args) < 3 {
+				return errors.New("Wrong number of params for xgroup setid")
+			}
+			id, err := groupStartID(ctx, args[0], args[2])
+			if err != nil {
+				return writeErrorReply(wf, err.Error())
+			}
+			err = ctx.client.PutBins(ctx.writePolicy, gKey, as.NewBin(groupLastIDBin, id.key()))
+			if err != nil {
+				return err
+			}
+			return writeLine(wf, "+OK")
+		case "CREATECONSUMER":
+			if len(args) < 3 {
+				return errors.New("Wrong number of params for xgroup createconsumer")
+			}
+			_, err = ctx.client.Operate(ctx.writePolicy, gKey, mapPutOp(streamMapCreateOnlyPolicy, groupConsumersBin, string(args[2]), nowMillis()))
+			if errResultCode(err) == ase.FAIL_ELEMENT_EXISTS {
+				return writeLine(wf, ":0")
+			}
+			if err != nil {
+				return err
+			}
+			return writeLine(wf, ":1")
+		case "DELCONSUMER":
+			if len(args) < 3 {
+				return errors.New("Wrong number of params for xgroup delconsumer")
+			}
+			count, err := tryXGroupDelConsumer(ctx, gKey, rec, string(args[2]))
+			if err == nil {
+				return writeLine(wf, ":"+strconv.Itoa(count))
+			}
+			if errResultCode(err) != ase.GENERATION_ERROR {
+				return err
+			}
+		default:
+			return writeErrorReply(wf, "Unknown XGROUP subcommand '"+sub+"'")
+		}
+	}
+	return errors.New("Too many retry for xgroup")
+}
+
+// Delivers the entries after the last delivered ID of the group, and adds
+// them to the pending entries list of the consumer.
+func tryReadGroupNew(ctx *context, k []byte, group []byte, consumer string, count int, noAck bool) (*streamReadResult, error) {
+	gKey, rec, err := streamGroup(ctx, k, group)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, errNoGroup
+	}
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	now := nowMillis()
+	var begin interface{}
+	next, ok := streamIDFromKey(rec.Bins[groupLastIDBin]).next()
+	if ok {
+		begin = next.key()
+	}
+	var ids, entries []interface{}
+	if begin != nil {
+		ids, entries, err = streamRange(ctx, key, begin, nil, count, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ops := []*operation{mapPutOp(streamMapPolicy, groupConsumersBin, consumer, now)}
+	if len(ids) > 0 {
+		ops = append(ops, putOp(as.NewBin(groupLastIDBin, ids[len(ids)-1])))
+		if !noAck {
+			items := make(map[interface{}]interface{})
+			for _, id := range ids {
+				items[id] = []interface{}{consumer, now, 1}
+			}
+			ops = append(ops, mapPutItemsOp(streamMapPolicy, groupPendingBin, items))
+		}
+	}
+	_, err = ctx.client.Operate(createWritePolicyGeneration(rec.Generation, -1), gKey, ops...)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return &streamReadResult{k, ids, entries}, nil
+}
+
+// Returns the pending entries of the consumer after the given ID. Deleted
+// entries are returned with a nil value.
+func readGroupHistory(ctx *context, k []byte, group []byte, consumer string, after streamID, count int) (*streamReadResult, error) {
+	gKey, rec, err := streamGroup(ctx, k, group)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, errNoGroup
+	}
+	_, err = ctx.client.Operate(ctx.writePolicy, gKey, mapPutOp(streamMapPolicy, groupConsumersBin, consumer, nowMillis()))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, 0)
+	for id, e := range groupPending(rec) {
+		if e.([]interface{})[0] == consumer && after.less(streamIDFromKey(id)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].(string) < ids[j].(string) })
+	if count > 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+	res := &streamReadResult{k, ids, make([]interface{}, len(ids))}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	key, err := buildKey(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]*operation, len(ids)+1)
+	ops[0] = mapSizeOp(streamBin)
+	for i, id := range ids {
+		ops[i+1] = mapGetByKeyOp(streamBin, id, returnValue)
+	}
+	stream, err := ctx.client.Operate(ctx.writePolicy, key, ops...)
+	if err != nil && errResultCode(err) != ase.KEY_NOT_FOUND_ERROR {
+		return nil, err
+	}
+	if err == nil {
+		res.entries = binResults(stream, streamBin, len(ops))[1:]
+	}
+	return res, nil
+}
+
+func cmdXREADGROUP(wf io.Writer, ctx *context, args [][]byte) error {
+	if strings.ToUpper(string(args[0])) != "GROUP" {
+		return writeErrorReply(wf, "syntax error")
+	}
+	group := args[1]
+	consumer := string(args[2])
+	r, err := parseStreamRead(args[3:], true)
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	history := make([]*streamID, len(r.keys))
+	for i, e := range r.ids {
+		if string(e) == ">" {
+			continue
+		}
+		id, err := parseStreamID(e, 0)
+		if err != nil {
+			return writeErrorReply(wf, err.Error())
+		}
+		history[i] = &id
+		// Like Redis, reading the history never blocks
+		r.block = false
+	}
+	err = readStreams(wf, ctx, r, func(i int) (*streamReadResult, error) {
+		if history[i] != nil {
+			return readGroupHistory(ctx, r.keys[i], group, consumer, *history[i], r.count)
+		}
+		for j := 0; j < ctx.generationRetries; j++ {
+			res, err := tryReadGroupNew(ctx, r.keys[i], group, consumer, r.count, r.noAck)
+			if errResultCode(err) != ase.GENERATION_ERROR {
+				return res, err
+			}
+		}
+		return nil, errors.New("Too many retry for xreadgroup")
+	})
+	if err == errNoGroup {
+		return writeNoGroup(wf, r.keys[0], group, "XREADGROUP with GROUP option")
+	}
+	return err
+}
+
+func cmdXACK(wf io.Writer, ctx *context, args [][]byte) error {
+	ids := make([]interface{}, len(args)-2)
+	for i, e := range args[2:] {
+		id, err := parseStreamID(e, 0)
+		if err != nil {
+			return writeErrorReply(wf, err.Error())
+		}
+		ids[i] = id.key()
+	}
+	gKey, rec, err := streamGroup(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeLine(wf, ":0")
+	}
+	rec, err = ctx.client.Operate(ctx.writePolicy, gKey, mapRemoveByKeyListOp(groupPendingBin, ids, returnCount))
+	if err != nil {
+		return err
+	}
+	return writeBinInt(wf, rec, groupPendingBin)
+}
+
+func xpendingSummary(wf io.Writer, pending map[interface{}]interface{}) error {
+	if len(pending) == 0 {
+		return writeLine(wf, "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1")
+	}
+	var min, max string
+	consumers := make(map[string]int)
+	for id, e := range pending {
+		s := id.(string)
+		if min == "" || s < min {
+			min = s
+		}
+		if s > max {
+			max = s
+		}
+		consumers[e.([]interface{})[0].(string)]++
+	}
+	names := make([]string, 0, len(consumers))
+	for name := range consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err := writeLine(wf, "*4\r\n:"+strconv.Itoa(len(pending)))
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, []byte(streamIDFromKey(min).String()))
+	if err != nil {
+		return err
+	}
+	err = writeByteArray(wf, []byte(streamIDFromKey(max).String()))
+	if err != nil {
+		return err
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(names)))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = writeLine(wf, "*2")
+		if err != nil {
+			return err
+		}
+		err = writeByteArray(wf, []byte(name))
+		if err != nil {
+			return err
+		}
+		err = writeByteArray(wf, []byte(strconv.Itoa(consumers[name])))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func cmdXPENDING(wf io.Writer, ctx *context, args [][]byte) error {
+	_, rec, err := streamGroup(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return writeNoGroup(wf, args[0], args[1], "XPENDING")
+	}
+	pending := groupPending(rec)
+	args = args[2:]
+	if len(args) == 0 {
+		return xpendingSummary(wf, pending)
+	}
+	minIdle := 0
+	if strings.ToUpper(string(args[0])) == "IDLE" {
+		if len(args) < 2 {
+			return writeErrorReply(wf, "syntax error")
+		}
+		minIdle, err = strconv.Atoi(string(args[1]))
+		if err != nil {
+			return err
+		}
+		args = args[2:]
+	}
+	if len(args) < 3 {
+		return writeErrorReply(wf, "syntax error")
+	}
+	begin, end, ok, err := streamKeyRange(args[0], args[1])
+	if err != nil {
+		return writeErrorReply(wf, err.Error())
+	}
+	count, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return err
+	}
+	var consumer interface{}
+	if len(args) > 3 {
+		consumer = string(args[3])
+	}
+	now := nowMillis()
+	ids := make([]string, 0)
+	for id, e := range pending {
+		s := id.(string)
+		info := e.([]interface{})
+		if !ok || (begin != nil && s < begin.(string)) || (end != nil && s >= end.(string)) {
+			continue
+		}
+		if (consumer != nil && info[0] != consumer) || now-info[1].(int) < minIdle {
+			continue
+		}
+		ids = append(ids, s)
+	}
+	sort.Strings(ids)
+	if count >= 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(ids)))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		info := pending[id].([]interface{})
+		err = writeLine(wf, "*4")
+		if err != nil {
+			return err
+		}
+		err = writeByteArray(wf, []byte(streamIDFromKey(id).String()))
+		if err != nil {
+			return err
+		}
+		err = writeByteArray(wf, []byte(info[0].(string)))
+		if err != nil {
+			return err
+		}
+		err = writeLine(wf, ":"+strconv.Itoa(now-info[1].(int))+"\r\n:"+strconv.Itoa(info[2].(int)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	as "github.com/aerospike/aerospike-client-go"
+	ase "github.com/aerospike/aerospike-client-go/types"
+)
+
+// Same limit as Redis
+const maxStringLength = 512 * 1024 * 1024
+
+// Reads the string value of a key for a generation checked write. The policy
+// is create only if the record does not exist.
+func stringRecord(ctx *context, key *as.Key) ([]byte, *as.Record, *as.WritePolicy, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, binName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if rec == nil {
+		return nil, nil, createWritePolicyEx(-1, true), nil
+	}
+	policy := createWritePolicyGeneration(rec.Generation, -1)
+	if rec.Bins[binName] == nil {
+		return nil, rec, policy, nil
+	}
+	current, err := decodeValue(rec.Bins[binName])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return current, rec, policy, nil
+}
+
+// The value is appended server side when it is stored as a plain blob, and
+// stays one after the append. Otherwise it is rewritten.
+func tryAppend(ctx *context, key *as.Key, suffix []byte) (int, error) {
+	current, rec, policy, err := stringRecord(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	value := make([]byte, 0, len(current)+len(suffix))
+	value = append(append(value, current...), suffix...)
+	encoded := encode(ctx, value)
+	if rec != nil {
+		stored, ok := rec.Bins[binName].([]byte)
+		buf, blob := encoded.([]byte)
+		if ok && blob && bytes.Equal(stored, current) && bytes.Equal(buf, value) {
+			_, err = ctx.client.Operate(policy, key, appendOp(as.NewBin(binName, suffix)))
+			return len(value), err
+		}
+	}
+	return len(value), ctx.client.PutBins(policy, key, as.NewBin(binName, encoded))
+}
+
+func cmdAPPEND(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		l, err := tryAppend(ctx, key, args[1])
+		if err == nil {
+			return writeLine(wf, ":"+strconv.Itoa(l))
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for append")
+}
+
+func cmdSTRLEN(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+	if err != nil {
+		return err
+	}
+	return writeBinLen(wf, rec, binName)
+}
+
+// Returns the bounds of a range given with Redis inclusive, possibly negative,
+// indexes, false if the range is empty.
+func stringRange(l int, start int, end int) (int, int, bool) {
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false
+	}
+	if start < 0 {
+		start += l
+	}
+	if end < 0 {
+		end += l
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= l {
+		end = l - 1
+	}
+	if l == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end + 1, true
+}
+
+func cmdGETRANGE(wf io.Writer, ctx *context, args [][]byte) error {
+	start, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	end, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.Bins[binName] == nil {
+		return writeByteArray(wf, []byte{})
+	}
+	buf, err := decodeValue(rec.Bins[binName])
+	if err != nil {
+		return err
+	}
+	from, to, ok := stringRange(len(buf), start, end)
+	if !ok {
+		return writeByteArray(wf, []byte{})
+	}
+	return writeByteArray(wf, buf[from:to])
+}
+
+func trySetRange(ctx *context, key *as.Key, offset int, value []byte) (int, error) {
+	current, _, policy, err := stringRecord(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	l := len(current)
+	if offset+len(value) > l {
+		l = offset + len(value)
+	}
+	buf := make([]byte, l)
+	copy(buf, current)
+	copy(buf[offset:], value)
+	return l, ctx.client.PutBins(policy, key, as.NewBin(binName, encode(ctx, buf)))
+}
+
+func cmdSETRANGE(wf io.Writer, ctx *context, args [][]byte) error {
+	offset, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return err
+	}
+	if offset < 0 || offset+len(args[2]) > maxStringLength {
+		return writeErrorReply(wf, "offset is out of range")
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if len(args[2]) == 0 {
+		rec, err := ctx.client.Get(ctx.readPolicy, key, binName)
+		if err != nil {
+			return err
+		}
+		return writeBinLen(wf, rec, binName)
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		l, err := trySetRange(ctx, key, offset, args[2])
+		if err == nil {
+			return writeLine(wf, ":"+strconv.Itoa(l))
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for setrange")
+}
+
+func cmdGETSET(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := ctx.client.Operate(createWritePolicyEx(-2, false), key, getOpForBin(binName), putOp(as.NewBin(binName, encode(ctx, args[1]))))
+	if err != nil {
+		return err
+	}
+	return writeBin(wf, rec, binName, "$-1")
+}
+
+// Returns the deleted value, nil if the key does not exist.
+func tryGetDel(ctx *context, key *as.Key) (interface{}, error) {
+	rec, err := ctx.client.Get(createMasterReadPolicy(), key, binName)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, nil
+	}
+	_, err = ctx.client.Delete(createWritePolicyGeneration(rec.Generation, -1), key)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Bins[binName], nil
+}
+
+func getDel(wf io.Writer, ctx *context, key *as.Key) error {
+	for i := 0; i < ctx.generationRetries; i++ {
+		value, err := tryGetDel(ctx, key)
+		if err == nil {
+			if value == nil {
+				return writeLine(wf, "$-1")
+			}
+			return writeValue(wf, value)
+		}
+		if errResultCode(err) != ase.GENERATION_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for getdel")
+}
+
+func cmdGETDEL(wf io.Writer, ctx *context, args [][]byte) error {
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return getDel(wf, ctx, key)
+}
+
+// Converts an expiration given as an option of a Redis command to an Aerospike
+// ttl in seconds. Milliseconds are rounded up to the next second. Returns -2
+// for PERSIST, and 0 if the expiration is already in the past.
+func parseExpiration(option []byte, value []byte) (int, error) {
+	if strings.ToUpper(string(option)) == "PERSIST" {
+		return -2, nil
+	}
+	x, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	var ms int64
+	switch strings.ToUpper(string(option)) {
+	case "EX", "PX":
+		if x <= 0 {
+			return 0, errors.New("Invalid expire time: '" + string(value) + "'")
+		}
+		ms = x
+		if option[0] == 'E' || option[0] == 'e' {
+			ms = x * 1000
+		}
+	case "EXAT":
+		ms = x*1000 - time.Now().UnixNano()/int64(time.Millisecond)
+	case "PXAT":
+		ms = x - time.Now().UnixNano()/int64(time.Millisecond)
+	default:
+		return 0, errors.New("Syntax error: '" + string(option) + "'")
+	}
+	if ms <= 0 {
+		return 0, nil
+	}
+	return int((ms + 999) / 1000), nil
+}
+
+func cmdGETEX(wf io.Writer, ctx *context, args [][]byte) error {
+	if len(args) == 1 {
+		return get(wf, ctx, args[0], binName)
+	}
+	var value []byte
+	if len(args) == 3 {
+		value = args[2]
+	} else if len(args) != 2 || strings.ToUpper(string(args[1])) != "PERSIST" {
+		return errors.New("Syntax error for getex")
+	}
+	ttl, err := parseExpiration(args[1], value)
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if ttl == 0 {
+		return getDel(wf, ctx, key)
+	}
+	rec, err := ctx.client.Operate(createWritePolicyEx(ttl, false), key, touchOp(), getOpForBin(binName))
+	if err != nil {
+		if errResultCode(err) == ase.KEY_NOT_FOUND_ERROR {
+			return writeLine(wf, "$-1")
+		}
+		return err
+	}
+	return writeBin(wf, rec, binName, "$-1")
+}
+
+// All or nothing: keys are created one by one, and the created ones are
+// deleted if one of the keys already exists. The deletions are generation
+// checked, to keep the values written by others in the meantime.
+func cmdMSETNX(wf io.Writer, ctx *context, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return errors.New("Wrong number of params for msetnx")
+	}
+	names, values := hashFieldValues(ctx, args)
+	keys := make([]*as.Key, len(names))
+	for i, e := range names {
+		key, err := buildKey(ctx, []byte(e))
+		if err != nil {
+			return err
+		}
+		exists, err := ctx.client.Exists(createMasterReadPolicy(), key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return writeLine(wf, ":0")
+		}
+		keys[i] = key
+	}
+	for i, key := range keys {
+		err := ctx.client.PutBins(createWritePolicyEx(-2, true), key, as.NewBin(binName, values[i]))
+		if err == nil {
+			continue
+		}
+		for _, created := range keys[:i] {
+			_, rollbackErr := ctx.client.Delete(createWritePolicyGeneration(1, -1), created)
+			if rollbackErr != nil && errResultCode(rollbackErr) != ase.GENERATION_ERROR {
+				return rollbackErr
+			}
+		}
+		if errResultCode(err) == ase.KEY_EXISTS_ERROR {
+			return writeLine(wf, ":0")
+		}
+		return err
+	}
+	return writeLine(wf, ":1")
+}
+
+func tryIncrByFloat(ctx *context, key *as.Key, incr float64) (interface{}, error) {
+	current, _, policy, err := stringRecord(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := 0.0
+	if current != nil {
+		f, err = parseFloat(current)
+		if err != nil {
+			return nil, nil
+		}
+	}
+	res := f + incr
+	if math.IsNaN(res) || math.IsInf(res, 0) {
+		return nil, nil
+	}
+	return res, ctx.client.PutBins(policy, key, as.NewBin(binName, res))
+}
+
+// The value is stored as a float bin, incremented server side. Values stored
+// as integers or strings are converted by a generation checked write.
+func cmdINCRBYFLOAT(wf io.Writer, ctx *context, args [][]byte) error {
+	incr, err := parseFloat(args[1])
+	if err != nil {
+		return err
+	}
+	key, err := buildKey(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	rec, err := operate(ctx, ctx.writePolicy, key, addOp(as.NewBin(binName, incr)), getOpForBin(binName))
+	if err == nil {
+		return writeBin(wf, rec, binName, "$-1")
+	}
+	if errResultCode(err) != ase.BIN_TYPE_ERROR {
+		return err
+	}
+	for i := 0; i < ctx.generationRetries; i++ {
+		res, err := tryIncrByFloat(ctx, key, incr)
+		if err == nil {
+			if res == nil {
+				return writeLine(wf, "$-1")
+			}
+			return writeValue(wf, res)
+		}
+		code := errResultCode(err)
+		if code != ase.GENERATION_ERROR && code != ase.KEY_EXISTS_ERROR {
+			return err
+		}
+	}
+	return errors.New("Too many retry for incrbyfloat")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"io"
+	"time"
 
 	as "github.com/aerospike/aerospike-client-go"
 	"github.com/coocood/freecache"
@@ -15,7 +16,7 @@ type handler struct {
 }
 
 type context struct {
-	client                *as.Client
+	client                backend
 	exitOnClusterLost     bool
 	ns                    string
 	set                   string
@@ -30,4 +31,18 @@ type context struct {
 	expandedMapCacheTTL   int
 	logCommands           bool
 	generationRetries     int
+	strictEncoding        bool
+	compression           byte
+	compressionThreshold  int
+	counterCompressionIn  uint64
+	counterCompressionOut uint64
+	listWaiters           *waiters
+	capture               *capture
+	monitors              *monitors
+	slowlog               *slowlog
+	idleTimeout           time.Duration
+	tcpKeepAlive          time.Duration
+	maxClients            int32
+	counterRejected       uint32
+	counterTimeout        uint32
 }
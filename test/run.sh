@@ -23,3 +23,38 @@ sleep 3
 php test.php
 pkill aerodis || true
 sleep 3
+
+echo "Strict encoding test"
+../aerodis --config_file config_strict_encoding.json &
+sleep 3
+STRICT_ENCODING=1 php test.php
+pkill aerodis || true
+sleep 3
+
+echo "Compression test"
+../aerodis --config_file config_compression.json &
+sleep 3
+php test.php
+pkill aerodis || true
+sleep 3
+
+echo "Cdt map test"
+../aerodis --config_file config_cdt_map.json &
+sleep 3
+CDT_MAP=1 php test.php
+pkill aerodis || true
+sleep 3
+
+echo "TTL compatibility test"
+redis-server --port 6380 &
+../aerodis --config_file config.json &
+sleep 3
+php ttl_compat.php
+pkill aerodis || true
+sleep 3
+../aerodis --config_file config_expanded_map.json &
+sleep 3
+php ttl_compat.php
+pkill aerodis || true
+pkill redis-server || true
+sleep 3
@@ -7,5 +7,7 @@ echo "Redis test"
 redis-server &
 sleep 3
 USE_REAL_REDIS=1 php test.php
+echo "Compatibility test"
+(cd .. && go test -run TestCompat -redis 127.0.0.1:6379)
 pkill redis-server || true
 sleep 3
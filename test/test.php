@@ -109,6 +109,30 @@ for($i = 1; $i < 1032; $i ++) {
   compare($r->get('myKey'), $s);
 }
 
+if (isset($_ENV['STRICT_ENCODING']) || isset($_ENV['USE_REAL_REDIS'])) {
+  echo("Strict encoding\n");
+  $r->del('myKey');
+  compare($r->set('myKey', '007'), true);
+  compare($r->get('myKey'), '007');
+  compare($r->set('myKey', '+5'), true);
+  compare($r->get('myKey'), '+5');
+  compare($r->set('myKey', '-0'), true);
+  compare($r->get('myKey'), '-0');
+  compare($r->set('myKey', '-12'), true);
+  compare($r->get('myKey'), '-12');
+  compare($r->incr('myKey'), -11);
+  $r->del('myKey');
+  compare($r->rpush('myKey', '007'), 1);
+  compare($r->rpush('myKey', '12'), 2);
+  compare($r->lRange('myKey', 0, -1), array(0 => '007', 1 => '12'));
+  compare($r->lpop('myKey'), '007');
+  $r->del('myKey');
+  compare($r->hSet('myKey', 'a', '007'), 1);
+  compare($r->hMSet('myKey', array('b' => '+5', 'c' => '3')), true);
+  compare($r->hGet('myKey', 'a'), '007');
+  compare_map($r->hGetAll('myKey'), array('a' => '007', 'b' => '+5', 'c' => '3'));
+}
+
 echo("Flush\n");
 compare($r->set('myKey1', "a"), true);
 compare($r->set('myKey2', "b"), true);
@@ -148,6 +172,16 @@ compare($r->rpush('myKey', $bin), 1);
 compare(gzuncompress($r->lrange('myKey', 0, 200)[0]), $json);
 compare(gzuncompress($r->rpop('myKey')), $json);
 
+echo("Get Set values starting with the compression prefix\n");
+
+foreach(array("\x00AZCgnot gzip", "\x00AZCsnot snappy", "\x00AZC\x00escaped", "\x00AZCg".str_repeat('a', 200)) as $v) {
+  $r->del('myKey');
+  compare($r->set('myKey', $v), true);
+  compare($r->get('myKey'), $v);
+  compare($r->rpush('myKey2', $v), 1);
+  compare($r->rpop('myKey2'), $v);
+}
+
 echo("Incr Decr\n");
 
 $r->delete('myKey');
@@ -314,6 +348,411 @@ compare($r->lRange('myKey', 0, 0), array());
 compare($r->ltrim('myKey', 2, 4), true);
 compare($r->lsize('myKey'), 0);
 
+echo("Array multiple push\n");
+$r->del('myKey');
+compare($r->rpush('myKey', 'a', 'b', 'c'), 3);
+compare($r->lpush('myKey', 'd', 'e'), 5);
+compare($r->lsize('myKey'), 5);
+compare($r->lRange('myKey', 0, -1), array('e', 'd', 'a', 'b', 'c'));
+compare($r->rpushx('myKey', 'f'), 6);
+compare($r->lpushx('myKey', 'g'), 7);
+compare($r->lRange('myKey', 0, -1), array('g', 'e', 'd', 'a', 'b', 'c', 'f'));
+$r->del('myKey2');
+compare($r->rpushx('myKey2', 'a'), 0);
+compare($r->lpushx('myKey2', 'a'), 0);
+compare($r->lsize('myKey2'), 0);
+
+echo("Array lIndex lSet lInsert lRem lPos\n");
+$r->del('myKey');
+compare($r->lIndex('myKey', 0), false);
+compare($r->lSet('myKey', 0, 'a'), false);
+compare($r->rpush('myKey', 'a', 'b', 'c', 'b', 'a', 'b'), 6);
+compare($r->lIndex('myKey', 0), 'a');
+compare($r->lIndex('myKey', -1), 'b');
+compare($r->lIndex('myKey', 10), false);
+compare($r->lSet('myKey', 1, 'z'), true);
+compare($r->lSet('myKey', -1, 'y'), true);
+compare($r->lSet('myKey', 6, 'x'), false);
+compare($r->lRange('myKey', 0, -1), array('a', 'z', 'c', 'b', 'a', 'y'));
+compare($r->lInsert('myKey', Redis::BEFORE, 'c', 'w'), 7);
+compare($r->lInsert('myKey', Redis::AFTER, 'y', 'v'), 8);
+compare($r->lInsert('myKey', Redis::AFTER, 'unknown', 'v'), -1);
+compare($r->lRange('myKey', 0, -1), array('a', 'z', 'w', 'c', 'b', 'a', 'y', 'v'));
+compare($r->lsize('myKey'), 8);
+compare($r->rawCommand('LPOS', 'myKey', 'a'), 0);
+compare($r->rawCommand('LPOS', 'myKey', 'a', 'RANK', '2'), 5);
+compare($r->rawCommand('LPOS', 'myKey', 'a', 'RANK', '-1'), 5);
+compare($r->rawCommand('LPOS', 'myKey', 'a', 'COUNT', '0'), array(0, 5));
+compare($r->rawCommand('LPOS', 'myKey', 'a', 'RANK', '2', 'MAXLEN', '3'), false);
+compare($r->rawCommand('LPOS', 'myKey', 'unknown'), false);
+compare($r->lRem('myKey', 'a', 0), 2);
+compare($r->lsize('myKey'), 6);
+compare($r->rpush('myKey', 'z', 'z'), 8);
+compare($r->lRem('myKey', 'z', -2), 2);
+compare($r->lRange('myKey', 0, -1), array('z', 'w', 'c', 'b', 'y', 'v'));
+compare($r->lRem('myKey', 'unknown', 0), 0);
+$r->del('myKey');
+compare($r->lInsert('myKey', Redis::AFTER, 'a', 'b'), 0);
+
+echo("Array blocking pop\n");
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->rpush('myKey2', 'a', 'b'), 2);
+compare($r->blPop(array('myKey', 'myKey2'), 1), array('myKey2', 'a'));
+compare($r->brPop(array('myKey', 'myKey2'), 1), array('myKey2', 'b'));
+$start_pop = microtime(true);
+compare($r->blPop(array('myKey', 'myKey2'), 1), array());
+upper(microtime(true) - $start_pop, 0.9);
+compare($r->rpush('myKey', 'c'), 1);
+compare($r->rawCommand('BLMOVE', 'myKey', 'myKey2', 'LEFT', 'RIGHT', '1'), 'c');
+compare($r->rawCommand('BLMOVE', 'myKey', 'myKey2', 'LEFT', 'RIGHT', '0.1'), false);
+compare($r->lRange('myKey2', 0, -1), array('c'));
+compare($r->brpoplpush('myKey2', 'myKey', 1), 'c');
+compare($r->lRange('myKey', 0, -1), array('c'));
+// A client closing the connection while blocked does not pop the next element
+$r->del('myKey');
+$s = fsockopen('127.0.0.1', 6379);
+fwrite($s, "*3\r\n\$5\r\nBLPOP\r\n\$5\r\nmyKey\r\n\$1\r\n0\r\n");
+usleep(100000);
+fclose($s);
+usleep(100000);
+compare($r->rpush('myKey', 'd'), 1);
+usleep(100000);
+compare($r->lRange('myKey', 0, -1), array('d'));
+
+echo("Array rPopLPush lMove\n");
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->rpoplpush('myKey', 'myKey2'), false);
+compare($r->rpush('myKey', 'a', 'b', 'c'), 3);
+compare($r->rpoplpush('myKey', 'myKey2'), 'c');
+compare($r->lRange('myKey', 0, -1), array('a', 'b'));
+compare($r->lRange('myKey2', 0, -1), array('c'));
+compare($r->llen('myKey'), 2);
+compare($r->llen('myKey2'), 1);
+compare($r->rawCommand('LMOVE', 'myKey', 'myKey2', 'LEFT', 'RIGHT'), 'a');
+compare($r->lRange('myKey2', 0, -1), array('c', 'a'));
+compare($r->rawCommand('LMOVE', 'myKey2', 'myKey2', 'LEFT', 'RIGHT'), 'c');
+compare($r->lRange('myKey2', 0, -1), array('a', 'c'));
+compare($r->rpoplpush('myKey2', 'myKey2'), 'c');
+compare($r->lRange('myKey2', 0, -1), array('c', 'a'));
+compare($r->llen('myKey2'), 2);
+compare($r->rawCommand('LMOVE', 'unknown', 'myKey2', 'LEFT', 'RIGHT'), false);
+
+echo("String commands\n");
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->append('myKey', 'abc'), 3);
+compare($r->append('myKey', 'def'), 6);
+compare($r->get('myKey'), 'abcdef');
+compare($r->strlen('myKey'), 6);
+compare($r->strlen('unknown'), 0);
+compare($r->getRange('myKey', 1, 2), 'bc');
+compare($r->getRange('myKey', -3, -1), 'def');
+compare($r->getRange('myKey', 4, 100), 'ef');
+compare($r->getRange('myKey', 5, 2), '');
+compare($r->getRange('unknown', 0, -1), '');
+compare($r->setRange('myKey', 3, 'XY'), 6);
+compare($r->get('myKey'), 'abcXYf');
+compare($r->setRange('myKey2', 2, 'a'), 3);
+compare($r->get('myKey2'), "\0\0a");
+$r->set('myKey', 12);
+compare($r->append('myKey', '3'), 3);
+compare($r->incr('myKey'), 124);
+compare($r->getSet('myKey', 'z'), '124');
+compare($r->getSet('unknown', 'z'), false);
+compare($r->get('unknown'), 'z');
+compare($r->rawCommand('GETDEL', 'unknown'), 'z');
+compare($r->rawCommand('GETDEL', 'unknown'), false);
+compare($r->exists('unknown'), false);
+compare($r->rawCommand('GETEX', 'myKey', 'EX', '100'), 'z');
+upper($r->ttl('myKey'), 99);
+lower($r->ttl('myKey'), 100);
+compare($r->rawCommand('GETEX', 'myKey', 'PX', '1500'), 'z');
+upper($r->ttl('myKey'), 1);
+lower($r->ttl('myKey'), 2);
+compare($r->rawCommand('GETEX', 'myKey', 'PERSIST'), 'z');
+compare($r->ttl('myKey'), -1);
+compare($r->rawCommand('GETEX', 'myKey'), 'z');
+compare($r->rawCommand('GETEX', 'unknown', 'EX', '100'), false);
+compare($r->incrByFloat('myKey2', 10.5), 10.5);
+compare($r->incrByFloat('myKey2', 0.25), 10.75);
+compare($r->incrByFloat('myKey2', -0.75), 10.0);
+compare($r->get('myKey2'), '10');
+$r->set('myKey2', '3');
+compare($r->incrByFloat('myKey2', 1.5), 4.5);
+compare($r->get('myKey2'), '4.5');
+compare($r->incrByFloat('myKey', 1.5), false);
+$r->del('myKey2');
+compare($r->rawCommand('INCRBYFLOAT', 'myKey2', '0.1'), '0.1');
+compare($r->rawCommand('INCRBYFLOAT', 'myKey2', '0.2'), '0.3');
+compare($r->get('myKey2'), '0.3');
+$r->set('myKey2', '10.50');
+compare($r->rawCommand('INCRBYFLOAT', 'myKey2', '0.1'), '10.6');
+compare($r->rawCommand('INCRBYFLOAT', 'myKey2', '-5'), '5.6');
+$r->set('myKey2', '5.0e3');
+compare($r->rawCommand('INCRBYFLOAT', 'myKey2', '2.0e2'), '5200');
+$r->del('myKey1');
+$r->del('myKey2');
+$r->del('myKey3');
+compare($r->msetnx(array('myKey1' => 'a', 'myKey2' => 'b')), true);
+compare($r->msetnx(array('myKey3' => 'c', 'myKey2' => 'd')), false);
+compare($r->mget(['myKey1', 'myKey2', 'myKey3']), ['a', 'b', false]);
+
+echo("Bitmap\n");
+$r->del('myKey');
+$r->del('myKey2');
+$r->del('myKey3');
+compare($r->getBit('myKey', 10), 0);
+compare($r->getBit('unknown', 0), 0);
+compare($r->setBit('myKey', 7, 1), 0);
+compare($r->setBit('myKey', 7, 1), 1);
+compare($r->setBit('myKey', 20, 1), 0);
+compare($r->getBit('myKey', 7), 1);
+compare($r->getBit('myKey', 8), 0);
+compare($r->getBit('myKey', 1000), 0);
+compare($r->strlen('myKey'), 3);
+compare($r->get('myKey'), "\x01\x00\x08");
+compare($r->bitCount('myKey'), 2);
+compare($r->bitCount('myKey', 1, -1), 1);
+compare($r->rawCommand('BITCOUNT', 'myKey', '0', '7', 'BIT'), 1);
+compare($r->bitCount('unknown'), 0);
+compare($r->bitpos('myKey', 1), 7);
+compare($r->bitpos('myKey', 0), 0);
+compare($r->bitpos('myKey', 1, 1), 20);
+compare($r->bitpos('unknown', 1), -1);
+compare($r->bitpos('unknown', 0), 0);
+$r->set('myKey2', "\xff");
+compare($r->bitpos('myKey2', 0), 8);
+compare($r->bitpos('myKey2', 0, 0, -1), -1);
+compare($r->bitOp('AND', 'myKey3', 'myKey', 'myKey2'), 3);
+compare($r->get('myKey3'), "\x01\x00\x00");
+compare($r->bitOp('OR', 'myKey3', 'myKey', 'myKey2'), 3);
+compare($r->get('myKey3'), "\xff\x00\x08");
+compare($r->bitOp('XOR', 'myKey3', 'myKey', 'myKey2'), 3);
+compare($r->get('myKey3'), "\xfe\x00\x08");
+compare($r->bitOp('NOT', 'myKey3', 'myKey2'), 1);
+compare($r->get('myKey3'), "\x00");
+compare($r->bitOp('AND', 'myKey3', 'unknown'), 0);
+compare($r->exists('myKey3'), false);
+$r->set('myKey2', 5);
+compare($r->setBit('myKey2', 6, 1), 0);
+compare($r->get('myKey2'), '7');
+$big = str_repeat("\x01", 200);
+$r->set('myKey2', $big);
+compare($r->getBit('myKey2', 15), 1);
+compare($r->setBit('myKey2', 7, 0), 1);
+compare($r->getBit('myKey2', 7), 0);
+compare($r->getBit('myKey2', 15), 1);
+compare($r->get('myKey2'), "\x00".substr($big, 1));
+
+echo("HyperLogLog\n");
+$r->del('myKey');
+$r->del('myKey2');
+$r->del('myKey3');
+compare($r->pfAdd('myKey', array('a', 'b', 'c')), true);
+compare($r->pfAdd('myKey', array('a', 'b')), false);
+compare($r->pfCount('myKey'), 3);
+compare($r->pfCount('unknown'), 0);
+compare($r->pfAdd('myKey2', array('c', 'd')), true);
+compare($r->pfCount(array('myKey', 'myKey2')), 4);
+compare($r->pfCount(array('unknown', 'myKey2')), 2);
+compare($r->pfMerge('myKey3', array('myKey', 'myKey2', 'unknown')), true);
+compare($r->pfCount('myKey3'), 4);
+compare($r->pfMerge('myKey', array()), true);
+compare($r->pfCount('myKey'), 3);
+compare($r->rawCommand('PFADD', 'unknown'), 1);
+compare($r->rawCommand('PFADD', 'unknown'), 0);
+compare($r->pfCount('unknown'), 0);
+$r->del('unknown');
+$elements = array();
+for ($i = 0; $i < 1000; $i++) {
+  $elements[] = 'element'.$i;
+}
+$r->pfAdd('myKey', $elements);
+upper($r->pfCount('myKey'), 970);
+lower($r->pfCount('myKey'), 1030);
+
+echo("Geo\n");
+$r->del('myKey');
+compare($r->geoadd('myKey', 13.361389, 38.115556, 'Palermo', 15.087269, 37.502669, 'Catania'), 2);
+compare($r->geoadd('myKey', 13.361389, 38.115556, 'Palermo'), 0);
+compare($r->rawCommand('GEOADD', 'myKey', 'NX', '13', '38', 'Palermo', '12.5', '41.9', 'Rome'), 1);
+compare($r->rawCommand('GEOADD', 'myKey', 'XX', 'CH', '12.496366', '41.902782', 'Rome', '0', '0', 'Nowhere'), 1);
+compare($r->geohash('myKey', 'Palermo', 'Catania', 'unknown'), array('sqc8b49rny0', 'sqdtr74hyu0', NULL));
+$pos = $r->geopos('myKey', 'Palermo', 'unknown');
+compare(count($pos), 2);
+lower(abs($pos[0][0] - 13.361389), 0.00001);
+lower(abs($pos[0][1] - 38.115556), 0.00001);
+compare($pos[1], NULL);
+compare($r->geodist('myKey', 'Palermo', 'Catania'), 166274.1516);
+compare($r->geodist('myKey', 'Palermo', 'Catania', 'km'), 166.2742);
+compare($r->geodist('myKey', 'Palermo', 'unknown'), false);
+compare($r->rawCommand('GEOSEARCH', 'myKey', 'FROMLONLAT', '15', '37', 'BYRADIUS', '200', 'km', 'ASC'), array('Catania', 'Palermo'));
+compare($r->rawCommand('GEOSEARCH', 'myKey', 'FROMLONLAT', '15', '37', 'BYRADIUS', '200', 'km', 'DESC', 'WITHDIST'), array(array('Palermo', '190.4424'), array('Catania', '56.4413')));
+compare($r->rawCommand('GEOSEARCH', 'myKey', 'FROMMEMBER', 'Palermo', 'BYRADIUS', '100', 'km', 'COUNT', '1'), array('Palermo'));
+compare($r->rawCommand('GEOSEARCH', 'myKey', 'FROMLONLAT', '15', '37', 'BYBOX', '400', '400', 'km', 'ASC', 'WITHDIST'), array(array('Catania', '56.4413'), array('Palermo', '190.4424')));
+compare($r->rawCommand('GEOSEARCH', 'myKey', 'FROMLONLAT', '15', '37', 'BYBOX', '100', '100', 'km', 'ASC'), array());
+compare($r->rawCommand('GEOSEARCH', 'unknown', 'FROMLONLAT', '15', '37', 'BYRADIUS', '200', 'km'), array());
+
+echo("Stream\n");
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->xAdd('myKey', '1-1', array('a' => '1', 'b' => 'x')), '1-1');
+compare($r->xAdd('myKey', '1-*', array('a' => '2')), '1-2');
+compare($r->xAdd('myKey', '5', array('a' => '3')), '5-0');
+compare($r->xAdd('myKey', '4-1', array('a' => '4')), false);
+compare($r->xLen('myKey'), 3);
+compare($r->xLen('unknown'), 0);
+compare($r->xRange('myKey', '-', '+'), array('1-1' => array('a' => '1', 'b' => 'x'), '1-2' => array('a' => '2'), '5-0' => array('a' => '3')));
+compare($r->xRange('myKey', '1', '1'), array('1-1' => array('a' => '1', 'b' => 'x'), '1-2' => array('a' => '2')));
+compare($r->xRange('myKey', '(1-1', '+', 1), array('1-2' => array('a' => '2')));
+compare($r->xRevRange('myKey', '+', '-', 2), array('5-0' => array('a' => '3'), '1-2' => array('a' => '2')));
+compare($r->xRange('unknown', '-', '+'), array());
+compare($r->xDel('myKey', array('1-2', '3-0')), 1);
+compare($r->xTrim('myKey', 1), 1);
+compare($r->xRange('myKey', '-', '+'), array('5-0' => array('a' => '3')));
+$id = $r->xAdd('myKey', '*', array('a' => '5'), 1);
+compare($r->xRange('myKey', '-', '+'), array($id => array('a' => '5')));
+compare($r->xRead(array('myKey' => '0-0', 'unknown' => '0-0')), array('myKey' => array($id => array('a' => '5'))));
+compare(empty($r->xRead(array('myKey' => $id))), true);
+$start_read = microtime(true);
+compare(empty($r->xRead(array('myKey' => '$'), 1, 200)), true);
+upper(microtime(true) - $start_read, 0.15);
+
+echo("Stream consumer groups\n");
+$r->del('myKey');
+compare($r->xGroup('CREATE', 'myKey', 'g1', '0'), false);
+compare($r->xGroup('CREATE', 'myKey', 'g1', '0', true), true);
+compare($r->xGroup('CREATE', 'myKey', 'g1', '0'), false);
+compare($r->xAdd('myKey', '1-0', array('a' => '1')), '1-0');
+compare($r->xAdd('myKey', '2-0', array('a' => '2')), '2-0');
+compare($r->xReadGroup('g1', 'c1', array('myKey' => '>'), 1), array('myKey' => array('1-0' => array('a' => '1'))));
+compare($r->xReadGroup('g1', 'c2', array('myKey' => '>')), array('myKey' => array('2-0' => array('a' => '2'))));
+compare(empty($r->xReadGroup('g1', 'c2', array('myKey' => '>'))), true);
+compare($r->xReadGroup('g1', 'c1', array('myKey' => '0')), array('myKey' => array('1-0' => array('a' => '1'))));
+compare($r->xPending('myKey', 'g1'), array(2, '1-0', '2-0', array(array('c1', '1'), array('c2', '1'))));
+$pending = $r->xPending('myKey', 'g1', '-', '+', 10, 'c2');
+compare(count($pending), 1);
+compare($pending[0][0], '2-0');
+compare($pending[0][1], 'c2');
+compare($pending[0][3], 1);
+compare($r->xAck('myKey', 'g1', array('1-0', '3-0')), 1);
+compare($r->xPending('myKey', 'g1'), array(1, '2-0', '2-0', array(array('c2', '1'))));
+compare($r->xGroup('DESTROY', 'myKey', 'g1'), 1);
+compare($r->xGroup('DESTROY', 'myKey', 'g1'), 0);
+compare($r->xGroup('CREATE', 'myKey', 'g1', '$'), true);
+compare(empty($r->xReadGroup('g1', 'c1', array('myKey' => '>'))), true);
+$r->del('myKey');
+compare($r->xGroup('CREATE', 'myKey', 'g1', '0', true), true);
+compare($r->xPending('myKey', 'g1'), array(0, NULL, NULL, NULL));
+
+echo("Keys\n");
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->type('myKey'), Redis::REDIS_NOT_FOUND);
+compare($r->rename('myKey', 'myKey2'), false);
+compare($r->set('myKey', 'a'), true);
+compare($r->type('myKey'), Redis::REDIS_STRING);
+compare($r->expire('myKey', 100), true);
+compare($r->rename('myKey', 'myKey2'), true);
+compare($r->exists('myKey'), false);
+compare($r->get('myKey2'), 'a');
+compare($r->ttl('myKey2') > 90, true);
+compare($r->persist('myKey2'), true);
+compare($r->persist('myKey2'), false);
+compare($r->ttl('myKey2'), -1);
+compare($r->persist('myKey'), false);
+compare($r->set('myKey', 'b'), true);
+compare($r->renameNx('myKey', 'myKey2'), false);
+compare($r->get('myKey'), 'b');
+compare($r->rename('myKey', 'myKey2'), true);
+compare($r->get('myKey2'), 'b');
+compare($r->renameNx('myKey2', 'myKey'), true);
+compare($r->get('myKey'), 'b');
+compare($r->copy('myKey', 'myKey2'), true);
+compare($r->copy('myKey', 'myKey2'), false);
+compare($r->set('myKey', 'c'), true);
+compare($r->copy('myKey', 'myKey2', array('replace' => true)), true);
+compare($r->get('myKey2'), 'c');
+compare($r->get('myKey'), 'c');
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->rPush('myKey', 'a', 'b'), 2);
+compare($r->type('myKey'), Redis::REDIS_LIST);
+compare($r->rename('myKey', 'myKey2'), true);
+compare($r->lRange('myKey2', 0, -1), array('a', 'b'));
+compare($r->rPush('myKey2', 'c'), 3);
+compare($r->lLen('myKey2'), 3);
+$r->del('myKey2');
+compare($r->hSet('myKey', 'a', '1'), 1);
+compare($r->hSet('myKey', 'b', '2'), 1);
+compare($r->type('myKey'), Redis::REDIS_HASH);
+compare($r->rename('myKey', 'myKey2'), true);
+compare($r->exists('myKey'), false);
+compare($r->hGet('myKey2', 'b'), '2');
+compare($r->hGetAll('myKey'), array());
+compare($r->copy('myKey2', 'myKey'), true);
+compare($r->hGet('myKey', 'a'), '1');
+compare($r->hSet('myKey', 'a', '3'), 0);
+compare($r->hGet('myKey2', 'a'), '1');
+compare($r->set('myKey3', 'a'), true);
+compare($r->rename('myKey3', 'myKey2'), true);
+compare($r->get('myKey2'), 'a');
+compare($r->hGet('myKey2', 'a'), false);
+$r->del('myKey');
+$r->del('myKey2');
+compare($r->xAdd('myKey', '1-0', array('a' => '1')), '1-0');
+compare($r->type('myKey'), Redis::REDIS_STREAM);
+$r->del('myKey');
+$k = $r->randomKey();
+compare($k === false || is_string($k), true);
+
+echo("Expire\n");
+$r->del('myKey');
+compare($r->pExpire('myKey', 10000), false);
+compare($r->pttl('myKey'), -2);
+compare($r->rawCommand('EXPIRETIME', 'myKey'), -2);
+compare($r->set('myKey', 'a'), true);
+compare($r->pttl('myKey'), -1);
+compare($r->rawCommand('EXPIRETIME', 'myKey'), -1);
+compare($r->rawCommand('EXPIRE', 'myKey', '100', 'XX'), 0);
+compare($r->rawCommand('EXPIRE', 'myKey', '100', 'GT'), 0);
+compare($r->rawCommand('EXPIRE', 'myKey', '100', 'NX'), 1);
+compare($r->rawCommand('EXPIRE', 'myKey', '200', 'NX'), 0);
+compare($r->rawCommand('EXPIRE', 'myKey', '50', 'GT'), 0);
+compare($r->rawCommand('EXPIRE', 'myKey', '200', 'GT'), 1);
+upper($r->ttl('myKey'), 199);
+compare($r->rawCommand('EXPIRE', 'myKey', '300', 'LT'), 0);
+compare($r->rawCommand('EXPIRE', 'myKey', '150', 'XX', 'LT'), 1);
+upper($r->ttl('myKey'), 149);
+lower($r->ttl('myKey'), 150);
+compare($r->pExpire('myKey', 10500), true);
+upper($r->pttl('myKey'), 10000);
+lower($r->pttl('myKey'), 11000);
+$t = time() + 1000;
+compare($r->expireAt('myKey', $t), true);
+upper($r->rawCommand('EXPIRETIME', 'myKey'), $t - 1);
+lower($r->rawCommand('EXPIRETIME', 'myKey'), $t + 1);
+compare($r->pExpireAt('myKey', $t * 1000 + 500), true);
+upper($r->rawCommand('PEXPIRETIME', 'myKey'), $t * 1000 - 1000);
+lower($r->rawCommand('PEXPIRETIME', 'myKey'), $t * 1000 + 2000);
+compare($r->persist('myKey'), true);
+compare($r->rawCommand('EXPIRE', 'myKey', '100', 'LT'), 1);
+compare($r->expireAt('myKey', time() - 10), true);
+compare($r->exists('myKey'), false);
+compare($r->set('myKey', 'a'), true);
+compare($r->expire('myKey', 0), true);
+compare($r->exists('myKey'), false);
+compare($r->hSet('myKey', 'a', '1'), 1);
+compare($r->pExpire('myKey', 10000), true);
+upper($r->ttl('myKey'), 9);
+lower($r->ttl('myKey'), 10);
+compare($r->rawCommand('EXPIRE', 'myKey', '100', 'NX'), 0);
+compare($r->persist('myKey'), true);
+compare($r->ttl('myKey'), -1);
+$r->del('myKey');
+
 echo("mGet mSet\n");
 $r->del('myKey1');
 $r->del('myKey2');
@@ -383,13 +822,86 @@ compare($r->hmSet('myKey', array("b" => $bin)), true);
 compare($r->hGet('myKey', "b"), $bin);
 compare_map($r->hGetAll('myKey'), array('b' => $bin, 'toto' => '2'));
 
-if (isset($_ENV['EXPANDED_MAP'])) {
+if (isset($_ENV['EXPANDED_MAP']) || isset($_ENV['CDT_MAP'])) {
   $r->del('myKey');
   compare($r->hSet('myKey', "veryveryveryveryveryverylongke", "toto"), 1);
   compare($r->hGet('myKey', "veryveryveryveryveryverylongke"), "toto");
   compare_map($r->hGetAll('myKey'), array('veryveryveryveryveryverylongke' => 'toto'));
 }
 
+echo("hExists hLen hKeys hVals hSetNx hStrLen\n");
+$r->del('myKey');
+compare($r->hExists('myKey', 'a'), false);
+compare($r->hLen('myKey'), 0);
+compare($r->hKeys('myKey'), array());
+compare($r->hVals('myKey'), array());
+compare($r->hSetNx('myKey', 'a', 'toto'), true);
+compare($r->hSetNx('myKey', 'a', 'titi'), false);
+compare($r->hGet('myKey', 'a'), 'toto');
+compare($r->hExists('myKey', 'a'), true);
+compare($r->hExists('myKey', 'b'), false);
+compare($r->hStrLen('myKey', 'a'), 4);
+compare($r->hStrLen('myKey', 'b'), 0);
+compare($r->hSet('myKey', 'b', 12), 1);
+compare($r->hStrLen('myKey', 'b'), 2);
+compare($r->hLen('myKey'), 2);
+$keys = $r->hKeys('myKey');
+sort($keys);
+compare($keys, array('a', 'b'));
+$values = $r->hVals('myKey');
+sort($values);
+compare($values, array('12', 'toto'));
+
+echo("hSet hDel multiple fields\n");
+$r->del('myKey');
+compare($r->rawCommand('HSET', 'myKey', 'a', '1', 'b', '2'), 2);
+compare($r->rawCommand('HSET', 'myKey', 'a', '3', 'c', '4', 'c', '5'), 1);
+compare_map($r->hGetAll('myKey'), array('a' => '3', 'b' => '2', 'c' => '5'));
+compare($r->hDel('myKey', 'a', 'b', 'd'), 2);
+compare_map($r->hGetAll('myKey'), array('c' => '5'));
+
+echo("hIncrByFloat\n");
+$r->del('myKey');
+compare($r->hIncrByFloat('myKey', 'a', 1.5), 1.5);
+compare($r->hIncrByFloat('myKey', 'a', 1.5), 3.0);
+compare($r->hGet('myKey', 'a'), '3');
+compare($r->hIncrBy('myKey', 'a', 2), 5);
+compare($r->hIncrByFloat('myKey', 'a', -0.25), 4.75);
+compare($r->hGet('myKey', 'a'), '4.75');
+compare($r->hSet('myKey', 'b', 'toto'), 1);
+compare($r->hIncrByFloat('myKey', 'b', 1), false);
+compare($r->rawCommand('HINCRBYFLOAT', 'myKey', 'c', '0.1'), '0.1');
+compare($r->rawCommand('HINCRBYFLOAT', 'myKey', 'c', '0.2'), '0.3');
+compare($r->hGet('myKey', 'c'), '0.3');
+
+echo("hScan\n");
+$r->del('myKey');
+compare($r->rawCommand('HSCAN', 'myKey', '0'), array('0', array()));
+compare($r->hSet('myKey', 'a', '1'), 1);
+compare($r->hSet('myKey', 'b', '2'), 1);
+$res = $r->rawCommand('HSCAN', 'myKey', '0');
+compare($res[0], '0');
+compare_map(array($res[1][0] => $res[1][1], $res[1][2] => $res[1][3]), array('a' => '1', 'b' => '2'));
+$r->del('myKey');
+$expected = array();
+for($i = 0; $i < 25; $i ++) {
+  compare($r->hSet('myKey', 'field'.$i, $i), 1);
+  $expected['field'.$i] = ''.$i;
+}
+$found = array();
+$cursor = '0';
+do {
+  $res = $r->rawCommand('HSCAN', 'myKey', $cursor, 'COUNT', '7');
+  $cursor = $res[0];
+  for($i = 0; $i < count($res[1]); $i += 2) {
+    $found[$res[1][$i]] = $res[1][$i + 1];
+  }
+} while ($cursor !== '0');
+compare_map($found, $expected);
+$res = $r->rawCommand('HSCAN', 'myKey', '0', 'MATCH', 'field1?', 'COUNT', '100');
+compare($res[0], '0');
+compare(count($res[1]), 20); // 10 fields and their values
+
 echo("hIncrBy\n");
 $r->del('myKey');
 compare($r->hIncrBy('myKey', 'a', 1), 1);
@@ -427,6 +939,17 @@ if (!isset($_ENV['USE_REAL_REDIS'])) {
   upper($r->ttl('myKey'), 100);
   lower($r->ttl('myKey'), 1000);
 
+  echo("pfAddEx pfMergeEx\n");
+  $r->del('myKey');
+  $r->del('myKey2');
+  compare($r->rawCommand('PFADDEX', 'myKey', '500', 'a', 'b'), 1);
+  upper($r->ttl('myKey'), 100);
+  lower($r->ttl('myKey'), 1000);
+  compare($r->rawCommand('PFMERGEEX', 'myKey2', '500', 'myKey'), 'OK');
+  compare($r->pfCount('myKey2'), 2);
+  upper($r->ttl('myKey2'), 100);
+  lower($r->ttl('myKey2'), 1000);
+
   echo("Batch\n");
 
   if (isset($_ENV['EXPANDED_MAP'])) {
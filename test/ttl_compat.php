@@ -0,0 +1,160 @@
+<?php
+
+// Runs the same commands on Aerodis and on a Redis server, and compares the
+// answers. Ttls are rounded to the second by Aerodis, so they are compared
+// with a tolerance.
+
+$aerodis = new Redis();
+$aerodis->connect('127.0.0.1', isset($_ENV['AERODIS_PORT']) ? $_ENV['AERODIS_PORT'] : 6379);
+
+$redis = new Redis();
+$redis->connect('127.0.0.1', isset($_ENV['REDIS_PORT']) ? $_ENV['REDIS_PORT'] : 6380);
+
+function dump($a) {
+  ob_start();
+  var_dump($a);
+  $aa = ob_get_contents();
+  ob_clean();
+  return trim($aa);
+}
+
+function run($r, $commands) {
+  $r->del('myKey');
+  $r->del('myKey2');
+  $res = array();
+  foreach($commands as $c) {
+    $res[] = call_user_func_array(array($r, 'rawCommand'), $c);
+  }
+  $r->del('myKey');
+  $r->del('myKey2');
+  return $res;
+}
+
+function same($command, $a, $b) {
+  if ($a === $b) {
+    return true;
+  }
+  if (!is_int($a) || !is_int($b) || $a < 0 || $b < 0) {
+    return false;
+  }
+  switch(strtoupper($command[0])) {
+    case 'TTL':
+    case 'EXPIRETIME':
+      return abs($a - $b) <= 1;
+    case 'PTTL':
+    case 'PEXPIRETIME':
+      return abs($a - $b) <= 1000;
+  }
+  return false;
+}
+
+function check($name, $commands) {
+  global $aerodis, $redis;
+  echo($name."\n");
+  $a = run($aerodis, $commands);
+  $b = run($redis, $commands);
+  foreach($commands as $i => $c) {
+    if (!same($c, $a[$i], $b[$i])) {
+      throw new Exception("Different answer for ".implode(' ', $c).": Aerodis <".dump($a[$i]).">, Redis <".dump($b[$i]).">");
+    }
+  }
+}
+
+check('missing key', array(
+  array('TTL', 'myKey'),
+  array('PTTL', 'myKey'),
+  array('EXPIRETIME', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('PERSIST', 'myKey'),
+));
+
+check('set', array(
+  array('SET', 'myKey', 'a'),
+  array('TTL', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('TTL', 'myKey'),
+  array('PTTL', 'myKey'),
+  array('SET', 'myKey', 'b'),
+  array('TTL', 'myKey'),
+  array('SETEX', 'myKey', '100', 'c'),
+  array('TTL', 'myKey'),
+  array('MSET', 'myKey', 'd'),
+  array('TTL', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('GETSET', 'myKey', 'e'),
+  array('TTL', 'myKey'),
+));
+
+check('string updates', array(
+  array('INCR', 'myKey'),
+  array('TTL', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('INCRBY', 'myKey', '2'),
+  array('TTL', 'myKey'),
+  array('INCRBYFLOAT', 'myKey', '0.5'),
+  array('TTL', 'myKey'),
+  array('APPEND', 'myKey2', 'a'),
+  array('TTL', 'myKey2'),
+  array('EXPIRE', 'myKey2', '100'),
+  array('APPEND', 'myKey2', 'b'),
+  array('TTL', 'myKey2'),
+  array('SETRANGE', 'myKey2', '1', 'c'),
+  array('TTL', 'myKey2'),
+  array('GETEX', 'myKey2', 'PERSIST'),
+  array('TTL', 'myKey2'),
+));
+
+check('list', array(
+  array('RPUSH', 'myKey', 'a'),
+  array('TTL', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('RPUSH', 'myKey', 'b'),
+  array('LPUSH', 'myKey', 'c'),
+  array('TTL', 'myKey'),
+  array('LPOP', 'myKey'),
+  array('TTL', 'myKey'),
+  array('LPUSH', 'myKey2', 'a'),
+  array('TTL', 'myKey2'),
+));
+
+check('hash', array(
+  array('HSET', 'myKey', 'a', '1'),
+  array('TTL', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('HSET', 'myKey', 'b', '2'),
+  array('TTL', 'myKey'),
+  array('HINCRBY', 'myKey', 'c', '1'),
+  array('TTL', 'myKey'),
+  array('HDEL', 'myKey', 'a'),
+  array('TTL', 'myKey'),
+  array('PERSIST', 'myKey'),
+  array('TTL', 'myKey'),
+  array('HINCRBY', 'myKey2', 'a', '1'),
+  array('TTL', 'myKey2'),
+));
+
+check('bitmap and hyperloglog', array(
+  array('SETBIT', 'myKey', '7', '1'),
+  array('TTL', 'myKey'),
+  array('EXPIRE', 'myKey', '100'),
+  array('SETBIT', 'myKey', '8', '1'),
+  array('TTL', 'myKey'),
+  array('PFADD', 'myKey2', 'a'),
+  array('TTL', 'myKey2'),
+  array('EXPIRE', 'myKey2', '100'),
+  array('PFADD', 'myKey2', 'b'),
+  array('TTL', 'myKey2'),
+));
+
+check('expire', array(
+  array('SET', 'myKey', 'a'),
+  array('EXPIRE', 'myKey', '100', 'XX'),
+  array('EXPIRE', 'myKey', '100', 'NX'),
+  array('EXPIRE', 'myKey', '200', 'GT'),
+  array('TTL', 'myKey'),
+  array('PEXPIRE', 'myKey', '50000'),
+  array('PTTL', 'myKey'),
+  array('EXPIRE', 'myKey', '-1'),
+  array('EXISTS', 'myKey'),
+  array('TTL', 'myKey'),
+));
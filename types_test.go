@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBitmap(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"GETBIT myKey 10", ":0"},
+			{"GETBIT unknown 0", ":0"},
+			{"SETBIT myKey 7 1", ":0"},
+			{"SETBIT myKey 7 1", ":1"},
+			{"SETBIT myKey 20 1", ":0"},
+			{"GETBIT myKey 7", ":1"},
+			{"GETBIT myKey 8", ":0"},
+			{"GETBIT myKey 1000", ":0"},
+			{"STRLEN myKey", ":3"},
+			{"GET myKey", `"\x01\x00\b"`},
+			{"BITCOUNT myKey", ":2"},
+			{"BITCOUNT myKey 1 -1", ":1"},
+			{"BITCOUNT myKey 0 7 BIT", ":1"},
+			{"BITCOUNT unknown", ":0"},
+			{"BITPOS myKey 1", ":7"},
+			{"BITPOS myKey 0", ":0"},
+			{"BITPOS myKey 1 1", ":20"},
+			{"BITPOS unknown 1", ":-1"},
+			{"BITPOS unknown 0", ":0"},
+		})
+		if res := c.do("SET", "myKey2", "\xff"); res != "+OK" {
+			t.Errorf("SET: got %s", res)
+		}
+		run(t, c, [][2]string{
+			{"BITPOS myKey2 0", ":8"},
+			{"BITPOS myKey2 0 0 -1", ":-1"},
+			{"BITOP AND myKey3 myKey myKey2", ":3"},
+			{"GET myKey3", `"\x01\x00\x00"`},
+			{"BITOP OR myKey3 myKey myKey2", ":3"},
+			{"GET myKey3", `"\xff\x00\b"`},
+			{"BITOP XOR myKey3 myKey myKey2", ":3"},
+			{"GET myKey3", `"\xfe\x00\b"`},
+			{"BITOP NOT myKey3 myKey2", ":1"},
+			{"GET myKey3", `"\x00"`},
+			{"BITOP AND myKey3 unknown", ":0"},
+			{"EXISTS myKey3", ":0"},
+			{"SET myKey2 5", "+OK"},
+			{"SETBIT myKey2 6 1", ":0"},
+			{"GET myKey2", `"7"`},
+		})
+	})
+}
+
+// Bitmaps starting like a compressed value are escaped.
+func TestBitmapEscaped(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		if res := c.do("SET", "myKey", "\x00AZC"); res != "+OK" {
+			t.Errorf("SET: got %s", res)
+		}
+		run(t, c, [][2]string{
+			{"SETBIT myKey 39 0", ":0"},
+			{"GET myKey", `"\x00AZC\x00"`},
+			{"BITCOUNT myKey", ":9"},
+			{"SETBIT myKey 47 1", ":0"},
+			{"GETBIT myKey 47", ":1"},
+			{"GET myKey", `"\x00AZC\x00\x01"`},
+			{"SETBIT myKey 47 0", ":1"},
+			{"BITOP OR myKey2 myKey", ":6"},
+			{"GET myKey2", `"\x00AZC\x00\x00"`},
+			{"STRLEN myKey2", ":6"},
+		})
+	})
+}
+
+func TestBitmapWrongType(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"RPUSH myKey a", ":1"},
+			{"SETBIT myKey 100 1", wrongType},
+			{"SETBIT myKey 1 1", wrongType},
+			{"BITCOUNT myKey", wrongType},
+			{"BITPOS myKey 1", wrongType},
+			{"BITOP AND myKey2 myKey", wrongType},
+			{"LRANGE myKey 0 -1", `["a"]`},
+		})
+	})
+}
+
+func TestHyperLogLog(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"PFADD myKey a b c", ":1"},
+			{"PFADD myKey a b", ":0"},
+			{"PFCOUNT myKey", ":3"},
+			{"PFCOUNT unknown", ":0"},
+			{"PFADD myKey2 c d", ":1"},
+			{"PFCOUNT myKey myKey2", ":4"},
+			{"PFCOUNT unknown myKey2", ":2"},
+			{"PFMERGE myKey3 myKey myKey2 unknown", "+OK"},
+			{"PFCOUNT myKey3", ":4"},
+			{"PFMERGE myKey", "+OK"},
+			{"PFCOUNT myKey", ":3"},
+			{"PFADD unknown", ":1"},
+			{"PFADD unknown", ":0"},
+			{"PFCOUNT unknown", ":0"},
+			{"PFADDEX myKey4 500 a b", ":1"},
+			{"TTL myKey4", ":500"},
+			{"PFMERGEEX myKey5 500 myKey4", "+OK"},
+			{"PFCOUNT myKey5", ":2"},
+			{"TTL myKey5", ":500"},
+			{"SET myKey6 a", "+OK"},
+			{"PFCOUNT myKey6", "-" + hllWrongTypeError},
+			{"PFMERGE myKey7 myKey6", "-" + hllWrongTypeError},
+		})
+		args := []string{"PFADD", "myKey"}
+		for i := 0; i < 1000; i++ {
+			args = append(args, fmt.Sprintf("element%d", i))
+		}
+		c.do(args...)
+		between(t, c, "PFCOUNT myKey", 970, 1030)
+	})
+}
+
+func TestGeo(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"GEOADD myKey 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", ":2"},
+			{"GEOADD myKey 13.361389 38.115556 Palermo", ":0"},
+			{"GEOADD myKey NX 13 38 Palermo 12.5 41.9 Rome", ":1"},
+			{"GEOADD myKey XX CH 12.496366 41.902782 Rome 0 0 Nowhere", ":1"},
+			{"GEOADD myKey XX NX 0 0 Nowhere", "-ERR XX and NX options at the same time are not compatible"},
+			{"GEOHASH myKey Palermo Catania unknown", `["sqc8b49rny0" "sqdtr74hyu0" nil]`},
+			{"GEODIST myKey Palermo Catania", `"166274.1516"`},
+			{"GEODIST myKey Palermo Catania km", `"166.2742"`},
+			{"GEODIST myKey Palermo unknown", "nil"},
+			{"GEOSEARCH myKey FROMLONLAT 15 37 BYRADIUS 200 km ASC", `["Catania" "Palermo"]`},
+			{"GEOSEARCH myKey FROMLONLAT 15 37 BYRADIUS 200 km DESC WITHDIST", `[["Palermo" "190.4424"] ["Catania" "56.4413"]]`},
+			{"GEOSEARCH myKey FROMMEMBER Palermo BYRADIUS 100 km COUNT 1", `["Palermo"]`},
+			{"GEOSEARCH myKey FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHDIST", `[["Catania" "56.4413"] ["Palermo" "190.4424"]]`},
+			{"GEOSEARCH myKey FROMLONLAT 15 37 BYBOX 100 100 km ASC", "[]"},
+			{"GEOSEARCH unknown FROMLONLAT 15 37 BYRADIUS 200 km", "[]"},
+		})
+		res := c.do("GEOPOS", "myKey", "Palermo", "unknown")
+		if !strings.HasPrefix(res, `[["13.36138`) || !strings.HasSuffix(res, ` nil]`) {
+			t.Errorf("GEOPOS: got %s", res)
+		}
+	})
+}
+
+func TestGeoWrongType(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"SET myKey a", "+OK"},
+			{"GEOADD myKey 13.361389 38.115556 Palermo", wrongType},
+			{"GEOPOS myKey Palermo", wrongType},
+			{"GET myKey", `"a"`},
+		})
+	})
+}
+
+func TestStreams(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"XADD myKey 1-1 a 1 b x", `"1-1"`},
+			{"XADD myKey 1-* a 2", `"1-2"`},
+			{"XADD myKey 5 a 3", `"5-0"`},
+			{"XADD myKey 4-1 a 4", "-ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+			{"XLEN myKey", ":3"},
+			{"XLEN unknown", ":0"},
+			{"XRANGE myKey - +", `[["1-1" ["a" "1" "b" "x"]] ["1-2" ["a" "2"]] ["5-0" ["a" "3"]]]`},
+			{"XRANGE myKey 1 1", `[["1-1" ["a" "1" "b" "x"]] ["1-2" ["a" "2"]]]`},
+			{"XRANGE myKey (1-1 + COUNT 1", `[["1-2" ["a" "2"]]]`},
+			{"XREVRANGE myKey + - COUNT 2", `[["5-0" ["a" "3"]] ["1-2" ["a" "2"]]]`},
+			{"XRANGE unknown - +", "[]"},
+			{"XDEL myKey 1-2 3-0", ":1"},
+			{"XTRIM myKey MAXLEN 1", ":1"},
+			{"XRANGE myKey - +", `[["5-0" ["a" "3"]]]`},
+			{"XADD myKey MAXLEN 1 6-0 a 5", `"6-0"`},
+			{"XRANGE myKey - +", `[["6-0" ["a" "5"]]]`},
+			{"XREAD STREAMS myKey unknown 0-0 0-0", `[["myKey" [["6-0" ["a" "5"]]]]]`},
+			{"XREAD STREAMS myKey 6-0", "nil"},
+			{"XREAD COUNT 1 BLOCK 100 STREAMS myKey $", "nil"},
+		})
+
+		// A blocked XREAD is woken up by XADD
+		c.send("XREAD", "BLOCK", "0", "STREAMS", "myKey", "$")
+		time.Sleep(50 * time.Millisecond)
+		c2 := s.connect(t)
+		defer c2.close()
+		run(t, c2, [][2]string{{"XADD myKey 7-0 a 6", `"7-0"`}})
+		if res := c.read(); res != `[["myKey" [["7-0" ["a" "6"]]]]]` {
+			t.Errorf("XREAD BLOCK: got %s", res)
+		}
+	})
+}
+
+// The count is applied from the start of the range, or from its end for
+// XREVRANGE, with and without bounds.
+func TestStreamsRangeCount(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		for i := 1; i <= 5; i++ {
+			run(t, c, [][2]string{{fmt.Sprintf("XADD myKey %d-0 a %d", i, i), fmt.Sprintf(`"%d-0"`, i)}})
+		}
+		run(t, c, [][2]string{
+			{"XRANGE myKey - + COUNT 2", `[["1-0" ["a" "1"]] ["2-0" ["a" "2"]]]`},
+			{"XRANGE myKey 2 + COUNT 2", `[["2-0" ["a" "2"]] ["3-0" ["a" "3"]]]`},
+			{"XRANGE myKey (2 4 COUNT 10", `[["3-0" ["a" "3"]] ["4-0" ["a" "4"]]]`},
+			{"XRANGE myKey 2 2 COUNT 2", `[["2-0" ["a" "2"]]]`},
+			{"XREVRANGE myKey + - COUNT 2", `[["5-0" ["a" "5"]] ["4-0" ["a" "4"]]]`},
+			{"XREVRANGE myKey 3 - COUNT 2", `[["3-0" ["a" "3"]] ["2-0" ["a" "2"]]]`},
+			{"XREVRANGE myKey 4 (2 COUNT 10", `[["4-0" ["a" "4"]] ["3-0" ["a" "3"]]]`},
+			{"XREVRANGE myKey 9 7 COUNT 2", `[]`},
+			{"XREAD COUNT 2 STREAMS myKey 3", `[["myKey" [["4-0" ["a" "4"]] ["5-0" ["a" "5"]]]]]`},
+		})
+	})
+}
+
+func TestStreamGroups(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *testServer, c *testClient) {
+		run(t, c, [][2]string{
+			{"XGROUP CREATE myKey g1 0", "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."},
+			{"XGROUP CREATE myKey g1 0 MKSTREAM", "+OK"},
+			{"XGROUP CREATE myKey g1 0", "-BUSYGROUP Consumer Group name already exists"},
+			{"XADD myKey 1-0 a 1", `"1-0"`},
+			{"XADD myKey 2-0 a 2", `"2-0"`},
+			{"XREADGROUP GROUP g1 c1 COUNT 1 STREAMS myKey >", `[["myKey" [["1-0" ["a" "1"]]]]]`},
+			{"XREADGROUP GROUP g1 c2 STREAMS myKey >", `[["myKey" [["2-0" ["a" "2"]]]]]`},
+			{"XREADGROUP GROUP g1 c2 STREAMS myKey >", "nil"},
+			{"XREADGROUP GROUP g1 c1 STREAMS myKey 0", `[["myKey" [["1-0" ["a" "1"]]]]]`},
+			{"XPENDING myKey g1", `[:2 "1-0" "2-0" [["c1" "1"] ["c2" "1"]]]`},
+			{"XACK myKey g1 1-0 3-0", ":1"},
+			{"XPENDING myKey g1", `[:1 "2-0" "2-0" [["c2" "1"]]]`},
+			{"XGROUP DESTROY myKey g1", ":1"},
+			{"XGROUP DESTROY myKey g1", ":0"},
+			{"XGROUP CREATE myKey g1 $", "+OK"},
+			{"XREADGROUP GROUP g1 c1 STREAMS myKey >", "nil"},
+			{"DEL myKey", ":1"},
+			{"XGROUP CREATE myKey g1 0 MKSTREAM", "+OK"},
+			{"XPENDING myKey g1", "[:0 nil nil nil]"},
+		})
+		res := c.do("XPENDING", "myKey", "g1", "-", "+", "10", "c2")
+		if res != "[]" {
+			t.Errorf("XPENDING: got %s", res)
+		}
+	})
+}
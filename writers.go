@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"log"
+	"math"
 	"strconv"
 
 	as "github.com/aerospike/aerospike-client-go"
@@ -21,6 +23,15 @@ func writeErr(wf io.Writer, errorPrefix string, s string, args [][]byte) error {
 	return write(wf, []byte("-ERR "+s+"\n"))
 }
 
+// Returned by the commands called on a key of another type. The error is
+// replied to the client, without closing the connection.
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Writes an error reply, without closing the connection
+func writeErrorReply(wf io.Writer, s string) error {
+	return writeLine(wf, "-ERR "+s)
+}
+
 func writeByteArray(wf io.Writer, buf []byte) error {
 	err := write(wf, []byte("$"+strconv.Itoa(len(buf))+"\r\n"))
 	if err != nil {
@@ -39,12 +50,16 @@ func writeArray(wf io.Writer, array []interface{}) error {
 			return writeLine(wf, "*0")
 		}
 	}
-	err := writeLine(wf, "*"+strconv.Itoa(len(array)))
+	bufs, err := decodeValues(array)
+	if err != nil {
+		return err
+	}
+	err = writeLine(wf, "*"+strconv.Itoa(len(bufs)))
 	if err != nil {
 		return err
 	}
-	for _, e := range array {
-		err := writeByteArray(wf, e.([]byte))
+	for _, buf := range bufs {
+		err := writeByteArray(wf, buf)
 		if err != nil {
 			return err
 		}
@@ -75,15 +90,42 @@ func writeLine(wf io.Writer, s string) error {
 	return nil
 }
 
-func writeValue(wf io.Writer, x interface{}) error {
+func decodeValue(x interface{}) ([]byte, error) {
 	switch x.(type) {
 	case int:
-		return writeByteArray(wf, []byte(strconv.Itoa(x.(int))))
+		return []byte(strconv.Itoa(x.(int))), nil
 	case string:
-		return writeByteArray(wf, []byte(x.(string)))
-	default:
-		return writeByteArray(wf, x.([]byte))
+		return []byte(x.(string)), nil
+	case float64:
+		return []byte(formatFloat(x.(float64))), nil
+	case as.HLLValue:
+		return []byte(x.(as.HLLValue)), nil
+	case []byte:
+		return decompress(x.([]byte))
+	}
+	return nil, errWrongType
+}
+
+// Decodes all the values of a reply before writing it, so an error is not
+// written in the middle of an array.
+func decodeValues(values []interface{}) ([][]byte, error) {
+	res := make([][]byte, len(values))
+	for i, x := range values {
+		buf, err := decodeValue(x)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = buf
 	}
+	return res, nil
+}
+
+func writeValue(wf io.Writer, x interface{}) error {
+	buf, err := decodeValue(x)
+	if err != nil {
+		return err
+	}
+	return writeByteArray(wf, buf)
 }
 
 func writeBin(wf io.Writer, rec *as.Record, binName string, nilValue string) error {
@@ -97,6 +139,17 @@ func writeBin(wf io.Writer, rec *as.Record, binName string, nilValue string) err
 	return writeValue(wf, x)
 }
 
+func writeBinLen(wf io.Writer, rec *as.Record, binName string) error {
+	if rec == nil || rec.Bins[binName] == nil {
+		return writeLine(wf, ":0")
+	}
+	buf, err := decodeValue(rec.Bins[binName])
+	if err != nil {
+		return err
+	}
+	return writeLine(wf, ":"+strconv.Itoa(len(buf)))
+}
+
 func writeBinInt(wf io.Writer, rec *as.Record, binName string) error {
 	return writeBinIntFull(wf, rec, binName, ":0", ":0")
 }
@@ -136,12 +189,39 @@ func writeArrayBin(wf io.Writer, res []*as.Record, binName string, keyBinName st
 	return nil
 }
 
+// Redis computes with a long double and formats 17 digits, so 0.1 + 0.2 is
+// 0.3. The rounding errors of the double computation are removed by keeping
+// the shortest of 15 or 16 significant digits which is within one unit in
+// the last place of the result.
+func formatFloat(f float64) string {
+	ulp := math.Nextafter(math.Abs(f), math.Inf(1)) - math.Abs(f)
+	for prec := 15; prec <= 16; prec++ {
+		rounded, err := strconv.ParseFloat(strconv.FormatFloat(f, 'g', prec, 64), 64)
+		if err == nil && math.Abs(rounded-f) <= ulp {
+			return strconv.FormatFloat(rounded, 'f', -1, 64)
+		}
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// In strict mode, a value is stored as an integer only when it is the
+// canonical decimal form of that integer, so "007" or "+5" are kept as is.
+// Values written before switching mode are read back by writeValue whatever
+// their representation. Values which are not integers are compressed if the
+// set is configured for it.
 func encode(ctx *context, buf []byte) interface{} {
+	if ctx.strictEncoding {
+		x, err := strconv.Atoi(string(buf))
+		if err == nil && strconv.Itoa(x) == string(buf) {
+			return x
+		}
+		return compress(ctx, buf)
+	}
 	if len(buf) < 10 {
 		x, err := strconv.Atoi(string(buf))
 		if err == nil {
 			return x
 		}
 	}
-	return buf
+	return compress(ctx, buf)
 }